@@ -1,6 +1,7 @@
 package clustermessage
 
 import (
+	"context"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -19,6 +20,12 @@ const (
 	TypeOnlineClients Type = "online_clients" // 用户在线列表消息
 	TypeHeart         Type = "heart"          // 心跳消息
 
+	TypeTap      Type = "tap"       // 管理端订阅实时消息
+	TypeTapStop  Type = "tap_stop"  // 管理端取消订阅,订阅到期时集群也会发送该消息
+	TypeTapEvent Type = "tap_event" // 推送给管理端的消息记录
+
+	TypeKick Type = "kick" // 管理端踢下线,广播到所有节点
+
 	// TypeReport        Type = "report"         // 用户端上报设备信息,该信息会保存到ws集群中
 )
 
@@ -99,6 +106,50 @@ type AffairMsg struct {
 	Type     Type        `json:"type,omitempty"`      // 消息类型
 	Source   *Source     `json:"source,omitempty"`    // WS集群附加Source,代表哪个用户发送
 	To       *To         `json:"to,omitempty"`        // 业务服务端附加To,代表发送给哪些用户
+
+	ReceiptID string  `json:"receipt_id,omitempty"` // 回执ID,不为空时每个节点都需要上报投递结果,由http同步推送时附加
+	Target    string  `json:"target,omitempty"`     // 目标服务端cid,不为空时只投递给该服务端,由WS集群按项目的分发方式附加
+	Header    *Header `json:"header,omitempty"`     // 消息头,由WS集群在http或ws入口附加,在各个队列中原样传递
+}
+
+// Header 消息在集群内流转时附加的信息,不会发送给用户端
+type Header struct {
+	MsgID    string            `json:"msg_id,omitempty"`    // 消息唯一ID,同时写入两个队列时消费端按该ID去重
+	IngestAt int64             `json:"ingest_at,omitempty"` // 消息进入集群的时间,unix微秒
+	Node     int64             `json:"node,omitempty"`      // 接收消息的节点
+	Trace    map[string]string `json:"trace,omitempty"`     // 链路追踪上下文,如 traceparent
+}
+
+// IngestTime 消息进入集群的时间,没有消息头时返回零值
+func (m *AffairMsg) IngestTime() time.Time {
+	if m.Header == nil || m.Header.IngestAt <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(m.Header.IngestAt)
+}
+
+// PID 消息所属的项目,推送消息为 To.PID,用户端发送的消息为 Source.PID
+func (m *AffairMsg) PID() string {
+	if m.To != nil && m.To.PID != "" {
+		return m.To.PID
+	}
+	if m.Source != nil {
+		return m.Source.PID
+	}
+	return ""
+}
+
+type msgContextKey struct{}
+
+// NewContext 返回携带当前处理的消息的ctx,写入连接时根据消息头统计端到端耗时
+func NewContext(ctx context.Context, msg *AffairMsg) context.Context {
+	return context.WithValue(ctx, msgContextKey{}, msg)
+}
+
+// FromContext 返回ctx中正在处理的消息
+func FromContext(ctx context.Context) (*AffairMsg, bool) {
+	msg, ok := ctx.Value(msgContextKey{}).(*AffairMsg)
+	return msg, ok && msg != nil
 }
 
 type Source struct {
@@ -128,9 +179,10 @@ func PackAffair(message *AffairMsg) ([]byte, error) {
 // 1. ws流中客户端请求时具有ack_id,则使用 NewAck 应答,否则不应答
 // 2. 连接成功,失败或没有ack_id的消息，使用 NewSuccessResp 或 NewErrorResp 应答
 type AckMsg struct {
-	AckID string `json:"ack_id"`
-	Msg   string `json:"msg"` // 提示信息
-	Code  int    `json:"code"`
+	AckID   string      `json:"ack_id"`
+	Msg     string      `json:"msg"` // 提示信息
+	Code    int         `json:"code"`
+	Payload interface{} `json:"payload,omitempty"` // 附加数据,如同步推送的投递结果
 }
 
 func ParseAck(bytes []byte) (ack *AckMsg, err error) {
@@ -145,6 +197,11 @@ func NewAck(ackID string) AckMsg {
 	}
 }
 
+// NewErrorAck 请求处理失败时的应答,携带请求的ack_id
+func NewErrorAck(ackID string, msg string) AckMsg {
+	return newResp(ackID, 0, msg)
+}
+
 func NewErrorResp(msg string) AckMsg {
 	return newResp("", 0, msg)
 }
@@ -156,6 +213,13 @@ func NewSuccessResp(msg ...string) AckMsg {
 	return newResp("", 1, "success")
 }
 
+// NewSuccessRespWithPayload 携带附加数据的成功应答
+func NewSuccessRespWithPayload(payload interface{}) AckMsg {
+	resp := newResp("", 1, "success")
+	resp.Payload = payload
+	return resp
+}
+
 func newResp(ackID string, code int, msg string) AckMsg {
 	return AckMsg{
 		AckID: ackID,
@@ -0,0 +1,72 @@
+package clustermessage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAffairHeaderRoundTrip(t *testing.T) {
+	now := time.Now()
+	msg := &AffairMsg{
+		Type:    TypePush,
+		Payload: "hello",
+		To:      &To{PID: "p1"},
+		Header: &Header{
+			MsgID:    "1",
+			IngestAt: now.UnixMicro(),
+			Node:     3,
+			Trace:    map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		},
+	}
+	data, err := PackAffair(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseAffair(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Header, msg.Header) {
+		t.Fatalf("unexpected header:%+v", parsed.Header)
+	}
+	if !parsed.IngestTime().Equal(time.UnixMicro(now.UnixMicro())) {
+		t.Fatalf("unexpected ingest time:%v", parsed.IngestTime())
+	}
+
+	old, err := ParseAffair([]byte(`{"type":"push","payload":"hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Header != nil || !old.IngestTime().IsZero() {
+		t.Fatalf("message without header should have zero ingest time")
+	}
+}
+
+func TestMessageContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Fatal("empty context should not carry message")
+	}
+	msg := &AffairMsg{Type: TypeRequest}
+	got, ok := FromContext(NewContext(context.Background(), msg))
+	if !ok || got != msg {
+		t.Fatalf("unexpected message from context:%v", got)
+	}
+}
+
+func TestAffairPID(t *testing.T) {
+	cases := []struct {
+		msg  *AffairMsg
+		want string
+	}{
+		{&AffairMsg{}, ""},
+		{&AffairMsg{To: &To{PID: "p1"}, Source: &Source{PID: "p2"}}, "p1"},
+		{&AffairMsg{To: &To{}, Source: &Source{PID: "p2"}}, "p2"},
+	}
+	for _, c := range cases {
+		if got := c.msg.PID(); got != c.want {
+			t.Fatalf("pid of %+v = %q, want %q", c.msg, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package clustermessage
+
+import "sort"
+
+// Receipt 节点处理同步推送消息后上报的投递回执
+type Receipt struct {
+	ReceiptID string              `json:"receipt_id"` // 同步推送消息的回执ID
+	Node      int64               `json:"node"`       // 上报的节点
+	Delivered map[string][]string `json:"delivered"`  // 本节点成功进入发送队列的连接 key:uid value:cids
+}
+
+// PushResult 同步推送时汇总所有节点回执后返回给业务系统的结果
+type PushResult struct {
+	Delivered map[string][]string `json:"delivered"` // 投递成功的连接 key:uid value:cids
+	Offline   []string            `json:"offline"`   // 指定的uid中没有任何连接投递成功的uid
+	Nodes     int                 `json:"nodes"`     // 等待回执的节点数量
+	Reported  int                 `json:"reported"`  // 在等待时间内上报回执的节点数量
+	Timeout   bool                `json:"timeout"`   // 是否因为超时而没有收齐回执
+}
+
+// ParseReceipt 解析节点回执
+func ParseReceipt(bytes []byte) (receipt *Receipt, err error) {
+	receipt = &Receipt{}
+	err = json.Unmarshal(bytes, receipt)
+	return
+}
+
+// PackReceipt 序列化节点回执
+func PackReceipt(receipt *Receipt) ([]byte, error) {
+	return json.Marshal(receipt)
+}
+
+// MergeReceipts 合并各节点回执,uids 为推送时指定的用户,用于计算不在线的用户
+func MergeReceipts(uids []string, nodes int, receipts []*Receipt) *PushResult {
+	result := &PushResult{
+		Delivered: make(map[string][]string),
+		Offline:   make([]string, 0),
+		Nodes:     nodes,
+		Reported:  len(receipts),
+		Timeout:   len(receipts) < nodes,
+	}
+	for _, receipt := range receipts {
+		if receipt == nil {
+			continue
+		}
+		for uid, cids := range receipt.Delivered {
+			result.Delivered[uid] = append(result.Delivered[uid], cids...)
+		}
+	}
+	for uid := range result.Delivered {
+		sort.Strings(result.Delivered[uid])
+	}
+	for _, uid := range uids {
+		if len(result.Delivered[uid]) == 0 {
+			result.Offline = append(result.Offline, uid)
+		}
+	}
+	return result
+}
@@ -0,0 +1,45 @@
+package clustermessage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeReceipts(t *testing.T) {
+	receipts := []*Receipt{
+		{Node: 1, Delivered: map[string][]string{"u1": {"c2"}, "u2": {"c3"}}},
+		{Node: 2, Delivered: map[string][]string{"u1": {"c1"}}},
+		{Node: 3, Delivered: map[string][]string{}},
+	}
+	result := MergeReceipts([]string{"u1", "u2", "u3"}, 3, receipts)
+
+	if !reflect.DeepEqual(result.Delivered["u1"], []string{"c1", "c2"}) {
+		t.Fatalf("unexpected u1 delivered:%v", result.Delivered["u1"])
+	}
+	if !reflect.DeepEqual(result.Offline, []string{"u3"}) {
+		t.Fatalf("unexpected offline:%v", result.Offline)
+	}
+	if result.Reported != 3 || result.Timeout {
+		t.Fatalf("unexpected reported:%d timeout:%v", result.Reported, result.Timeout)
+	}
+
+	result = MergeReceipts([]string{"u1"}, 2, receipts[2:])
+	if !result.Timeout || !reflect.DeepEqual(result.Offline, []string{"u1"}) {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+}
+
+func TestPackReceipt(t *testing.T) {
+	receipt := &Receipt{Node: 7, Delivered: map[string][]string{"u1": {"c1"}}}
+	bytes, err := PackReceipt(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseReceipt(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, receipt) {
+		t.Fatalf("unexpected receipt:%+v", parsed)
+	}
+}
@@ -30,9 +30,16 @@ type Values struct {
 	Kafka      Kafka      `mapstructure:"kafka"`
 	Jwt        Jwt        `mapstructure:"jwt"`
 	Sentry     Sentry     `mapstructure:"sentry"`
+	Trace      Trace      `mapstructure:"trace"`
 	Prometheus Prometheus `mapstructure:"prometheus"`
 	Pprof      Pprof      `mapstructure:"pprof"`
 	Swagger    Swagger    `mapstructure:"swagger"`
+	Webhook    Webhook    `mapstructure:"webhook"`
+	ConnEvent  ConnEvent  `mapstructure:"conn_event"`
+	Tap        Tap        `mapstructure:"tap"`
+
+	ServerBuffer   ServerBuffer   `mapstructure:"server_buffer"`
+	ServerDispatch ServerDispatch `mapstructure:"server_dispatch"`
 }
 
 type Router struct {
@@ -46,34 +53,135 @@ type WsServer struct {
 }
 
 type HttpServer struct {
-	Port int `mapstructure:"port"`
+	Port              int `mapstructure:"port"`
+	MaxBodySize       int `mapstructure:"max_body_size"`        // 请求体最大大小,单位MB,为0时使用默认的8MB,不包括流式推送接口
+	StreamMaxBodySize int `mapstructure:"stream_max_body_size"` // 流式推送接口请求体最大大小,单位MB,为0时使用默认的1024MB
+	StreamReadTimeout int `mapstructure:"stream_read_timeout"`  // 流式推送接口读取请求体的超时时间,单位秒,为0时使用默认的600秒
 }
 type Queue struct {
-	Use   string `mapstructure:"use"`
-	Redis Redis  `mapstructure:"redis"`
-	Kafka Kafka  `mapstructure:"kafka"`
+	Use         string       `mapstructure:"use"`
+	Redis       Redis        `mapstructure:"redis"`
+	RedisShards int          `mapstructure:"redis_shards"` // redis队列按pid哈希拆分的stream数量,同一项目的消息在同一分片内保持顺序,为1时使用原有的单个stream
+	Kafka       Kafka        `mapstructure:"kafka"`
+	Nats        Nats         `mapstructure:"nats"`
+	Resume      Resume       `mapstructure:"resume"`
+	Publish     QueuePublish `mapstructure:"publish"`
+	Spill       QueueSpill   `mapstructure:"spill"`
+	DeadLetter  DeadLetter   `mapstructure:"dead_letter"`
+	Dual        DualQueue    `mapstructure:"dual"`
+
+	Memory MemoryQueue `mapstructure:"memory"`
+}
+
+// QueuePublish 本地发布缓冲区满时的处理策略
+type QueuePublish struct {
+	Policy      string `mapstructure:"policy"`        // block:一直等待 drop:等待超时后丢弃并返回错误 spill:写入溢出缓冲区
+	TimeoutMs   int    `mapstructure:"timeout_ms"`    // drop策略等待的最长时间,单位毫秒
+	SpillMaxLen int    `mapstructure:"spill_max_len"` // 溢出缓冲区最多保存的消息数量
+}
+
+// QueueSpill redis不可用时写入失败的消息保存到本地文件,恢复后按顺序重新写入
+type QueueSpill struct {
+	Enable bool   `mapstructure:"enable"`
+	Dir    string `mapstructure:"dir"`     // 保存目录,每个节点、每个分片使用单独的子目录
+	MaxMB  int    `mapstructure:"max_mb"`  // 每个分片最多占用的磁盘空间,单位MB,超过时丢弃最早的消息
+	MaxAge int    `mapstructure:"max_age"` // 消息最长保存时间,单位秒,超过后不再重新写入
+}
+
+// DualQueue 迁移队列时同时写入两个队列,消费来源可以在运行时切换
+type DualQueue struct {
+	Primary    string `mapstructure:"primary"`     // 主队列类型,写入失败时返回错误
+	Secondary  string `mapstructure:"secondary"`   // 从队列类型,写入失败只记录日志
+	Consume    string `mapstructure:"consume"`     // 启动时的消费来源 primary,secondary,both
+	DedupeSize int    `mapstructure:"dedupe_size"` // 按消息ID去重时保存的最近消息数量
+}
+
+// DeadLetter 无法解析、没有处理函数或处理时panic的队列消息写入死信,可以通过接口查看和重新投递
+type DeadLetter struct {
+	Enable bool  `mapstructure:"enable"`
+	MaxLen int64 `mapstructure:"max_len"` // 死信最大保存数量
+}
+
+// Resume redis队列重启或重连后从上次处理的位置继续消费
+type Resume struct {
+	Enable         bool           `mapstructure:"enable"`
+	MaxAge         int            `mapstructure:"max_age"`          // 最多回溯的时间,单位秒,超过该时间的消息不再消费
+	SaveIntervalMs int            `mapstructure:"save_interval_ms"` // 保存消费位置的间隔,单位毫秒
+	Freshness      map[string]int `mapstructure:"freshness"`        // 各消息类型的有效期,单位毫秒,超过有效期的消息直接跳过,未配置或为0时不限制 key:消息类型
+}
+
+// QueueMemory 内存队列,只能单节点部署
+const QueueMemory = "memory"
+
+// MemoryQueue 使用内存队列时的配置,此时为单节点部署,不依赖redis等外部服务
+type MemoryQueue struct {
+	Size     int      `mapstructure:"size"`     // 队列容量
+	Projects []string `mapstructure:"projects"` // 允许连接的项目,单节点部署时没有redis中的项目列表,为空时不校验项目
+}
+
+// Nats 使用 nats jetstream 作为队列时的配置
+type Nats struct {
+	URL      string `mapstructure:"url"`      // nats地址,多个地址使用逗号分隔
+	Stream   string `mapstructure:"stream"`   // jetstream 名称
+	MaxAge   int    `mapstructure:"max_age"`  // 消息在stream中保留的时间,单位秒
+	Replicas int    `mapstructure:"replicas"` // stream副本数量
+	Durable  bool   `mapstructure:"durable"`  // 是否使用持久化消费者,开启后节点重启会继续消费重启期间的消息
+
+	InactiveThreshold int `mapstructure:"inactive_threshold"` // 持久化消费者没有订阅超过该时间后删除,单位秒,为0时使用默认的86400秒
 }
 
 type Log struct {
-	Path       string `mapstructure:"path"`
-	Print      bool   `mapstructure:"print"`
-	Level      string `mapstructure:"level"`
-	MaxAge     int    `mapstructure:"max_age"`
-	MaxSize    int    `mapstructure:"max_size"`
-	MaxBackups int    `mapstructure:"max_backups"`
-	Compress   bool   `mapstructure:"compress"`
+	Path           string            `mapstructure:"path"`
+	Print          bool              `mapstructure:"print"`
+	Level          string            `mapstructure:"level"`
+	Modules        map[string]string `mapstructure:"modules"` // 单独配置模块的级别,如 queue: debug
+	MaxAge         int               `mapstructure:"max_age"`
+	MaxSize        int               `mapstructure:"max_size"`
+	MaxBackups     int               `mapstructure:"max_backups"`
+	Compress       bool              `mapstructure:"compress"`
+	Rotate         string            `mapstructure:"rotate"`          // 切割方式 builtin,external
+	RotateInterval int               `mapstructure:"rotate_interval"` // 按时间切割的间隔,单位小时,为0时不按时间切割
 }
 
+const (
+	RedisModeSingle   = "single"   // 单实例
+	RedisModeSentinel = "sentinel" // 哨兵,主从切换后自动连接新的主节点
+	RedisModeCluster  = "cluster"  // redis cluster,不支持db
+)
+
 type Redis struct {
-	Addr     string `mapstructure:"addr"`
+	Mode     string `mapstructure:"mode"` // single,sentinel,cluster 为空时为single
+	Addr     string `mapstructure:"addr"` // cluster模式下多个地址使用逗号分隔
 	User     string `mapstructure:"user"`
 	Password string `mapstructure:"password"`
 	DB       int    `mapstructure:"db"`
+
+	MasterName       string `mapstructure:"master_name"`       // 哨兵模式下主节点的名称
+	SentinelAddrs    string `mapstructure:"sentinel_addrs"`    // 哨兵地址,多个地址使用逗号分隔
+	SentinelPassword string `mapstructure:"sentinel_password"` // 哨兵的密码,为空时不认证
 }
 
 type Kafka struct {
-	Broker  string `mapstructure:"broker"`
-	Version string `mapstructure:"version"`
+	Broker        string    `mapstructure:"broker"`         // 多个地址使用逗号分隔
+	Version       string    `mapstructure:"version"`        // kafka版本,如 3.2.0
+	InitialOffset string    `mapstructure:"initial_offset"` // 新节点的消费组从哪里开始消费 newest,oldest,为newest时不会把历史消息推送给在线用户
+	SASL          KafkaSASL `mapstructure:"sasl"`
+	TLS           KafkaTLS  `mapstructure:"tls"`
+}
+
+type KafkaSASL struct {
+	Enable    bool   `mapstructure:"enable"`
+	Mechanism string `mapstructure:"mechanism"` // PLAIN,SCRAM-SHA-256,SCRAM-SHA-512
+	User      string `mapstructure:"user"`
+	Password  string `mapstructure:"password"`
+}
+
+type KafkaTLS struct {
+	Enable             bool   `mapstructure:"enable"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
 }
 
 type Jwt struct {
@@ -85,10 +193,19 @@ type Sentry struct {
 	TracesSampleRate float64 `mapstructure:"traces_sample_rate"`
 }
 
+type Trace struct {
+	Endpoint    string  `mapstructure:"endpoint"`     // OTLP http 接收地址,如 localhost:4318,为空时不启用链路追踪
+	Insecure    bool    `mapstructure:"insecure"`     // 是否使用http而不是https
+	SampleRate  float64 `mapstructure:"sample_rate"`  // 采样率,(0,1],为0或者未配置时全部采样,上游已经采样的链路总是继续采样
+	ServiceName string  `mapstructure:"service_name"` // 为空时为 ws-cluster
+}
+
 type Prometheus struct {
-	Path   string `mapstructure:"path"`
-	Addr   string `mapstructure:"addr"`
-	Enable bool   `mapstructure:"enable"`
+	Path         string   `mapstructure:"path"`
+	Addr         string   `mapstructure:"addr"`
+	Enable       bool     `mapstructure:"enable"`
+	PIDTopN      int      `mapstructure:"pid_top_n"`     // 项目维度的指标中按连接数单独统计的项目数量,其他项目合并为 other
+	PIDAllowlist []string `mapstructure:"pid_allowlist"` // 总是单独统计的项目
 }
 
 type Pprof struct {
@@ -102,6 +219,74 @@ type Swagger struct {
 	Port   int    `mapstructure:"port"`
 }
 
+type Webhook struct {
+	Enable           bool             `mapstructure:"enable"`
+	Workers          int              `mapstructure:"workers"`             // 投递协程数量
+	QueueSize        int              `mapstructure:"queue_size"`          // 本地待投递事件的缓冲数量,超过后丢弃
+	TimeoutMs        int              `mapstructure:"timeout_ms"`          // 单次投递超时时间
+	MaxRetries       int              `mapstructure:"max_retries"`         // 最大投递次数,超过后写入死信
+	DeadLetterMaxLen int64            `mapstructure:"dead_letter_max_len"` // 死信最大保存数量
+	Projects         []WebhookProject `mapstructure:"projects"`
+}
+
+// ConnEvent 连接的建立、断开、踢下线、空闲超时事件,写入文件或队列的topic
+type ConnEvent struct {
+	Enable    bool           `mapstructure:"enable"`
+	QueueSize int            `mapstructure:"queue_size"` // 本地待写入事件的缓冲数量,超过后丢弃
+	File      ConnEventFile  `mapstructure:"file"`
+	Queue     ConnEventQueue `mapstructure:"queue"`
+}
+
+type ConnEventFile struct {
+	Enable     bool   `mapstructure:"enable"`
+	Path       string `mapstructure:"path"`        // 每行一个JSON事件
+	MaxSize    int    `mapstructure:"max_size"`    // 单个文件的大小,单位MB,超过后滚动
+	MaxBackups int    `mapstructure:"max_backups"` // 保留的旧文件数量
+	MaxAge     int    `mapstructure:"max_age"`     // 旧文件保留的天数
+	Compress   bool   `mapstructure:"compress"`
+}
+
+type ConnEventQueue struct {
+	Enable bool   `mapstructure:"enable"`
+	Topic  string `mapstructure:"topic"`   // 按 queue.use 写入kafka的topic,nats的stream或redis的stream
+	MaxLen int64  `mapstructure:"max_len"` // 写入redis stream时保留的最大长度
+}
+
+// Tap 管理端通过ws连接订阅实时消息,按pid,uid,cid,消息类型过滤,采样并脱敏后推送,可以看到消息在各个节点上经过的位置和耗时
+type Tap struct {
+	Enable       bool     `mapstructure:"enable"`
+	TTL          int      `mapstructure:"ttl"`           // 单次订阅的最长时间,单位秒
+	MaxRate      int      `mapstructure:"max_rate"`      // 每个订阅在每个节点上每秒最多推送的消息数
+	PayloadLimit int      `mapstructure:"payload_limit"` // payload超过该字节数时截断
+	RedactFields []string `mapstructure:"redact_fields"` // payload中需要脱敏的字段,不区分大小写
+}
+
+type WebhookProject struct {
+	PID    string   `mapstructure:"pid"`
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"` // 签名密钥
+	Events []string `mapstructure:"events"` // connect, disconnect, request,为空时投递所有事件
+}
+
+// ServerBuffer 项目的业务服务端都不在线时,用户请求的处理策略
+type ServerBuffer struct {
+	Policy   string            `mapstructure:"policy"`   // 默认策略 none:直接丢弃(原有行为) buffer:缓存到redis,服务端重连后按顺序投递 reject:返回服务不可用
+	MaxLen   int64             `mapstructure:"max_len"`  // 每个项目最多缓存的请求数量
+	TTL      int               `mapstructure:"ttl"`      // 缓存请求的有效期,单位秒
+	Projects map[string]string `mapstructure:"projects"` // 项目单独的策略 key:pid value:policy
+}
+
+// ServerDispatch 用户消息在项目的多个业务服务端之间的分发方式
+type ServerDispatch struct {
+	Mode     string            `mapstructure:"mode"`     // 默认方式 broadcast:发送给所有服务端(原有行为) round_robin:轮询 hash:按uid一致性哈希,同一用户固定到同一服务端
+	Projects map[string]string `mapstructure:"projects"` // 项目单独的分发方式 key:pid value:mode
+}
+
+// Standalone 是否为不依赖redis的单节点部署
+func (v *Values) Standalone() bool {
+	return v.Queue.Use == QueueMemory
+}
+
 func NewViperConfig(configFullPath ...string) Config {
 
 	c := &viperConfig{}
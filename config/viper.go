@@ -23,7 +23,7 @@ func (c *viperConfig) load() Config {
 	pflag.Int("ws_port", 8084, "set ws server port")
 	pflag.Int("http_port", 8085, "set http server port")
 	pflag.String("router", "", "set router address")
-	pflag.String("queue", "redis", "set queue type, options:redis,kafka")
+	pflag.String("queue", "redis", "set queue type, options:redis,redis_group,kafka,nats,memory,dual")
 
 	pflag.Parse()
 
@@ -9,19 +9,33 @@ import (
 var DefaultChecking *Checking = NewChecking()
 
 type Checking struct {
-	pids map[string]struct{}
-	mu   deadlock.RWMutex
-	opts *Options
+	pids      map[string]struct{}
+	mu        deadlock.RWMutex
+	opts      *Options
+	allowsAll bool // 单节点部署且没有配置项目列表时不校验项目
 }
 
 func NewChecking(opts ...Option) *Checking {
 	opt := NewOptions(opts...)
 	c := &Checking{opts: opt}
+	// 单节点部署没有redis,使用配置中的项目列表
+	if opt.Config.Values().Standalone() {
+		projects := opt.Config.Values().Queue.Memory.Projects
+		c.pids = make(map[string]struct{}, len(projects))
+		for _, pid := range projects {
+			c.pids[pid] = struct{}{}
+		}
+		c.allowsAll = len(projects) == 0
+		return c
+	}
 	go c.infiniteGetByRedis()
 	return c
 }
 
 func (c *Checking) IsExist(pid string) bool {
+	if c.allowsAll {
+		return true
+	}
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	_, ok := c.pids[pid]
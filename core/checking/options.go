@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/mtgnorton/ws-cluster/config"
 	"github.com/mtgnorton/ws-cluster/shared"
 
 	"github.com/redis/go-redis/v9"
@@ -12,7 +13,8 @@ import (
 type Options struct {
 	Ctx      context.Context
 	Interval time.Duration
-	Redis    *redis.Client
+	Redis    redis.UniversalClient
+	Config   config.Config
 }
 
 func NewOptions(opts ...Option) *Options {
@@ -20,6 +22,7 @@ func NewOptions(opts ...Option) *Options {
 		Ctx:      context.Background(),
 		Interval: 10 * time.Second,
 		Redis:    shared.GetRedis(),
+		Config:   config.DefaultConfig,
 	}
 	for _, o := range opts {
 		o(opt)
@@ -41,8 +44,14 @@ func WithInterval(interval time.Duration) Option {
 	}
 }
 
-func WithRedis(redis *redis.Client) Option {
+func WithRedis(redis redis.UniversalClient) Option {
 	return func(o *Options) {
 		o.Redis = redis
 	}
 }
+
+func WithConfig(c config.Config) Option {
+	return func(o *Options) {
+		o.Config = c
+	}
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"time"
 )
 
 // Status 客户端状态,正常或者关闭
@@ -34,16 +35,42 @@ func (c CType) String() string {
 	}
 }
 
+// 连接关闭的原因
+const (
+	CloseReasonPeer        = "peer"         // 读取连接失败,通常是对端关闭了连接
+	CloseReasonWriteError  = "write_error"  // 写入连接失败
+	CloseReasonIdleTimeout = "idle_timeout" // 超过时间没有交互
+	CloseReasonKick        = "kick"         // 被管理员踢下线
+)
+
+// Stats 连接建立以来的收发统计
+type Stats struct {
+	ConnectedAt time.Time
+	BytesIn     int64
+	BytesOut    int64
+	MsgsIn      int64
+	MsgsOut     int64
+	QueueLen    int // 发送队列中等待写入的消息数量,连接关闭后为0
+	QueueCap    int
+	Drops       int64  // 发送队列已满丢弃的消息数量
+	CloseReason string // 连接未关闭时为空
+}
+
 type Client interface {
 	Init(opts ...Option)
 	Options() Options
 	//Read(ctx context.Context) (message *wsmessage.Req, isTerminate bool, err error)
-	// message 直接为golang类型
-	Send(ctx context.Context, message interface{})
+	// message 直接为golang类型,ok 表示消息是否成功进入发送队列
+	Send(ctx context.Context, message interface{}) (ok bool)
 	Close()
+	// CloseWithReason 关闭连接并记录原因,只记录第一次关闭的原因
+	CloseWithReason(reason string)
 	Status() Status
 	UpdateInteractTime()
 	GetInteractTime() int64
+	// AddInbound 记录从连接读取的一条消息
+	AddInbound(size int)
+	Stats() Stats
 	GetIDs() (cid string, uid string, pid string)
 	GetCID() string
 	GetUID() string
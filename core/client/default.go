@@ -2,22 +2,31 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/logger/logfield"
 	"github.com/mtgnorton/ws-cluster/shared"
 	"github.com/mtgnorton/ws-cluster/shared/kit"
 	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type outboundMessage struct {
 	payload    interface{}
 	enqueuedAt time.Time
+	ingestAt   time.Time // 消息进入集群的时间,为零值时不统计端到端耗时
+	msgType    string
+	spanCtx    trace.SpanContext // 投递消息的span,无效时不记录写入的span
 }
 
 type defaultClient struct {
@@ -34,6 +43,13 @@ type defaultClient struct {
 	lastSlowLogAt    atomic.Int64
 	lastDropLogAt    atomic.Int64
 	status           atomic.Int32
+	connectedAt      time.Time
+	bytesIn          atomic.Int64
+	bytesOut         atomic.Int64
+	msgsIn           atomic.Int64
+	msgsOut          atomic.Int64
+	drops            atomic.Int64
+	closeReason      atomic.Pointer[string]
 	sync.RWMutex
 }
 
@@ -56,7 +72,7 @@ func (c *defaultClient) Options() Options {
 //	return
 //}
 
-func (c *defaultClient) Send(ctx context.Context, message interface{}) {
+func (c *defaultClient) Send(ctx context.Context, message interface{}) (ok bool) {
 	defer func() {
 		if r := recover(); r != nil {
 			c.opts.logger.Warnf(ctx, "PANIC client:%s,send message panic,message is %v,panic is:%v", c, message, r)
@@ -73,19 +89,33 @@ func (c *defaultClient) Send(ctx context.Context, message interface{}) {
 		return
 	}
 
-	select {
-	case c.messageChan <- &outboundMessage{
+	outbound := &outboundMessage{
 		payload:    message,
 		enqueuedAt: time.Now(),
-	}:
+	}
+	if msg, ok := clustermessage.FromContext(ctx); ok {
+		outbound.ingestAt, outbound.msgType = msg.IngestTime(), string(msg.Type)
+	}
+	outbound.spanCtx = trace.SpanContextFromContext(ctx)
+	select {
+	case c.messageChan <- outbound:
+		ok = true
 	default:
-		_ = wsprometheus.DefaultPrometheus.GetAdd(wsprometheus.MetricClientSendDrop, c.metricLabels, 1)
+		c.drops.Add(1)
+		p := wsprometheus.DefaultPrometheus
+		_ = p.GetAdd(wsprometheus.MetricClientSendDrop, c.metricLabels, 1)
+		_ = p.GetAdd(wsprometheus.MetricProjectDrop, []string{c.metricLabels[0], c.metricLabels[1], p.PIDLabel(c.PID), "client_send"}, 1)
 		if kit.AllowByInterval(&c.lastDropLogAt, 2*time.Second) {
 			c.opts.logger.Warnf(ctx, "client:%s send queue full,dropped,len=%d,cap=%d", c.ID, len(c.messageChan), cap(c.messageChan))
 		}
 	}
 	c.RUnlock()
+	return
+}
 
+func (c *defaultClient) CloseWithReason(reason string) {
+	c.closeReason.CompareAndSwap(nil, &reason)
+	c.Close()
 }
 
 func (c *defaultClient) Close() {
@@ -119,6 +149,31 @@ func (c *defaultClient) GetInteractTime() int64 {
 	return c.lastInteractTime.Load()
 }
 
+func (c *defaultClient) AddInbound(size int) {
+	c.msgsIn.Add(1)
+	c.bytesIn.Add(int64(size))
+}
+
+func (c *defaultClient) Stats() Stats {
+	stats := Stats{
+		ConnectedAt: c.connectedAt,
+		BytesIn:     c.bytesIn.Load(),
+		BytesOut:    c.bytesOut.Load(),
+		MsgsIn:      c.msgsIn.Load(),
+		MsgsOut:     c.msgsOut.Load(),
+		Drops:       c.drops.Load(),
+	}
+	c.RLock()
+	if c.messageChan != nil {
+		stats.QueueLen, stats.QueueCap = len(c.messageChan), cap(c.messageChan)
+	}
+	c.RUnlock()
+	if reason := c.closeReason.Load(); reason != nil {
+		stats.CloseReason = *reason
+	}
+	return stats
+}
+
 func (c *defaultClient) GetIDs() (id string, uid string, pid string) {
 	return c.ID, c.UID, c.PID
 }
@@ -173,23 +228,49 @@ func (c *defaultClient) sendLoop(ctx context.Context) {
 			}
 
 			writeBegin := time.Now()
-			if err := c.socket.WriteJSON(message.payload); err != nil {
+			n, err := c.write(ctx, message)
+			if err != nil {
 				c.opts.logger.Debugf(ctx, "client:%s send message error:%v", c.ID, err)
-				c.Close()
+				c.CloseWithReason(CloseReasonWriteError)
 				return
 			}
+			c.msgsOut.Add(1)
+			c.bytesOut.Add(int64(n))
 			writeMs := float64(time.Since(writeBegin).Microseconds()) / 1000.0
-			_ = wsprometheus.DefaultPrometheus.GetObserve(wsprometheus.MetricClientWriteDuration, c.metricLabels, writeMs)
+			p := wsprometheus.DefaultPrometheus
+			pidLabel := p.PIDLabel(c.PID)
+			_ = p.GetObserve(wsprometheus.MetricClientWriteDuration, c.metricLabels, writeMs)
+			_ = p.GetAdd(wsprometheus.MetricProjectBytes, []string{c.metricLabels[0], c.metricLabels[1], pidLabel, "out"}, float64(n))
 			if writeMs >= 200 && kit.AllowByInterval(&c.lastSlowLogAt, 2*time.Second) {
 				c.opts.logger.Warnf(ctx, "client:%s websocket write slow=%0.2fms,type=%s,pid=%s,message=%s", c.ID, writeMs, c.cType, c.PID, kit.LogSnippet(message.payload, 240))
 			}
+			if !message.ingestAt.IsZero() {
+				e2eMs := float64(time.Since(message.ingestAt).Microseconds()) / 1000.0
+				_ = p.GetObserve(wsprometheus.MetricMessageE2EDuration, []string{c.metricLabels[0], c.metricLabels[1], message.msgType, pidLabel}, e2eMs)
+			}
 		}
 	}
 }
 
+// write 序列化后写入连接,返回写入的字节数,消息由队列投递时记录写入的span
+func (c *defaultClient) write(ctx context.Context, message *outboundMessage) (n int, err error) {
+	if message.spanCtx.IsValid() {
+		var span trace.Span
+		_, span = c.opts.tracer.Start(trace.ContextWithSpanContext(ctx, message.spanCtx), "client.write",
+			attribute.String("cid", c.ID), attribute.String("client_type", c.cType.String()))
+		defer func() { wstrace.End(span, err) }()
+	}
+	data, err := json.Marshal(message.payload)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), c.socket.WriteMessage(websocket.TextMessage, data)
+}
+
 // NewClient 创建一个新的客户端,uid,pid为用户id和项目id,socket为websocket连接
 func NewClient(ctx context.Context, uid string, pid string, cType CType, socket *websocket.Conn, options ...Option) Client {
-	ctx, cancel := context.WithCancel(ctx)
+	id := shared.GetSnowflakeNode().Generate().String()
+	ctx, cancel := context.WithCancel(logfield.WithCID(ctx, id))
 	options = append(options, WithContext(ctx))
 
 	opts := NewOptions(options...)
@@ -201,7 +282,7 @@ func NewClient(ctx context.Context, uid string, pid string, cType CType, socket
 	nodeIP := shared.GetInternalIP()
 	c := &defaultClient{
 		opts:         opts,
-		ID:           shared.GetSnowflakeNode().Generate().String(),
+		ID:           id,
 		UID:          uid,
 		PID:          pid,
 		cancel:       cancel,
@@ -209,6 +290,7 @@ func NewClient(ctx context.Context, uid string, pid string, cType CType, socket
 		socket:       socket,
 		messageChan:  messageChan,
 		metricLabels: []string{strconv.FormatInt(nodeID, 10), nodeIP, cType.String()},
+		connectedAt:  time.Now(),
 	}
 	c.status.Store(int32(StatusNormal))
 	c.lastInteractTime.Store(time.Now().Unix())
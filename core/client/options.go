@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
 )
 
 type Device string
@@ -27,12 +28,14 @@ type Option func(o *Options)
 type Options struct {
 	ctx    context.Context
 	logger logger.Logger
+	tracer *wstrace.Tracer
 }
 
 func NewOptions(opts ...Option) *Options {
 	options := &Options{
 		ctx:    context.Background(),
-		logger: logger.DefaultLogger,
+		logger: logger.DefaultLogger.WithModule(logger.ModuleClient),
+		tracer: wstrace.DefaultTracer,
 	}
 	for _, o := range opts {
 		o(options)
@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	bufferKeyPrefix  = "ws_cluster:buffer:"      // 服务端不在线时缓存的用户请求 stream
+	bufferLockPrefix = "ws_cluster:buffer_lock:" // 同一时间只允许一个节点排空某个项目的缓存
+	bufferDrainBatch = 500
+	bufferLockTTL    = time.Minute // 排空缓存的锁的有效期,每处理一批请求后续期
+)
+
+// bufferIfDrainingScript 正在排空缓存时将请求追加到缓存,保证与判断排空标记是原子的
+var bufferIfDrainingScript = redis.NewScript(`
+if redis.call("exists", KEYS[2]) == 0 then
+    return 0
+end
+redis.call("xadd", KEYS[1], "MAXLEN", "~", ARGV[1], "*", "m", ARGV[2])
+redis.call("expire", KEYS[1], ARGV[3])
+return 1
+`)
+
+// finishDrainScript 缓存为空时清除排空标记,避免清除后仍有请求留在缓存中
+var finishDrainScript = redis.NewScript(`
+if redis.call("xlen", KEYS[1]) > 0 then
+    return 0
+end
+redis.call("del", KEYS[2])
+return 1
+`)
+
+const (
+	BufferPolicyNone   = "none"   // 服务端不在线时直接丢弃,保持原有行为
+	BufferPolicyBuffer = "buffer" // 服务端不在线时缓存,服务端重连后按顺序投递
+	BufferPolicyReject = "reject" // 服务端不在线时告知用户服务不可用
+)
+
+// BufferPolicy 返回项目在服务端都不在线时的处理策略
+func (c *Cluster) BufferPolicy(pid string) string {
+	bufferConfig := c.opts.Config.Values().ServerBuffer
+	if policy, ok := bufferConfig.Projects[pid]; ok && policy != "" {
+		return policy
+	}
+	if bufferConfig.Policy == "" {
+		return BufferPolicyNone
+	}
+	return bufferConfig.Policy
+}
+
+// drainingKey 项目正在排空缓存的标记,使用hash tag与缓存的stream位于redis cluster的同一个slot
+func drainingKey(pid string) string {
+	return "{" + bufferKeyPrefix + pid + "}:draining"
+}
+
+// StartDrain 服务端连接时标记项目正在排空缓存,标记清除前新的请求继续进入缓存,保证先缓存的请求先投递
+// 标记在缓存的有效期后自动过期,避免排空的节点宕机后请求一直被缓存
+func (c *Cluster) StartDrain(ctx context.Context, pid string) error {
+	if c.local != nil {
+		c.startLocalDrain(pid)
+		return nil
+	}
+	return c.opts.Redis.Set(ctx, drainingKey(pid), 1, c.BufferTTL()).Err()
+}
+
+// BufferIfDraining 项目正在排空缓存时将请求追加到缓存,返回false表示没有在排空,请求需要直接投递
+func (c *Cluster) BufferIfDraining(ctx context.Context, pid string, message []byte) (bool, error) {
+	if c.local != nil {
+		return c.bufferLocalIfDraining(pid, message), nil
+	}
+	maxLen := c.opts.Config.Values().ServerBuffer.MaxLen
+	keys := []string{bufferKeyPrefix + pid, drainingKey(pid)}
+	buffered, err := bufferIfDrainingScript.Run(ctx, c.opts.Redis, keys, kit.IfElse(maxLen > 0, maxLen, 10000), message, int64(c.BufferTTL().Seconds())).Int()
+	return buffered == 1, err
+}
+
+// FinishDrain 缓存已经为空时清除排空标记,返回false表示缓存中仍有请求,需要继续排空
+func (c *Cluster) FinishDrain(ctx context.Context, pid string) (bool, error) {
+	if c.local != nil {
+		return c.finishLocalDrain(pid), nil
+	}
+	finished, err := finishDrainScript.Run(ctx, c.opts.Redis, []string{bufferKeyPrefix + pid, drainingKey(pid)}).Int()
+	return finished == 1, err
+}
+
+// BufferRequest 将用户请求缓存到项目的stream中,超过 MaxLen 时丢弃最早的请求
+func (c *Cluster) BufferRequest(ctx context.Context, pid string, message []byte) error {
+	if c.local != nil {
+		c.bufferLocalRequest(pid, message)
+		return nil
+	}
+	bufferConfig := c.opts.Config.Values().ServerBuffer
+	key := bufferKeyPrefix + pid
+	pipe := c.opts.Redis.Pipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: kit.IfElse(bufferConfig.MaxLen > 0, bufferConfig.MaxLen, 10000),
+		Approx: true,
+		Values: map[string]interface{}{"m": message},
+	})
+	pipe.Expire(ctx, key, c.BufferTTL())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// DrainBuffer 按顺序取出项目缓存的请求交给 publish,publish 失败时停止,未投递的请求保留在缓存中
+// 其他节点正在排空时直接返回,publish 返回的错误以外,请求取出后都会从缓存中删除
+func (c *Cluster) DrainBuffer(ctx context.Context, pid string, publish func(ctx context.Context, message []byte) error) (count int, err error) {
+	if c.local != nil {
+		return c.drainLocalBuffer(ctx, pid, publish)
+	}
+	var (
+		key    = bufferKeyPrefix + pid
+		logger = c.opts.Logger
+		ttl    = c.BufferTTL()
+	)
+	unlocker, err := kit.NewRedisLocker(c.opts.Redis, bufferLockPrefix).Lock(ctx, pid, bufferLockTTL)
+	if errors.Is(err, kit.ErrLockFailed) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = unlocker.Unlock(context.Background())
+	}()
+
+	for {
+		messages, err := c.opts.Redis.XRangeN(ctx, key, "-", "+", bufferDrainBatch).Result()
+		if err != nil {
+			return count, err
+		}
+		if len(messages) == 0 {
+			return count, nil
+		}
+		ids := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			ids = append(ids, msg.ID)
+			// 超过有效期的请求直接丢弃
+			if streamMessageAge(msg.ID) > ttl {
+				continue
+			}
+			value, ok := msg.Values["m"].(string)
+			if !ok {
+				logger.Warnf(ctx, "Cluster drain buffer pid:%s unsupported msg:%v", pid, msg.Values)
+				continue
+			}
+			if err := publish(ctx, []byte(value)); err != nil {
+				_ = c.opts.Redis.XDel(ctx, key, ids[:len(ids)-1]...).Err()
+				return count, err
+			}
+			count++
+		}
+		if err := c.opts.Redis.XDel(ctx, key, ids...).Err(); err != nil {
+			return count, err
+		}
+		// 锁过期后其他节点可能同时排空,导致重复投递
+		if err := unlocker.Refresh(ctx, bufferLockTTL); err != nil {
+			return count, err
+		}
+	}
+}
+
+// BufferTTL 缓存的请求的有效期
+func (c *Cluster) BufferTTL() time.Duration {
+	ttl := c.opts.Config.Values().ServerBuffer.TTL
+	if ttl <= 0 {
+		ttl = 300
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// streamMessageAge 根据stream消息ID中的毫秒时间戳计算消息已存在的时间
+func streamMessageAge(id string) time.Duration {
+	var ms int64
+	for _, ch := range id {
+		if ch < '0' || ch > '9' {
+			break
+		}
+		ms = ms*10 + int64(ch-'0')
+	}
+	return time.Since(time.UnixMilli(ms))
+}
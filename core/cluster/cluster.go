@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/shared"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	nodesKey         = "ws_cluster:nodes"    // 存活节点 zset member:nodeID score:最后心跳时间(ms)
+	receiptKeyPrefix = "ws_cluster:receipt:" // 同步推送回执 list,每个节点一个,key后缀为发起推送的节点id
+)
+
+// DefaultCluster 创建时不访问redis,在main中调用 Start 后开始上报心跳
+var DefaultCluster = NewCluster()
+
+// Cluster 负责节点之间的协作,如节点存活信息、服务端分布、用户消息的分发、同步推送的回执以及服务端不在线时的请求缓存
+type Cluster struct {
+	opts        *Options
+	startOnce   sync.Once
+	receiveOnce sync.Once
+	receipts    *receiptInbox // 本节点等待中的同步推送回执
+	servers     sync.Map      // 集群服务端列表的本地缓存 key:pid value:*serversCache
+
+	dispatchSeq sync.Map // 轮询分发的计数器 key:pid value:*atomic.Uint64
+
+	local *standalone // 单节点部署时不为空,不依赖redis
+}
+
+func NewCluster(opts ...Option) *Cluster {
+	c := &Cluster{
+		opts: NewOptions(opts...),
+	}
+	c.receipts = newReceiptInbox(c.opts.ReceiptTTL)
+	if c.opts.Config.Values().Standalone() {
+		c.local = newStandalone()
+	}
+	return c
+}
+
+// Start 开始上报本节点和服务端的心跳,重复调用只生效一次,单节点部署时不需要心跳
+func (c *Cluster) Start() {
+	if c.local != nil {
+		return
+	}
+	c.startOnce.Do(func() {
+		go c.infiniteHeartbeat()
+	})
+}
+
+// nodeID 使用时才获取节点id,动态分配时需要访问redis
+func (c *Cluster) nodeID() int64 {
+	return shared.GetNodeID()
+}
+
+func (c *Cluster) Options() Options {
+	return *c.opts
+}
+
+// AliveNodes 返回在 NodeTTL 内有心跳的节点
+func (c *Cluster) AliveNodes(ctx context.Context) ([]int64, error) {
+	if c.local != nil {
+		return []int64{c.nodeID()}, nil
+	}
+	minScore := time.Now().Add(-c.opts.NodeTTL).UnixMilli()
+	members, err := c.opts.Redis.ZRangeByScore(ctx, nodesKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(minScore, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]int64, 0, len(members))
+	for _, member := range members {
+		nodeID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, nodeID)
+	}
+	return nodes, nil
+}
+
+func (c *Cluster) infiniteHeartbeat() {
+	var (
+		ctx    = c.opts.Ctx
+		logger = c.opts.Logger
+	)
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+	for {
+		c.heartbeat(ctx)
+		c.heartbeatServers(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.removeExpiredNodes(ctx); err != nil {
+			logger.Warnf(ctx, "Cluster remove expired nodes error:%v", err)
+		}
+	}
+}
+
+func (c *Cluster) heartbeat(ctx context.Context) {
+	err := c.opts.Redis.ZAdd(ctx, nodesKey, redis.Z{
+		Score:  float64(time.Now().UnixMilli()),
+		Member: strconv.FormatInt(c.nodeID(), 10),
+	}).Err()
+	if err != nil {
+		c.opts.Logger.Warnf(ctx, "Cluster heartbeat error:%v", err)
+	}
+}
+
+func (c *Cluster) removeExpiredNodes(ctx context.Context) error {
+	// 保留较长时间的过期节点,避免时钟误差导致误删
+	maxScore := time.Now().Add(-c.opts.NodeTTL * 10).UnixMilli()
+	return c.opts.Redis.ZRemRangeByScore(ctx, nodesKey, "-inf", strconv.FormatInt(maxScore, 10)).Err()
+}
@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+)
+
+const (
+	DispatchBroadcast  = "broadcast"   // 发送给项目的所有服务端
+	DispatchRoundRobin = "round_robin" // 轮询选择一个服务端
+	DispatchHash       = "hash"        // 按uid一致性哈希选择一个服务端,同一用户固定到同一服务端
+)
+
+// DispatchMode 返回项目的用户消息在多个服务端之间的分发方式
+func (c *Cluster) DispatchMode(pid string) string {
+	dispatchConfig := c.opts.Config.Values().ServerDispatch
+	if mode, ok := dispatchConfig.Projects[pid]; ok && mode != "" {
+		return mode
+	}
+	if dispatchConfig.Mode == "" {
+		return DispatchBroadcast
+	}
+	return dispatchConfig.Mode
+}
+
+// PickServer 在接收用户消息的节点为消息选择目标服务端,返回空字符串时广播给所有服务端
+// 各节点读取的是同一份排序后的服务端列表,因此hash方式在各节点得到相同的结果
+// 轮询计数器保存在本节点,各节点分别轮询,整体仍是均匀分布
+func (c *Cluster) PickServer(ctx context.Context, pid string, uid string) (string, error) {
+	mode := c.DispatchMode(pid)
+	if mode != DispatchRoundRobin && mode != DispatchHash {
+		return "", nil
+	}
+	cids, err := c.Servers(ctx, pid)
+	if err != nil || len(cids) == 0 {
+		return "", err
+	}
+	if mode == DispatchHash {
+		return kit.RendezvousPick(uid, cids), nil
+	}
+	value, _ := c.dispatchSeq.LoadOrStore(pid, &atomic.Uint64{})
+	seq := value.(*atomic.Uint64).Add(1)
+	return cids[seq%uint64(len(cids))], nil
+}
+
+// ResolveTarget 在投递节点确认消息指定的目标服务端仍然存活,目标已断开时按uid在存活的服务端中重新选择
+// 各节点读取同一份服务端列表,重新选择的结果相同,仍然只有一个服务端收到消息
+// 没有存活的服务端或者读取失败时返回空字符串,广播给所有服务端,避免消息被所有节点跳过
+func (c *Cluster) ResolveTarget(ctx context.Context, pid string, uid string, target string) string {
+	cids, err := c.Servers(ctx, pid)
+	if err != nil || len(cids) == 0 {
+		return ""
+	}
+	if slices.Contains(cids, target) {
+		return target
+	}
+	return kit.RendezvousPick(uid, cids)
+}
@@ -0,0 +1,81 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/manager"
+	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/shared"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Options struct {
+	Ctx        context.Context
+	Config     config.Config
+	Redis      redis.UniversalClient
+	Logger     logger.Logger
+	Manager    manager.Manager
+	Interval   time.Duration // 节点心跳间隔
+	NodeTTL    time.Duration // 超过该时间没有心跳的节点或服务端视为下线
+	ReceiptTTL time.Duration // 回执在redis中的保存时间
+	CacheTTL   time.Duration // 本地缓存集群服务端列表的时间
+}
+
+func NewOptions(opts ...Option) *Options {
+	opt := &Options{
+		Ctx:        context.Background(),
+		Config:     config.DefaultConfig,
+		Redis:      shared.GetRedis(),
+		Logger:     logger.DefaultLogger,
+		Manager:    manager.DefaultManager,
+		Interval:   3 * time.Second,
+		NodeTTL:    10 * time.Second,
+		ReceiptTTL: 30 * time.Second,
+		CacheTTL:   time.Second,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return opt
+}
+
+type Option func(*Options)
+
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Ctx = ctx
+	}
+}
+
+func WithRedis(redis redis.UniversalClient) Option {
+	return func(o *Options) {
+		o.Redis = redis
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+func WithInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.Interval = interval
+	}
+}
+
+func WithConfig(c config.Config) Option {
+	return func(o *Options) {
+		o.Config = c
+	}
+}
+
+func WithManager(m manager.Manager) Option {
+	return func(o *Options) {
+		o.Manager = m
+	}
+}
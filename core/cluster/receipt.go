@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// receiptBlockTimeout 读取回执时BLPOP阻塞的最长时间,小于redis客户端默认的读超时
+	receiptBlockTimeout = time.Second
+	// receiptInboxSize 每个回执ID最多缓存的回执数量,即集群的最大节点数
+	receiptInboxSize = 256
+)
+
+var errNoIngestNode = errors.New("receipt msg has no ingest node")
+
+// receiptKey 节点的回执list,其他节点处理同步推送后将回执写入发起节点的list
+func receiptKey(node int64) string {
+	return receiptKeyPrefix + strconv.FormatInt(node, 10)
+}
+
+// ReportReceipt 上报本节点对同步推送消息的投递结果,回执写入发起推送的节点
+func (c *Cluster) ReportReceipt(ctx context.Context, msg *clustermessage.AffairMsg, delivered map[string][]string) error {
+	receipt := &clustermessage.Receipt{
+		ReceiptID: msg.ReceiptID,
+		Node:      c.nodeID(),
+		Delivered: delivered,
+	}
+	if c.local != nil {
+		c.receipts.deliver(receipt)
+		return nil
+	}
+	if msg.Header == nil || msg.Header.Node == 0 {
+		return errNoIngestNode
+	}
+	bytes, err := clustermessage.PackReceipt(receipt)
+	if err != nil {
+		return err
+	}
+	key := receiptKey(msg.Header.Node)
+	pipe := c.opts.Redis.Pipeline()
+	pipe.RPush(ctx, key, bytes)
+	pipe.Expire(ctx, key, c.opts.ReceiptTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// WaitReceipts 等待所有存活节点的回执,直到收齐或者超过 wait
+// uids 为推送时指定的用户,用于计算不在线的用户
+func (c *Cluster) WaitReceipts(ctx context.Context, receiptID string, uids []string, wait time.Duration) (*clustermessage.PushResult, error) {
+	expected := 1
+	if c.local == nil {
+		nodes, err := c.AliveNodes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		expected = max(len(nodes), 1)
+		c.receiveOnce.Do(func() {
+			go c.infiniteReceiveReceipts()
+		})
+	}
+	defer c.receipts.remove(receiptID)
+	ch := c.receipts.get(receiptID)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	receipts := make([]*clustermessage.Receipt, 0, expected)
+	for len(receipts) < expected {
+		select {
+		case receipt := <-ch:
+			receipts = append(receipts, receipt)
+		case <-ctx.Done():
+			return clustermessage.MergeReceipts(uids, expected, receipts), nil
+		case <-timer.C:
+			return clustermessage.MergeReceipts(uids, expected, receipts), nil
+		}
+	}
+	return clustermessage.MergeReceipts(uids, expected, receipts), nil
+}
+
+// infiniteReceiveReceipts 阻塞读取其他节点上报给本节点的回执,交给等待中的同步推送
+// 整个节点只占用一个redis连接,不随同步推送的并发数增加
+func (c *Cluster) infiniteReceiveReceipts() {
+	var (
+		ctx        = c.opts.Ctx
+		logger     = c.opts.Logger
+		key        = receiptKey(c.nodeID())
+		lastErrLog atomic.Int64
+	)
+	for ctx.Err() == nil {
+		values, err := c.opts.Redis.BLPop(ctx, receiptBlockTimeout, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if kit.AllowByInterval(&lastErrLog, 10*time.Second) {
+				logger.Warnf(ctx, "Cluster receive receipts error:%v", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+		// 返回值为 [key, value]
+		if len(values) != 2 {
+			continue
+		}
+		receipt, err := clustermessage.ParseReceipt([]byte(values[1]))
+		if err != nil {
+			logger.Warnf(ctx, "Cluster parse receipt:%s error:%v", values[1], err)
+			continue
+		}
+		c.receipts.deliver(receipt)
+	}
+}
+
+// receiptInbox 本节点上等待中的同步推送回执
+// 回执可能先于等待到达,先到的一方创建,等待结束后才到达的回执超过ttl后清理
+type receiptInbox struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	items     map[string]*inboxItem // key:receiptID
+	lastClean time.Time
+}
+
+type inboxItem struct {
+	ch chan *clustermessage.Receipt
+	at time.Time
+}
+
+func newReceiptInbox(ttl time.Duration) *receiptInbox {
+	return &receiptInbox{ttl: ttl, items: make(map[string]*inboxItem)}
+}
+
+func (b *receiptInbox) get(receiptID string) chan *clustermessage.Receipt {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if item, ok := b.items[receiptID]; ok {
+		return item.ch
+	}
+	now := time.Now()
+	if now.Sub(b.lastClean) > b.ttl {
+		for id, item := range b.items {
+			if now.Sub(item.at) > b.ttl {
+				delete(b.items, id)
+			}
+		}
+		b.lastClean = now
+	}
+	item := &inboxItem{ch: make(chan *clustermessage.Receipt, receiptInboxSize), at: now}
+	b.items[receiptID] = item
+	return item.ch
+}
+
+func (b *receiptInbox) deliver(receipt *clustermessage.Receipt) {
+	select {
+	case b.get(receipt.ReceiptID) <- receipt:
+	default:
+	}
+}
+
+func (b *receiptInbox) remove(receiptID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.items, receiptID)
+}
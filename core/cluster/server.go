@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/core/client"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// serversKeyPrefix 集群中某个项目的服务端 zset member:cid score:最后心跳时间(ms)
+const serversKeyPrefix = "ws_cluster:servers:"
+
+type serversCache struct {
+	cids []string
+	at   time.Time
+}
+
+// JoinServer 服务端连接后立即登记,不必等待下一次心跳
+func (c *Cluster) JoinServer(ctx context.Context, sc client.Client) error {
+	c.invalidateServers(sc.GetPID())
+	if c.local != nil {
+		return nil
+	}
+	return c.opts.Redis.ZAdd(ctx, serversKeyPrefix+sc.GetPID(), redis.Z{
+		Score:  float64(time.Now().UnixMilli()),
+		Member: sc.GetCID(),
+	}).Err()
+}
+
+// LeaveServer 服务端断开后立即注销
+func (c *Cluster) LeaveServer(ctx context.Context, sc client.Client) error {
+	c.invalidateServers(sc.GetPID())
+	if c.local != nil {
+		return nil
+	}
+	return c.opts.Redis.ZRem(ctx, serversKeyPrefix+sc.GetPID(), sc.GetCID()).Err()
+}
+
+// HasServer 判断集群中是否有该项目的服务端连接
+func (c *Cluster) HasServer(ctx context.Context, pid string) (bool, error) {
+	if len(c.opts.Manager.ServersByPID(ctx, pid)) > 0 {
+		return true, nil
+	}
+	cids, err := c.Servers(ctx, pid)
+	return len(cids) > 0, err
+}
+
+// Servers 返回集群中该项目所有存活的服务端cid,按cid排序,结果在本地缓存 CacheTTL
+func (c *Cluster) Servers(ctx context.Context, pid string) ([]string, error) {
+	if value, ok := c.servers.Load(pid); ok {
+		cache := value.(*serversCache)
+		if time.Since(cache.at) < c.opts.CacheTTL {
+			return cache.cids, nil
+		}
+	}
+	if c.local != nil {
+		servers := c.opts.Manager.ServersByPID(ctx, pid)
+		cids := make([]string, 0, len(servers))
+		for _, sc := range servers {
+			cids = append(cids, sc.GetCID())
+		}
+		sortCIDs(cids)
+		return cids, nil
+	}
+	var (
+		key      = serversKeyPrefix + pid
+		minScore = strconv.FormatInt(time.Now().Add(-c.opts.NodeTTL).UnixMilli(), 10)
+	)
+	pipe := c.opts.Redis.Pipeline()
+	// 顺带清理宕机节点遗留的服务端
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+minScore)
+	rangeCmd := pipe.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: minScore, Max: "+inf"})
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	cids := rangeCmd.Val()
+	sortCIDs(cids)
+	c.servers.Store(pid, &serversCache{cids: cids, at: time.Now()})
+	return cids, nil
+}
+
+func (c *Cluster) invalidateServers(pid string) {
+	c.servers.Delete(pid)
+}
+
+// heartbeatServers 刷新本节点所有服务端的心跳
+func (c *Cluster) heartbeatServers(ctx context.Context) {
+	now := float64(time.Now().UnixMilli())
+	pipe := c.opts.Redis.Pipeline()
+	count := 0
+	for _, project := range c.opts.Manager.Projects(ctx) {
+		for _, sc := range project.Servers {
+			pipe.ZAdd(ctx, serversKeyPrefix+project.PID, redis.Z{Score: now, Member: sc.GetCID()})
+			count++
+		}
+	}
+	if count == 0 {
+		return
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		c.opts.Logger.Warnf(ctx, "Cluster heartbeat servers error:%v", err)
+	}
+}
+
+// sortCIDs cid为snowflake生成的数字字符串,按数值排序保证各节点得到相同的顺序
+func sortCIDs(cids []string) {
+	sort.Slice(cids, func(i, j int) bool {
+		if len(cids[i]) != len(cids[j]) {
+			return len(cids[i]) < len(cids[j])
+		}
+		return cids[i] < cids[j]
+	})
+}
@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+)
+
+// standalone 单节点部署时代替redis缓存的请求,回执直接交给本节点的 receiptInbox
+type standalone struct {
+	mu       sync.Mutex
+	buffers  map[string][]*localRequest
+	draining map[string]time.Time // key:pid value:排空标记的过期时间
+}
+
+type localRequest struct {
+	message []byte
+	at      time.Time
+}
+
+func newStandalone() *standalone {
+	return &standalone{
+		buffers:  make(map[string][]*localRequest),
+		draining: make(map[string]time.Time),
+	}
+}
+
+func (c *Cluster) bufferLocalRequest(pid string, message []byte) {
+	c.local.mu.Lock()
+	defer c.local.mu.Unlock()
+	c.appendLocalRequest(pid, message)
+}
+
+// appendLocalRequest 调用方需要持有锁
+func (c *Cluster) appendLocalRequest(pid string, message []byte) {
+	maxLen := c.opts.Config.Values().ServerBuffer.MaxLen
+	maxLen = kit.IfElse(maxLen > 0, maxLen, 10000)
+	requests := append(c.local.buffers[pid], &localRequest{message: message, at: time.Now()})
+	if int64(len(requests)) > maxLen {
+		requests = requests[int64(len(requests))-maxLen:]
+	}
+	c.local.buffers[pid] = requests
+}
+
+func (c *Cluster) startLocalDrain(pid string) {
+	c.local.mu.Lock()
+	defer c.local.mu.Unlock()
+	c.local.draining[pid] = time.Now().Add(c.BufferTTL())
+}
+
+func (c *Cluster) bufferLocalIfDraining(pid string, message []byte) bool {
+	c.local.mu.Lock()
+	defer c.local.mu.Unlock()
+	expireAt, ok := c.local.draining[pid]
+	if !ok || time.Now().After(expireAt) {
+		return false
+	}
+	c.appendLocalRequest(pid, message)
+	return true
+}
+
+func (c *Cluster) finishLocalDrain(pid string) bool {
+	c.local.mu.Lock()
+	defer c.local.mu.Unlock()
+	if len(c.local.buffers[pid]) > 0 {
+		return false
+	}
+	delete(c.local.draining, pid)
+	return true
+}
+
+// drainLocalBuffer 排空期间仍可能有新的请求进入缓存,取完为止
+func (c *Cluster) drainLocalBuffer(ctx context.Context, pid string, publish func(ctx context.Context, message []byte) error) (count int, err error) {
+	ttl := c.BufferTTL()
+	for {
+		c.local.mu.Lock()
+		requests := c.local.buffers[pid]
+		delete(c.local.buffers, pid)
+		c.local.mu.Unlock()
+		if len(requests) == 0 {
+			return count, nil
+		}
+		for i, request := range requests {
+			if time.Since(request.at) > ttl {
+				continue
+			}
+			if err := publish(ctx, request.message); err != nil {
+				// 未投递的请求放回缓存头部
+				c.local.mu.Lock()
+				c.local.buffers[pid] = append(requests[i:], c.local.buffers[pid]...)
+				c.local.mu.Unlock()
+				return count, err
+			}
+			count++
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package connevent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/connevent/eventsink"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/core/queue/kafka"
+	"github.com/mtgnorton/ws-cluster/core/queue/nats"
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+)
+
+type (
+	Event = eventsink.Event
+	Sink  = eventsink.Sink
+)
+
+const (
+	TypeConnect     = eventsink.TypeConnect
+	TypeDisconnect  = eventsink.TypeDisconnect
+	TypeKick        = eventsink.TypeKick
+	TypeIdleTimeout = eventsink.TypeIdleTimeout
+)
+
+const (
+	dropReasonQueueFull   = "queue_full"
+	dropReasonWriteFailed = "write_failed"
+
+	batchSize = 100
+)
+
+var DefaultRecorder = NewRecorder()
+
+// Recorder 异步记录连接生命周期事件,按顺序批量写入所有输出,本地缓冲区满时丢弃
+type Recorder struct {
+	opts          *Options
+	sinks         []Sink
+	ch            chan *Event
+	metricLabels  []string
+	lastDropLogAt atomic.Int64
+}
+
+func NewRecorder(opts ...Option) *Recorder {
+	options := NewOptions(opts...)
+	c := options.Config.Values().ConnEvent
+	r := &Recorder{
+		opts:         options,
+		metricLabels: []string{strconv.FormatInt(shared.GetNodeID(), 10), shared.GetInternalIP()},
+	}
+	if !c.Enable {
+		return r
+	}
+	r.sinks = append(r.newSinks(c), options.Sinks...)
+	if len(r.sinks) == 0 {
+		return r
+	}
+	r.ch = make(chan *Event, kit.IfElse(c.QueueSize > 0, c.QueueSize, 10000))
+	go r.loop(options.Ctx)
+	return r
+}
+
+// newSinks 创建配置文件中开启的输出,创建失败时只记录日志,不影响连接
+func (r *Recorder) newSinks(c config.ConnEvent) (sinks []Sink) {
+	ctx := r.opts.Ctx
+	if c.File.Enable && c.File.Path != "" {
+		sinks = append(sinks, eventsink.NewFile(eventsink.FileConfig{
+			Path:       c.File.Path,
+			MaxSize:    c.File.MaxSize,
+			MaxBackups: c.File.MaxBackups,
+			MaxAge:     c.File.MaxAge,
+			Compress:   c.File.Compress,
+		}))
+	}
+	if c.Queue.Enable {
+		publisher, err := r.newPublisher(c.Queue)
+		if err != nil {
+			r.opts.Logger.Warnf(ctx, "ConnEvent create queue sink error:%v", err)
+		} else {
+			sinks = append(sinks, eventsink.NewQueue(publisher))
+		}
+	}
+	return sinks
+}
+
+// newPublisher 按当前使用的队列选择topic的实现,迁移队列时使用主队列
+func (r *Recorder) newPublisher(c config.ConnEventQueue) (eventsink.Publisher, error) {
+	values := r.opts.Config.Values()
+	topic := kit.IfElse(c.Topic != "", c.Topic, "ws_conn_event")
+	use := values.Queue.Use
+	if use == queue.QueueTypeDual {
+		use = values.Queue.Dual.Primary
+	}
+	switch use {
+	case queue.QueueTypeKafka:
+		return kafka.NewProducer(queue.NewKafkaConfig(r.opts.Config, topic))
+	case queue.QueueTypeNats:
+		nc := values.Queue.Nats
+		return nats.NewJetStream(nats.Config{
+			URL:      nc.URL,
+			Stream:   topic,
+			Subject:  topic,
+			MaxAge:   time.Duration(kit.IfElse(nc.MaxAge > 0, nc.MaxAge, 600)) * time.Second,
+			Replicas: nc.Replicas,
+			Name:     fmt.Sprintf("ws-cluster-node-%d-conn-event", shared.GetNodeID()),
+		})
+	case queue.QueueTypeMemory:
+		return nil, fmt.Errorf("queue %s does not support conn event topic", use)
+	default:
+		rdb := shared.GetDefaultRedisQueue()
+		return eventsink.NewRedisStream(rdb, kit.RedisKey(rdb, topic, ""), kit.IfElse(c.MaxLen > 0, c.MaxLen, 100000)), nil
+	}
+}
+
+// Connected 记录连接建立事件
+func (r *Recorder) Connected(ctx context.Context, c client.Client, remoteIP, userAgent string) {
+	if r.ch == nil {
+		return
+	}
+	r.emit(ctx, newEvent(TypeConnect, c, remoteIP, userAgent))
+}
+
+// Closed 根据连接关闭的原因记录断开、踢下线或空闲超时事件,附带连接时长和收发统计
+func (r *Recorder) Closed(ctx context.Context, c client.Client, remoteIP, userAgent string) {
+	if r.ch == nil {
+		return
+	}
+	stats := c.Stats()
+	reason := kit.IfElse(stats.CloseReason != "", stats.CloseReason, client.CloseReasonPeer)
+	eventType := TypeDisconnect
+	switch reason {
+	case client.CloseReasonKick:
+		eventType = TypeKick
+	case client.CloseReasonIdleTimeout:
+		eventType = TypeIdleTimeout
+	}
+	e := newEvent(eventType, c, remoteIP, userAgent)
+	e.DurationMs = e.Timestamp - e.ConnectedAt
+	e.BytesIn, e.BytesOut = stats.BytesIn, stats.BytesOut
+	e.MsgsIn, e.MsgsOut = stats.MsgsIn, stats.MsgsOut
+	e.Reason = reason
+	r.emit(ctx, e)
+}
+
+func newEvent(eventType string, c client.Client, remoteIP, userAgent string) *Event {
+	cid, uid, pid := c.GetIDs()
+	return &Event{
+		Type:        eventType,
+		PID:         pid,
+		UID:         uid,
+		CID:         cid,
+		ClientType:  c.Type().String(),
+		Node:        shared.GetNodeID(),
+		RemoteIP:    remoteIP,
+		UserAgent:   userAgent,
+		Timestamp:   time.Now().UnixMilli(),
+		ConnectedAt: c.Stats().ConnectedAt.UnixMilli(),
+	}
+}
+
+func (r *Recorder) emit(ctx context.Context, e *Event) {
+	select {
+	case r.ch <- e:
+	default:
+		_ = r.opts.Prometheus.GetAdd(wsprometheus.MetricConnEventDrop, append(r.metricLabels, dropReasonQueueFull), 1)
+		if kit.AllowByInterval(&r.lastDropLogAt, 2*time.Second) {
+			r.opts.Logger.Warnf(ctx, "ConnEvent queue full,dropped,type=%s,cid=%s,len=%d", e.Type, e.CID, len(r.ch))
+		}
+	}
+}
+
+func (r *Recorder) loop(ctx context.Context) {
+	batch := make([]*Event, 0, batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			// 写入剩余的事件后关闭输出
+			batch = batch[:0]
+			for len(batch) < cap(r.ch) {
+				select {
+				case e := <-r.ch:
+					batch = append(batch, e)
+					continue
+				default:
+				}
+				break
+			}
+			if len(batch) > 0 {
+				r.write(context.Background(), batch)
+			}
+			for _, s := range r.sinks {
+				if err := s.Close(); err != nil {
+					r.opts.Logger.Warnf(ctx, "ConnEvent close sink %s error:%v", s.Name(), err)
+				}
+			}
+			return
+		case e := <-r.ch:
+			batch = append(batch[:0], e)
+			for len(batch) < batchSize {
+				select {
+				case e := <-r.ch:
+					batch = append(batch, e)
+					continue
+				default:
+				}
+				break
+			}
+			r.write(ctx, batch)
+		}
+	}
+}
+
+func (r *Recorder) write(ctx context.Context, events []*Event) {
+	for _, s := range r.sinks {
+		if err := s.Write(ctx, events); err != nil {
+			_ = r.opts.Prometheus.GetAdd(wsprometheus.MetricConnEventDrop, append(r.metricLabels, dropReasonWriteFailed), float64(len(events)))
+			r.opts.Logger.Warnf(ctx, "ConnEvent write %d events to sink %s error:%v", len(events), s.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package eventsink
+
+import (
+	"context"
+)
+
+// 连接事件类型
+const (
+	TypeConnect     = "connect"      // 连接建立
+	TypeDisconnect  = "disconnect"   // 对端关闭或者读写失败
+	TypeKick        = "kick"         // 被管理员踢下线
+	TypeIdleTimeout = "idle_timeout" // 超过时间没有交互被关闭
+)
+
+// Event 连接生命周期事件,断开类事件附带连接时长和收发统计
+type Event struct {
+	Type        string `json:"type"`
+	PID         string `json:"pid"`
+	UID         string `json:"uid"`
+	CID         string `json:"cid"`
+	ClientType  string `json:"client_type"`
+	Node        int64  `json:"node"` // 连接所在的节点
+	RemoteIP    string `json:"remote_ip"`
+	UserAgent   string `json:"user_agent,omitempty"`
+	Timestamp   int64  `json:"timestamp"`    // 事件产生时间,单位毫秒
+	ConnectedAt int64  `json:"connected_at"` // 连接建立时间,单位毫秒
+	DurationMs  int64  `json:"duration_ms,omitempty"`
+	BytesIn     int64  `json:"bytes_in,omitempty"`
+	BytesOut    int64  `json:"bytes_out,omitempty"`
+	MsgsIn      int64  `json:"msgs_in,omitempty"`
+	MsgsOut     int64  `json:"msgs_out,omitempty"`
+	Reason      string `json:"reason,omitempty"` // 关闭原因
+}
+
+// Sink 连接事件的输出,Write 在同一个协程中按事件产生的顺序调用
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, events []*Event) error
+	Close() error
+}
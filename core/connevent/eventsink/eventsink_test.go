@@ -0,0 +1,96 @@
+package eventsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testEvents() []*Event {
+	return []*Event{
+		{Type: TypeConnect, PID: "p1", UID: "u1", CID: "c1", ClientType: "user", Node: 1, RemoteIP: "127.0.0.1", Timestamp: 1000, ConnectedAt: 1000},
+		{Type: TypeIdleTimeout, PID: "p1", UID: "u1", CID: "c1", ClientType: "user", Node: 1, RemoteIP: "127.0.0.1", Timestamp: 21000, ConnectedAt: 1000,
+			DurationMs: 20000, BytesIn: 10, BytesOut: 20, MsgsIn: 1, MsgsOut: 2, Reason: "idle_timeout"},
+	}
+}
+
+func TestFileWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conn_event.jsonl")
+	f := NewFile(FileConfig{Path: path, MaxSize: 1})
+	events := testEvents()
+	if err := f.Write(context.Background(), events[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Write(context.Background(), events[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var got []*Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		e := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, e)
+	}
+	if !reflect.DeepEqual(got, events) {
+		t.Fatalf("events = %+v", got)
+	}
+}
+
+type fakePublisher struct {
+	messages [][]byte
+	err      error
+	closed   bool
+}
+
+func (p *fakePublisher) PublishBatch(_ context.Context, messages [][]byte) (int, error) {
+	if p.err != nil {
+		return len(messages), p.err
+	}
+	p.messages = append(p.messages, messages...)
+	return 0, nil
+}
+
+func (p *fakePublisher) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestQueueWrite(t *testing.T) {
+	publisher := &fakePublisher{}
+	q := NewQueue(publisher)
+	if err := q.Write(context.Background(), testEvents()); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.messages) != 2 {
+		t.Fatalf("messages = %d", len(publisher.messages))
+	}
+	e := &Event{}
+	if err := json.Unmarshal(publisher.messages[1], e); err != nil || e.Reason != "idle_timeout" || e.DurationMs != 20000 {
+		t.Fatalf("event = %+v, err = %v", e, err)
+	}
+
+	publisher.err = errors.New("unavailable")
+	if err := q.Write(context.Background(), testEvents()); !errors.Is(err, publisher.err) {
+		t.Fatalf("err = %v", err)
+	}
+	_ = q.Close()
+	if !publisher.closed {
+		t.Fatal("publisher should be closed")
+	}
+}
@@ -0,0 +1,55 @@
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileConfig 文件输出的配置,超过 MaxSize 后滚动
+type FileConfig struct {
+	Path       string // 文件路径,如 ./logs/conn_event.jsonl
+	MaxSize    int    // 单个文件的大小,单位MB
+	MaxBackups int    // 保留的旧文件数量
+	MaxAge     int    // 旧文件保留的天数
+	Compress   bool   // 是否压缩旧文件
+}
+
+// File 将事件按行写入JSON文件
+type File struct {
+	writer *lumberjack.Logger
+	buf    bytes.Buffer
+}
+
+func NewFile(c FileConfig) *File {
+	return &File{writer: &lumberjack.Logger{
+		Filename:   c.Path,
+		MaxSize:    c.MaxSize,
+		MaxBackups: c.MaxBackups,
+		MaxAge:     c.MaxAge,
+		Compress:   c.Compress,
+	}}
+}
+
+func (f *File) Name() string {
+	return "file"
+}
+
+// Write 一批事件合并为一次写入
+func (f *File) Write(_ context.Context, events []*Event) error {
+	f.buf.Reset()
+	encoder := json.NewEncoder(&f.buf)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	_, err := f.writer.Write(f.buf.Bytes())
+	return err
+}
+
+func (f *File) Close() error {
+	return f.writer.Close()
+}
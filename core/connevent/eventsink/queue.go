@@ -0,0 +1,87 @@
+package eventsink
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Publisher 将一批消息写入队列的topic,kafka.Producer 和 nats.JetStream 都实现了该接口
+type Publisher interface {
+	PublishBatch(ctx context.Context, messages [][]byte) (failed int, err error)
+	Close() error
+}
+
+// Queue 将事件序列化为JSON后写入队列的topic,供其他系统订阅
+type Queue struct {
+	publisher Publisher
+}
+
+func NewQueue(publisher Publisher) *Queue {
+	return &Queue{publisher: publisher}
+}
+
+func (q *Queue) Name() string {
+	return "queue"
+}
+
+func (q *Queue) Write(ctx context.Context, events []*Event) error {
+	messages := make([][]byte, 0, len(events))
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, data)
+	}
+	_, err := q.publisher.PublishBatch(ctx, messages)
+	return err
+}
+
+func (q *Queue) Close() error {
+	return q.publisher.Close()
+}
+
+// RedisStream 使用redis stream作为topic,每条事件保存在 data 字段中
+type RedisStream struct {
+	rdb    redis.UniversalClient
+	stream string
+	maxLen int64
+}
+
+// NewRedisStream maxLen为stream近似保留的最大长度
+func NewRedisStream(rdb redis.UniversalClient, stream string, maxLen int64) *RedisStream {
+	return &RedisStream{rdb: rdb, stream: stream, maxLen: maxLen}
+}
+
+func (r *RedisStream) PublishBatch(ctx context.Context, messages [][]byte) (failed int, err error) {
+	cmds, err := r.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, data := range messages {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: r.stream,
+				MaxLen: r.maxLen,
+				Approx: true,
+				Values: map[string]interface{}{"data": data},
+			})
+		}
+		return nil
+	})
+	if err == nil {
+		return 0, nil
+	}
+	if len(cmds) == 0 {
+		return len(messages), err
+	}
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			failed++
+		}
+	}
+	return failed, err
+}
+
+// Close redis客户端由调用方管理
+func (r *RedisStream) Close() error {
+	return nil
+}
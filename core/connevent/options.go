@@ -0,0 +1,57 @@
+package connevent
+
+import (
+	"context"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+)
+
+type Options struct {
+	Ctx        context.Context
+	Config     config.Config
+	Logger     logger.Logger
+	Prometheus *wsprometheus.Prometheus
+	Sinks      []Sink // 配置文件之外的输出
+}
+
+func NewOptions(opts ...Option) *Options {
+	opt := &Options{
+		Ctx:        context.Background(),
+		Config:     config.DefaultConfig,
+		Logger:     logger.DefaultLogger,
+		Prometheus: wsprometheus.DefaultPrometheus,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return opt
+}
+
+type Option func(*Options)
+
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Ctx = ctx
+	}
+}
+
+func WithConfig(c config.Config) Option {
+	return func(o *Options) {
+		o.Config = c
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+// WithSink 添加自定义的输出,开启 conn_event 后和配置文件中的输出一起写入
+func WithSink(s Sink) Option {
+	return func(o *Options) {
+		o.Sinks = append(o.Sinks, s)
+	}
+}
@@ -0,0 +1,181 @@
+package deadletter
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ReasonDecode    = "decode"     // 消息无法解析
+	ReasonNoHandler = "no_handler" // 消息类型没有对应的处理函数
+	ReasonPanic     = "panic"      // 处理函数panic
+)
+
+const (
+	streamKey     = "ws_cluster:queue_dead_letter"       // 死信 stream
+	seenKeyPrefix = "ws_cluster:queue_dead_letter_seen:" // 每个节点都会消费到同一条消息,只由最先写入的节点记录
+	seenTTL       = 10 * time.Minute
+)
+
+var (
+	ErrNotFound    = errors.New("dead letter not found")
+	ErrUnavailable = errors.New("dead letter is not available in standalone mode")
+)
+
+var DefaultDeadLetter = NewDeadLetter()
+
+// Entry 一条死信
+type Entry struct {
+	ID        string `json:"id"`
+	Reason    string `json:"reason"`
+	Error     string `json:"error"`
+	Node      int64  `json:"node"`      // 记录死信的节点
+	Source    string `json:"source"`    // 消息来源的队列,如 redis:ws_queue_stream
+	SourceID  string `json:"source_id"` // 消息在队列中的ID,为空时使用消息内容的哈希去重
+	Timestamp int64  `json:"timestamp"` // 记录时间,单位毫秒
+	Message   string `json:"message"`   // 原始消息
+}
+
+// DeadLetter 保存队列中无法处理的消息,修复后可以通过接口重新投递
+type DeadLetter struct {
+	opts         *Options
+	metricLabels []string
+	lastLogAt    atomic.Int64
+}
+
+func NewDeadLetter(opts ...Option) *DeadLetter {
+	return &DeadLetter{
+		opts:         NewOptions(opts...),
+		metricLabels: []string{strconv.FormatInt(shared.GetNodeID(), 10), shared.GetInternalIP()},
+	}
+}
+
+func (d *DeadLetter) available() bool {
+	return !d.opts.Config.Values().Standalone()
+}
+
+// Add 记录一条死信,同一条消息在 seenTTL 内只记录一次
+func (d *DeadLetter) Add(ctx context.Context, e *Entry) {
+	c := d.opts.Config.Values().Queue.DeadLetter
+	if !c.Enable {
+		return
+	}
+	e.Node = shared.GetNodeID()
+	e.Timestamp = time.Now().UnixMilli()
+	_ = d.opts.Prometheus.GetAdd(wsprometheus.MetricQueueDeadLetter, append(d.metricLabels, e.Reason), 1)
+	// 单节点部署没有redis,只记录日志
+	if !d.available() {
+		d.opts.Logger.Warnf(ctx, "DeadLetter reason:%s,error:%s,source:%s,message:%s", e.Reason, e.Error, e.Source, kit.LogSnippet(e.Message, 240))
+		return
+	}
+
+	sourceID := e.SourceID
+	if sourceID == "" {
+		sum := sha1.Sum([]byte(e.Message))
+		sourceID = hex.EncodeToString(sum[:])
+	}
+	ok, err := d.opts.Redis.SetNX(ctx, seenKeyPrefix+e.Reason+":"+e.Source+":"+sourceID, e.Node, seenTTL).Result()
+	if err != nil {
+		d.opts.Logger.Warnf(ctx, "DeadLetter check seen error:%v", err)
+	}
+	if err == nil && !ok {
+		return
+	}
+	err = d.opts.Redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey,
+		MaxLen: kit.IfElse(c.MaxLen > 0, c.MaxLen, 10000),
+		Approx: true,
+		Values: map[string]interface{}{
+			"reason":    e.Reason,
+			"error":     e.Error,
+			"node":      e.Node,
+			"source":    e.Source,
+			"source_id": e.SourceID,
+			"timestamp": e.Timestamp,
+			"message":   e.Message,
+		},
+	}).Err()
+	if err != nil {
+		d.opts.Logger.Warnf(ctx, "DeadLetter write error:%v,reason:%s,message:%s", err, e.Reason, kit.LogSnippet(e.Message, 240))
+		return
+	}
+	if kit.AllowByInterval(&d.lastLogAt, 2*time.Second) {
+		d.opts.Logger.Warnf(ctx, "DeadLetter add reason:%s,error:%s,source:%s,message:%s", e.Reason, e.Error, e.Source, kit.LogSnippet(e.Message, 240))
+	}
+}
+
+// List 从新到旧返回死信,start为空时从最新的开始,next为下一页的start,为空时没有更多
+func (d *DeadLetter) List(ctx context.Context, start string, count int64) (entries []*Entry, next string, err error) {
+	if !d.available() {
+		return nil, "", ErrUnavailable
+	}
+	if count <= 0 || count > 500 {
+		count = 50
+	}
+	if start == "" {
+		start = "+"
+	}
+	messages, err := d.opts.Redis.XRevRangeN(ctx, streamKey, start, "-", count+1).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(messages)) > count {
+		next = messages[count].ID
+		messages = messages[:count]
+	}
+	entries = make([]*Entry, 0, len(messages))
+	for _, msg := range messages {
+		entries = append(entries, toEntry(msg))
+	}
+	return entries, next, nil
+}
+
+func (d *DeadLetter) Get(ctx context.Context, id string) (*Entry, error) {
+	if !d.available() {
+		return nil, ErrUnavailable
+	}
+	messages, err := d.opts.Redis.XRangeN(ctx, streamKey, id, id, 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrNotFound
+	}
+	return toEntry(messages[0]), nil
+}
+
+func (d *DeadLetter) Delete(ctx context.Context, ids ...string) error {
+	if !d.available() {
+		return ErrUnavailable
+	}
+	return d.opts.Redis.XDel(ctx, streamKey, ids...).Err()
+}
+
+func toEntry(msg redis.XMessage) *Entry {
+	str := func(key string) string {
+		value, _ := msg.Values[key].(string)
+		return value
+	}
+	e := &Entry{
+		ID:       msg.ID,
+		Reason:   str("reason"),
+		Error:    str("error"),
+		Source:   str("source"),
+		SourceID: str("source_id"),
+		Message:  str("message"),
+	}
+	e.Node, _ = strconv.ParseInt(str("node"), 10, 64)
+	e.Timestamp, _ = strconv.ParseInt(str("timestamp"), 10, 64)
+	return e
+}
@@ -0,0 +1,133 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/config"
+)
+
+type testConfig struct {
+	values config.Values
+}
+
+func (c *testConfig) Values() *config.Values {
+	return &c.values
+}
+
+func newTestDeadLetter(use string) *DeadLetter {
+	c := &testConfig{}
+	c.values.Queue.Use = use
+	c.values.Queue.DeadLetter = config.DeadLetter{Enable: true}
+	return NewDeadLetter(WithConfig(c))
+}
+
+// uniqueSource 其他测试或节点也会写入死信,每个测试使用单独的来源区分
+func uniqueSource(t *testing.T) string {
+	return "test:" + t.Name() + ":" + strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+// collect 从新到旧翻页读取指定来源的死信,测试结束时删除
+func collect(t *testing.T, d *DeadLetter, source string, pageSize int64) []*Entry {
+	t.Helper()
+	var (
+		result []*Entry
+		start  string
+	)
+	for {
+		entries, next, err := d.List(context.Background(), start, pageSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(entries)) > pageSize {
+			t.Fatalf("page size %d exceeds %d", len(entries), pageSize)
+		}
+		for _, e := range entries {
+			if e.Source == source {
+				result = append(result, e)
+			}
+		}
+		if next == "" {
+			break
+		}
+		start = next
+	}
+	t.Cleanup(func() {
+		for _, e := range result {
+			_ = d.Delete(context.Background(), e.ID)
+		}
+	})
+	return result
+}
+
+// 所有节点都会消费到同一条消息,同一条消息只记录一次
+func TestDeadLetterAddDedupe(t *testing.T) {
+	d := newTestDeadLetter("redis")
+	ctx := context.Background()
+	source := uniqueSource(t)
+
+	for i := 0; i < 2; i++ {
+		d.Add(ctx, &Entry{Reason: ReasonPanic, Error: "boom", Source: source, SourceID: "1-0", Message: "m1"})
+		// 没有队列中的ID时按消息内容去重
+		d.Add(ctx, &Entry{Reason: ReasonDecode, Error: "bad", Source: source, Message: "m2"})
+	}
+	// 同一条消息不同的原因分别记录
+	d.Add(ctx, &Entry{Reason: ReasonNoHandler, Error: "no handler", Source: source, SourceID: "1-0", Message: "m1"})
+
+	entries := collect(t, d, source, 500)
+	if len(entries) != 3 {
+		t.Fatalf("entries = %d, want 3", len(entries))
+	}
+	reasons := []string{entries[2].Reason, entries[1].Reason, entries[0].Reason}
+	if reasons[0] != ReasonPanic || reasons[1] != ReasonDecode || reasons[2] != ReasonNoHandler {
+		t.Fatalf("reasons from old to new = %v", reasons)
+	}
+}
+
+func TestDeadLetterListGetDelete(t *testing.T) {
+	d := newTestDeadLetter("redis")
+	ctx := context.Background()
+	source := uniqueSource(t)
+	for i := 0; i < 5; i++ {
+		d.Add(ctx, &Entry{Reason: ReasonPanic, Error: "boom", Source: source, SourceID: strconv.Itoa(i), Message: "m" + strconv.Itoa(i)})
+	}
+
+	// 每页2条,翻页后按从新到旧的顺序得到全部死信
+	entries := collect(t, d, source, 2)
+	if len(entries) != 5 {
+		t.Fatalf("entries = %d, want 5", len(entries))
+	}
+	for i, e := range entries {
+		if want := "m" + strconv.Itoa(4-i); e.Message != want {
+			t.Fatalf("entry %d message = %s, want %s", i, e.Message, want)
+		}
+	}
+
+	e, err := d.Get(ctx, entries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reason != ReasonPanic || e.Error != "boom" || e.SourceID != "4" || e.Node == 0 || e.Timestamp == 0 {
+		t.Fatalf("entry = %+v", e)
+	}
+	if err := d.Delete(ctx, e.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, e.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get deleted entry error = %v", err)
+	}
+}
+
+func TestDeadLetterStandalone(t *testing.T) {
+	d := newTestDeadLetter(config.QueueMemory)
+	d.Add(context.Background(), &Entry{Reason: ReasonPanic, Message: "m"})
+	if _, _, err := d.List(context.Background(), "", 10); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("list error = %v", err)
+	}
+	if _, err := d.Get(context.Background(), "1-0"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("get error = %v", err)
+	}
+}
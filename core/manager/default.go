@@ -172,6 +172,25 @@ func (m *manager) Projects(ctx context.Context) []ProjectAllClients {
 	return projects
 }
 
+func (m *manager) ConnectionCounts(ctx context.Context) []ConnectionCount {
+	type key struct {
+		pid   string
+		cType client.CType
+	}
+	counts := make(map[key]int)
+	m.RLock()
+	for _, c := range m.clients {
+		counts[key{pid: c.GetPID(), cType: c.Type()}]++
+	}
+	m.RUnlock()
+
+	result := make([]ConnectionCount, 0, len(counts))
+	for k, count := range counts {
+		result = append(result, ConnectionCount{PID: k.pid, CType: k.cType, Count: count})
+	}
+	return result
+}
+
 func (m *manager) Exist(ctx context.Context, clientID string) bool {
 	m.RLock()
 	defer m.RUnlock()
@@ -201,7 +220,7 @@ func (m *manager) infiniteCheckExpired(ctx context.Context) {
 			m.RUnlock()
 			for _, expiredClient := range expiredClients {
 				m.opts.logger.Debugf(ctx, "checkExpired client %s expired", expiredClient)
-				expiredClient.Close()
+				expiredClient.CloseWithReason(client.CloseReasonIdleTimeout)
 			}
 		}
 	}
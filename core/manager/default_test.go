@@ -3,6 +3,8 @@ package manager
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -79,9 +81,10 @@ func TestConcurrentAccess(t *testing.T) {
 
 // mockClient 用于测试的模拟客户端
 type mockClient struct {
-	id  string
-	uid string
-	pid string
+	id    string
+	uid   string
+	pid   string
+	cType client.CType
 }
 
 func (m *mockClient) Init(opts ...client.Option) {
@@ -91,7 +94,8 @@ func (m *mockClient) Options() client.Options {
 	return client.Options{}
 }
 
-func (m *mockClient) Send(ctx context.Context, message interface{}) {
+func (m *mockClient) Send(ctx context.Context, message interface{}) bool {
+	return true
 }
 
 func (m *mockClient) Status() client.Status {
@@ -106,7 +110,7 @@ func (m *mockClient) GetIDs() (string, string, string) {
 }
 
 func (m *mockClient) Type() client.CType {
-	return client.CTypeUser
+	return m.cType
 }
 
 func (m *mockClient) GetInteractTime() int64 {
@@ -115,6 +119,14 @@ func (m *mockClient) GetInteractTime() int64 {
 
 func (m *mockClient) Close() {}
 
+func (m *mockClient) CloseWithReason(string) {}
+
+func (m *mockClient) AddInbound(int) {}
+
+func (m *mockClient) Stats() client.Stats {
+	return client.Stats{}
+}
+
 func (m *mockClient) String() string {
 	return m.id
 }
@@ -131,6 +143,34 @@ func (m *mockClient) GetPID() string {
 	return m.pid
 }
 
+func TestConnectionCounts(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		m       = NewManager()
+		clients = []*mockClient{
+			{id: "c1", uid: "u1", pid: "p1"},
+			{id: "c2", uid: "u1", pid: "p1"},
+			{id: "c3", uid: "u2", pid: "p1"},
+			{id: "c4", uid: "s1", pid: "p1", cType: client.CTypeServer},
+			{id: "c5", uid: "u3", pid: "p2"},
+		}
+	)
+	for _, c := range clients {
+		m.Join(ctx, c)
+	}
+	m.Remove(ctx, clients[4])
+
+	counts := m.ConnectionCounts(ctx)
+	sort.Slice(counts, func(i, j int) bool { return counts[i].CType < counts[j].CType })
+	want := []ConnectionCount{
+		{PID: "p1", CType: client.CTypeUser, Count: 3},
+		{PID: "p1", CType: client.CTypeServer, Count: 1},
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Fatalf("counts = %+v", counts)
+	}
+}
+
 func TestBatchRemoveAndQuery(t *testing.T) {
 	var (
 		ctx     = context.Background()
@@ -14,6 +14,13 @@ type ProjectAllClients struct {
 	Clients []client.Client // 用户端
 }
 
+// ConnectionCount 项目中某种类型客户端的连接数
+type ConnectionCount struct {
+	PID   string
+	CType client.CType
+	Count int
+}
+
 // Manager 客户端管理
 // Clients... 相关方法是获取用户客户端
 type Manager interface {
@@ -39,6 +46,9 @@ type Manager interface {
 	// Admins 获取所有管理客户端
 	// Admins(ctx context.Context) []client.Client
 
+	// ConnectionCounts 按项目和客户端类型统计当前的连接数
+	ConnectionCounts(ctx context.Context) []ConnectionCount
+
 	// Exist 判断客户端是否存在
 	Exist(ctx context.Context, clientID string) bool
 }
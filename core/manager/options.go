@@ -16,7 +16,7 @@ type Option func(*Options)
 func NewOptions(opts ...Option) Options {
 	options := Options{
 		ctx:    context.Background(),
-		logger: logger.DefaultLogger,
+		logger: logger.DefaultLogger.WithModule(logger.ModuleManager),
 	}
 	for _, o := range opts {
 		o(&options)
@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+	"github.com/mtgnorton/ws-cluster/core/tap"
+	"github.com/mtgnorton/ws-cluster/core/tap/tapevent"
+	"github.com/mtgnorton/ws-cluster/logger/logfield"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+)
+
+// writeDeadLetter 将无法处理的消息写入死信,raw为空时使用msg重新编码
+func writeDeadLetter(ctx context.Context, opts option.Options, reason string, reasonErr string, raw []byte, msg *clustermessage.AffairMsg, source, sourceID string) {
+	if raw == nil && msg != nil {
+		raw, _ = clustermessage.PackAffair(msg)
+	}
+	opts.DeadLetter.Add(ctx, &deadletter.Entry{
+		Reason:   reason,
+		Error:    reasonErr,
+		Source:   source,
+		SourceID: sourceID,
+		Message:  string(raw),
+	})
+}
+
+// handleMessage 调用消息类型对应的处理函数,没有处理函数或处理函数panic时写入死信
+// ok为false代表没有处理函数
+func handleMessage(ctx context.Context, opts option.Options, msg *clustermessage.AffairMsg, raw []byte, source, sourceID string) (isAck bool, ok bool) {
+	h, ok := opts.Handlers[msg.Type]
+	if !ok {
+		writeDeadLetter(ctx, opts, deadletter.ReasonNoHandler, fmt.Sprintf("no handler for type %s", msg.Type), raw, msg, source, sourceID)
+		return false, false
+	}
+	ctx = logfield.WithPID(logfield.WithAffairID(ctx, msg.AffairID), msg.PID())
+	ctx, span := startConsumeSpan(ctx, opts, source, msg)
+	defer span.End()
+	opts.Tap.Observe(ctx, tap.Point{Stage: tapevent.StageConsume, Source: source, Msg: msg})
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			opts.Logger.Errorf(ctx, "%s handler panic:%v,type:%s,payload:%s,stack:%s", source, r, msg.Type, kit.LogSnippet(msg.Payload, 240), stack)
+			writeDeadLetter(ctx, opts, deadletter.ReasonPanic, fmt.Sprintf("%v\n%s", r, kit.LogSnippet(stack, 2000)), raw, msg, source, sourceID)
+			isAck = true
+		}
+	}()
+	return h.Handle(clustermessage.NewContext(ctx, msg), msg), true
+}
@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
+	"github.com/mtgnorton/ws-cluster/core/queue/handler"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+)
+
+type panicHandler struct{}
+
+func (panicHandler) Handle(context.Context, *clustermessage.AffairMsg) bool {
+	panic("handler boom")
+}
+
+// findDeadLetter 其他节点也会写入死信,按来源查找本测试写入的死信
+func findDeadLetter(t *testing.T, d *deadletter.DeadLetter, source string) *deadletter.Entry {
+	t.Helper()
+	var start string
+	for {
+		entries, next, err := d.List(context.Background(), start, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Source == source {
+				t.Cleanup(func() { _ = d.Delete(context.Background(), e.ID) })
+				return e
+			}
+		}
+		if next == "" {
+			t.Fatalf("dead letter of %s not found", source)
+		}
+		start = next
+	}
+}
+
+// 处理函数panic或者没有处理函数时,消费协程不退出,消息写入死信
+func TestHandleMessageDeadLetter(t *testing.T) {
+	c := &testConfig{}
+	c.values.Queue.Use = "redis"
+	c.values.Queue.DeadLetter = config.DeadLetter{Enable: true}
+	d := deadletter.NewDeadLetter(deadletter.WithConfig(c))
+	opts := option.NewOptions(option.WithConfig(c), func(o *option.Options) {
+		o.DeadLetter = d
+		o.Handlers = map[clustermessage.Type]handler.Handle{clustermessage.TypePush: panicHandler{}}
+	})
+	ctx := context.Background()
+	source := "redis:test_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	msg := testMsg("a1")
+	raw, _ := clustermessage.PackAffair(msg)
+	isAck, ok := handleMessage(ctx, opts, msg, raw, source, "1-0")
+	if !ok || !isAck {
+		t.Fatalf("panic message should be acked, isAck=%v ok=%v", isAck, ok)
+	}
+	e := findDeadLetter(t, d, source)
+	if e.Reason != deadletter.ReasonPanic || e.SourceID != "1-0" || e.Message != string(raw) || !strings.Contains(e.Error, "handler boom") {
+		t.Fatalf("dead letter = %+v", e)
+	}
+
+	source += "_no_handler"
+	msg = &clustermessage.AffairMsg{AffairID: "a2", Type: clustermessage.TypeRequest}
+	if _, ok := handleMessage(ctx, opts, msg, nil, source, "2-0"); ok {
+		t.Fatal("message without handler should not be ok")
+	}
+	if e := findDeadLetter(t, d, source); e.Reason != deadletter.ReasonNoHandler || e.SourceID != "2-0" {
+		t.Fatalf("dead letter = %+v", e)
+	}
+}
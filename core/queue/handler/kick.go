@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/client"
+)
+
+// Kick 从消息队列接收到管理端的踢下线消息,关闭连接在本节点上的客户端
+type Kick struct {
+	opts *Options
+}
+
+func (h *Kick) Handle(ctx context.Context, msg *clustermessage.AffairMsg) (isAck bool) {
+	logger, isAck := h.opts.logger, true
+	if msg.To == nil || len(msg.To.CIDs) == 0 {
+		logger.Warnf(ctx, "QueueHandler Kick msg cids is empty")
+		return
+	}
+	kicked := make(map[string][]string)
+	if msg.ReceiptID != "" {
+		defer func() {
+			// 本节点没有目标连接也需要上报,发起节点据此判断所有节点都已处理
+			if err := h.opts.cluster.ReportReceipt(ctx, msg, kicked); err != nil {
+				logger.Warnf(ctx, "QueueHandler Kick report receipt:%s error:%v", msg.ReceiptID, err)
+			}
+		}()
+	}
+	for _, c := range h.opts.manager.Clients(ctx, msg.To.CIDs...) {
+		c.CloseWithReason(client.CloseReasonKick)
+		uid := c.GetUID()
+		kicked[uid] = append(kicked[uid], c.GetCID())
+	}
+	if len(kicked) > 0 {
+		logger.Infof(ctx, "QueueHandler Kick clients:%v", kicked)
+	}
+	return
+}
+
+func NewKickHandler(opts ...Option) Handle {
+	return &Kick{
+		opts: NewOptions(opts...),
+	}
+}
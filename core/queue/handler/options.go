@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"github.com/mtgnorton/ws-cluster/core/cluster"
 	"github.com/mtgnorton/ws-cluster/core/manager"
+	"github.com/mtgnorton/ws-cluster/core/tap"
 	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
 )
 
 type Option func(*Options)
@@ -10,12 +13,18 @@ type Option func(*Options)
 type Options struct {
 	manager manager.Manager
 	logger  logger.Logger
+	cluster *cluster.Cluster
+	tracer  *wstrace.Tracer
+	tap     *tap.Tap
 }
 
 func NewOptions(opts ...Option) *Options {
 	options := &Options{
 		manager: manager.DefaultManager,
-		logger:  logger.DefaultLogger,
+		logger:  logger.DefaultLogger.WithModule(logger.ModuleQueue),
+		cluster: cluster.DefaultCluster,
+		tracer:  wstrace.DefaultTracer,
+		tap:     tap.DefaultTap,
 	}
 	for _, o := range opts {
 		o(options)
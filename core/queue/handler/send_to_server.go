@@ -2,12 +2,19 @@ package handler
 
 import (
 	"context"
+	"slices"
 	"sync/atomic"
 	"time"
 
 	"github.com/mtgnorton/ws-cluster/shared/kit"
 
 	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/tap"
+	"github.com/mtgnorton/ws-cluster/core/tap/tapevent"
+	"github.com/mtgnorton/ws-cluster/logger/logfield"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // SendToServer 从消息队列接收到用户端的消息，将其转发给服务端
@@ -35,16 +42,31 @@ func (h *SendToServer) Handle(ctx context.Context, msg *clustermessage.AffairMsg
 	if msg.Source == nil {
 		return
 	}
+	ctx = logfield.WithPID(logfield.WithAffairID(ctx, msg.AffairID), msg.Source.PID)
 	servers := manager.ServersByPID(ctx, msg.Source.PID)
 	if len(servers) == 0 {
 		return
 	}
+	ctx, span := h.opts.tracer.Start(ctx, "dispatch.send_to_server", attribute.String("pid", msg.Source.PID), attribute.Int("target", len(servers)))
+	defer span.End()
+	target := msg.Target
+	if target != "" && !slices.ContainsFunc(servers, func(sc client.Client) bool { return sc.GetCID() == target }) {
+		// 目标服务端不在本节点,可能已经断开,断开时重新选择
+		target = h.opts.cluster.ResolveTarget(ctx, msg.Source.PID, msg.Source.UID, target)
+	}
 	for _, client := range servers {
-		client.Send(ctx, msg)
+		// 指定了目标服务端时只有目标服务端所在的节点投递
+		if target != "" && client.GetCID() != target {
+			continue
+		}
+		if client.Send(ctx, msg) {
+			// 投递给业务服务端时按消息的来源用户匹配订阅条件
+			h.opts.tap.Observe(ctx, tap.Point{Stage: tapevent.StageDeliver, Source: "server:" + client.GetCID(), Msg: msg})
+		}
 	}
 	costMs := float64(time.Since(beginTime).Microseconds()) / 1000.0
 	if costMs >= 20 && kit.AllowByInterval(&h.lastSlowLogAt, 2*time.Second) {
-		logger.Warnf(ctx, "QueueHandler SendToServer slow=%0.2fms,pid=%s,server_count=%d,type=%s,payload=%s", costMs, msg.Source.PID, len(servers), msg.Type, kit.LogSnippet(msg.Payload, 240))
+		logger.Warnf(ctx, "QueueHandler SendToServer slow=%0.2fms,server_count=%d,type=%s,payload=%s", costMs, len(servers), msg.Type, kit.LogSnippet(msg.Payload, 240))
 	}
 	return
 }
@@ -7,7 +7,12 @@ import (
 
 	"github.com/mtgnorton/ws-cluster/clustermessage"
 	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/tap"
+	"github.com/mtgnorton/ws-cluster/core/tap/tapevent"
+	"github.com/mtgnorton/ws-cluster/logger/logfield"
 	"github.com/mtgnorton/ws-cluster/shared/kit"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // SendToUser 从消息队列接收到业务服务端的消息，将其转发给用户端
@@ -24,6 +29,7 @@ type SendToUserMessage struct {
 
 func (h *SendToUser) Handle(ctx context.Context, msg *clustermessage.AffairMsg) (isAck bool) {
 	logger, manager, isAck := h.opts.logger, h.opts.manager, true
+	ctx = logfield.WithAffairID(ctx, msg.AffairID)
 	if msg.To == nil {
 		logger.Warnf(ctx, "QueueHandler SendToUser msg.To is nil")
 		return
@@ -32,9 +38,10 @@ func (h *SendToUser) Handle(ctx context.Context, msg *clustermessage.AffairMsg)
 	beginTime := time.Now()
 
 	if pid == "" {
-		logger.Warnf(ctx, "QueueHandler SendToUser msg pid is empty,affair_id:%s", msg.AffairID)
+		logger.Warnf(ctx, "QueueHandler SendToUser msg pid is empty")
 		return
 	}
+	ctx = logfield.WithPID(ctx, pid)
 
 	finalClients := make([]client.Client, 0, len(uids)+len(cids))
 	if len(uids) == 0 && len(cids) == 0 {
@@ -58,21 +65,39 @@ func (h *SendToUser) Handle(ctx context.Context, msg *clustermessage.AffairMsg)
 			appendUnique(manager.Clients(ctx, cids...))
 		}
 	}
+	delivered := make(map[string][]string)
+	if msg.ReceiptID != "" {
+		defer func() {
+			// 即使本节点没有目标连接也需要上报,以便发起节点尽快收齐回执
+			if err := h.opts.cluster.ReportReceipt(ctx, msg, delivered); err != nil {
+				logger.Warnf(ctx, "QueueHandler SendToUser report receipt:%s error:%v", msg.ReceiptID, err)
+			}
+		}()
+	}
 	if len(finalClients) == 0 {
 		return
 	}
+	ctx, span := h.opts.tracer.Start(ctx, "dispatch.send_to_user", attribute.String("pid", pid), attribute.Int("target", len(finalClients)))
+	defer span.End()
 
 	sendMsg := SendToUserMessage{
 		AffairID: msg.AffairID,
 		Payload:  msg.Payload,
 	}
 	for _, client := range finalClients {
-		client.Send(ctx, sendMsg)
+		if !client.Send(ctx, sendMsg) {
+			continue
+		}
+		h.opts.tap.Observe(ctx, tap.Point{Stage: tapevent.StageDeliver, Msg: msg, UID: client.GetUID(), CID: client.GetCID()})
+		if msg.ReceiptID != "" {
+			uid := client.GetUID()
+			delivered[uid] = append(delivered[uid], client.GetCID())
+		}
 	}
 
 	costMs := float64(time.Since(beginTime).Microseconds()) / 1000.0
 	if costMs >= 50 && kit.AllowByInterval(&h.lastSlowLogAt, 2*time.Second) {
-		logger.Warnf(ctx, "QueueHandler SendToUser slow=%0.2fms,pid=%s,target=%d,uids=%d,cids=%d,payload=%s", costMs, pid, len(finalClients), len(uids), len(cids), kit.LogSnippet(msg.Payload, 240))
+		logger.Warnf(ctx, "QueueHandler SendToUser slow=%0.2fms,target=%d,uids=%d,cids=%d,payload=%s", costMs, len(finalClients), len(uids), len(cids), kit.LogSnippet(msg.Payload, 240))
 	}
 	return
 }
@@ -0,0 +1,22 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/shared"
+)
+
+// StampHeader 在http或ws入口为消息附加消息头,记录消息ID,进入集群的时间和节点
+// 已经有消息ID的消息(如从缓存中重新投递的请求)保持原有的消息头,端到端耗时从第一次进入集群开始计算
+func StampHeader(m *clustermessage.AffairMsg) {
+	if m.Header == nil {
+		m.Header = &clustermessage.Header{}
+	}
+	if m.Header.MsgID != "" {
+		return
+	}
+	m.Header.MsgID = shared.GetSnowflakeNode().Generate().String()
+	m.Header.IngestAt = time.Now().UnixMicro()
+	m.Header.Node = shared.GetNodeID()
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+	"github.com/mtgnorton/ws-cluster/core/queue/slowlog"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slowDispatchMs 单条消息分发超过该耗时时记录到 SlowDispatches 并打印日志
+const slowDispatchMs = 50
+
+// Inspector 可以查看本节点运行状态的队列
+type Inspector interface {
+	Inspect(ctx context.Context) Inspection
+}
+
+// Inspection 队列在本节点上的运行状态
+type Inspection struct {
+	Type    string        `json:"type"`
+	Role    string        `json:"role,omitempty"` // 双写队列中的primary,secondary
+	Buffers []BufferStats `json:"buffers,omitempty"`
+	Streams []StreamStats `json:"streams,omitempty"`
+	Pool    *PoolStats    `json:"pool,omitempty"`
+	Queues  []Inspection  `json:"queues,omitempty"` // 双写队列的子队列
+}
+
+// BufferStats 本地发布缓冲区,redis队列每个分片一个
+type BufferStats struct {
+	Shard    int     `json:"shard"`
+	Len      int     `json:"len"`
+	Cap      int     `json:"cap"`
+	Overflow int     `json:"overflow"` // 溢出缓冲区中的消息数量,只有spill策略时有值
+	Fill     float64 `json:"fill"`     // 本地发布缓冲区的使用率
+}
+
+// StreamStats redis stream的长度和本节点的消费位置
+type StreamStats struct {
+	Shard        int     `json:"shard"`
+	Stream       string  `json:"stream"`
+	Length       int64   `json:"length"`
+	LastEntryID  string  `json:"last_entry_id,omitempty"` // stream中最后一条消息的ID
+	LastReadID   string  `json:"last_read_id,omitempty"`  // 本节点最后消费的消息ID
+	ReadLagMs    float64 `json:"read_lag_ms"`             // 最后一条消息和最后消费的消息写入时间的差值
+	SpillPending bool    `json:"spill_pending,omitempty"` // 本地溢写文件中是否还有未重新写入的消息
+	Error        string  `json:"error,omitempty"`
+}
+
+// PoolStats redis连接池状态
+type PoolStats struct {
+	Hits       uint32 `json:"hits"`
+	Misses     uint32 `json:"misses"`
+	Timeouts   uint32 `json:"timeouts"`
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	StaleConns uint32 `json:"stale_conns"`
+}
+
+func NewPoolStats(client redis.UniversalClient) *PoolStats {
+	if client == nil {
+		return nil
+	}
+	stats := client.PoolStats()
+	return &PoolStats{
+		Hits:       stats.Hits,
+		Misses:     stats.Misses,
+		Timeouts:   stats.Timeouts,
+		TotalConns: stats.TotalConns,
+		IdleConns:  stats.IdleConns,
+		StaleConns: stats.StaleConns,
+	}
+}
+
+func (b *publishBuffer) Stats(shard int) BufferStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := BufferStats{
+		Shard:    shard,
+		Len:      len(b.ch),
+		Cap:      cap(b.ch),
+		Overflow: b.overflow.Len(),
+	}
+	if stats.Cap > 0 {
+		stats.Fill = float64(stats.Len) / float64(stats.Cap)
+	}
+	return stats
+}
+
+// recordSlowDispatch 记录较慢的分发,每一条都会记录,不受日志限流的影响
+func recordSlowDispatch(opts option.Options, source, sourceID string, msg *clustermessage.AffairMsg, dispatchMs float64) {
+	if opts.SlowDispatches == nil {
+		return
+	}
+	e := slowlog.Entry{
+		At:         time.Now(),
+		Source:     source,
+		SourceID:   sourceID,
+		Type:       string(msg.Type),
+		PID:        msg.PID(),
+		AffairID:   msg.AffairID,
+		DispatchMs: dispatchMs,
+		Payload:    kit.LogSnippet(msg.Payload, 240),
+	}
+	if msg.Header != nil {
+		e.MsgID = msg.Header.MsgID
+	}
+	opts.SlowDispatches.Add(e)
+}
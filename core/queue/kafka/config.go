@@ -0,0 +1,173 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+const (
+	OffsetNewest = "newest" // 新的消费组从最新的消息开始消费,不会把历史消息推送给在线用户
+	OffsetOldest = "oldest" // 新的消费组从最早的消息开始消费
+)
+
+const (
+	MechanismPlain       = "PLAIN"
+	MechanismScramSHA256 = "SCRAM-SHA-256"
+	MechanismScramSHA512 = "SCRAM-SHA-512"
+)
+
+type Config struct {
+	Brokers       []string
+	Version       string // kafka版本,如 3.2.0,为空时使用 3.2.0
+	Topic         string
+	Group         string // 消费组,每个节点使用单独的消费组,所有节点都能收到所有消息
+	ClientID      string
+	InitialOffset string // 新的消费组从哪里开始消费 newest,oldest,为空时为newest
+
+	FlushMessages  int           // 生产者累计多少条消息后发送
+	FlushFrequency time.Duration // 生产者距离上次发送超过该时间后发送
+
+	SASL SASL
+	TLS  TLS
+}
+
+type SASL struct {
+	Enable    bool
+	Mechanism string // PLAIN,SCRAM-SHA-256,SCRAM-SHA-512
+	User      string
+	Password  string
+}
+
+type TLS struct {
+	Enable             bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// NewSaramaConfig 根据配置生成生产者和消费者共用的 sarama 配置
+func NewSaramaConfig(c Config) (*sarama.Config, error) {
+	cfg := sarama.NewConfig()
+	cfg.ClientID = c.ClientID
+	if cfg.ClientID == "" {
+		cfg.ClientID = "ws-cluster"
+	}
+	version := c.Version
+	if version == "" {
+		version = "3.2.0"
+	}
+	v, err := sarama.ParseKafkaVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Version = v
+
+	switch strings.ToLower(c.InitialOffset) {
+	case "", OffsetNewest:
+		cfg.Consumer.Offsets.Initial = sarama.OffsetNewest
+	case OffsetOldest:
+		cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	default:
+		return nil, fmt.Errorf("invalid initial offset:%s", c.InitialOffset)
+	}
+	cfg.Consumer.Return.Errors = true
+
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+	cfg.Producer.RequiredAcks = sarama.WaitForLocal
+	cfg.Producer.Flush.Messages = c.FlushMessages
+	cfg.Producer.Flush.Frequency = c.FlushFrequency
+	if cfg.Producer.Flush.Frequency <= 0 {
+		cfg.Producer.Flush.Frequency = 5 * time.Millisecond
+	}
+
+	if c.SASL.Enable {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.User = c.SASL.User
+		cfg.Net.SASL.Password = c.SASL.Password
+		switch strings.ToUpper(c.SASL.Mechanism) {
+		case "", MechanismPlain:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case MechanismScramSHA256:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha256.New}
+			}
+		case MechanismScramSHA512:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha512.New}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported sasl mechanism:%s", c.SASL.Mechanism)
+		}
+	}
+
+	if c.TLS.Enable {
+		tlsConfig, err := newTLSConfig(c.TLS)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+	return cfg, cfg.Validate()
+}
+
+func newTLSConfig(c TLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificate found in ca file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// scramClient 实现 sarama.SCRAMClient
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (s *scramClient) Begin(userName, password, authzID string) (err error) {
+	s.Client, err = s.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	s.ClientConversation = s.Client.NewConversation()
+	return nil
+}
+
+func (s *scramClient) Step(challenge string) (string, error) {
+	return s.ClientConversation.Step(challenge)
+}
+
+func (s *scramClient) Done() bool {
+	return s.ClientConversation.Done()
+}
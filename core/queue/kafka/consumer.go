@@ -2,55 +2,122 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/shared/kit/retry"
 
 	"github.com/IBM/sarama"
 )
 
-type ConsumerGroupHandler interface {
-	sarama.ConsumerGroupHandler
-	WaitReady()
-	Reset()
-}
+// Handler 处理一条消息,返回true时提交该消息的位置
+type Handler func(msg *sarama.ConsumerMessage) (isAck bool)
 
+// ConsumerGroup 消费组出错时按退避时间重新加入,不会因为broker不可用而退出
 type ConsumerGroup struct {
-	cg sarama.ConsumerGroup
+	cg      sarama.ConsumerGroup
+	topics  []string
+	handler *groupHandler
+	onError func(err error)
+	closing chan struct{}
+	done    chan struct{}
 }
 
-type ConsumerSessionMessage struct {
-	Session sarama.ConsumerGroupSession
-	Message *sarama.ConsumerMessage
+// NewConsumerGroup 创建消费组并在后台开始消费,直到ctx结束或Close
+// onError 用于记录消费过程中的错误,可以为空
+func NewConsumerGroup(ctx context.Context, c Config, handler Handler, onError func(err error)) (*ConsumerGroup, error) {
+	cfg, err := NewSaramaConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	cg, err := sarama.NewConsumerGroup(c.Brokers, c.Group, cfg)
+	if err != nil {
+		return nil, err
+	}
+	g := &ConsumerGroup{
+		cg:      cg,
+		topics:  []string{c.Topic},
+		handler: &groupHandler{handle: handler},
+		onError: onError,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go g.drainErrors()
+	go g.run(ctx)
+	return g, nil
 }
 
-func (c *ConsumerGroup) Close() error {
-	return c.cg.Close()
+func (g *ConsumerGroup) run(ctx context.Context) {
+	defer close(g.done)
+	backoff := retry.NewBackoff(retry.WithMin(500*time.Millisecond), retry.WithMax(30*time.Second), retry.WithJitter(true))
+	for {
+		err := g.cg.Consume(ctx, g.topics, g.handler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) || ctx.Err() != nil {
+			return
+		}
+		wait := time.Duration(0)
+		if err != nil {
+			g.reportError(err)
+			wait = backoff.Duration()
+		} else {
+			// 重新平衡后正常返回,立即重新加入
+			backoff.Reset()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.closing:
+			return
+		case <-time.After(wait):
+		}
+	}
 }
 
-func NewConsumerGroup(ctx context.Context, broker string, topics []string, group string, handler ConsumerGroupHandler) (*ConsumerGroup, error) {
-	cfg := sarama.NewConfig()
-	cfg.Version = sarama.V3_2_0_0
-	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
-	groupClient, err := sarama.NewConsumerGroup([]string{broker}, group, cfg)
-	if err != nil {
-		return nil, err
+func (g *ConsumerGroup) drainErrors() {
+	for err := range g.cg.Errors() {
+		g.reportError(err)
 	}
+}
 
-	go func() {
-		for {
-			err := groupClient.Consume(ctx, topics, handler)
-			if err != nil {
-				if err == sarama.ErrClosedConsumerGroup {
-					break
-				} else {
-					panic(err)
-				}
-			}
-			handler.Reset()
-		}
-	}()
+func (g *ConsumerGroup) reportError(err error) {
+	if g.onError != nil {
+		g.onError(err)
+	}
+}
 
-	handler.WaitReady() // Await till the consumer has been set up
+// Close 等待正在处理的消息完成并提交位置后关闭
+func (g *ConsumerGroup) Close() error {
+	close(g.closing)
+	err := g.cg.Close()
+	<-g.done
+	return err
+}
+
+type groupHandler struct {
+	handle Handler
+}
 
-	return &ConsumerGroup{
-		cg: groupClient,
-	}, nil
+func (h *groupHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *groupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim 在每个分区的协程中按顺序处理消息
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if h.handle(msg) {
+				session.MarkMessage(msg, "")
+			}
+		}
+	}
 }
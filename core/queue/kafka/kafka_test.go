@@ -0,0 +1,196 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const testTopic = "ws_cluster_test"
+
+func TestNewSaramaConfig(t *testing.T) {
+	cfg, err := NewSaramaConfig(Config{Brokers: []string{"127.0.0.1:9092"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != sarama.V3_2_0_0 {
+		t.Fatalf("default version = %s", cfg.Version)
+	}
+	if cfg.Consumer.Offsets.Initial != sarama.OffsetNewest {
+		t.Fatalf("default initial offset = %d", cfg.Consumer.Offsets.Initial)
+	}
+	if !cfg.Producer.Return.Errors || !cfg.Producer.Return.Successes || !cfg.Consumer.Return.Errors {
+		t.Fatal("errors and successes should be returned")
+	}
+
+	cfg, err = NewSaramaConfig(Config{Version: "2.8.0", InitialOffset: OffsetOldest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != sarama.V2_8_0_0 {
+		t.Fatalf("version = %s", cfg.Version)
+	}
+	if cfg.Consumer.Offsets.Initial != sarama.OffsetOldest {
+		t.Fatalf("initial offset = %d", cfg.Consumer.Offsets.Initial)
+	}
+
+	for _, c := range []Config{
+		{Version: "abc"},
+		{InitialOffset: "latest"},
+		{SASL: SASL{Enable: true, Mechanism: "GSSAPI", User: "u", Password: "p"}},
+		{TLS: TLS{Enable: true, CAFile: "/not/exist/ca.pem"}},
+	} {
+		if _, err := NewSaramaConfig(c); err == nil {
+			t.Fatalf("config %+v should be invalid", c)
+		}
+	}
+}
+
+func TestNewSaramaConfigSecurity(t *testing.T) {
+	cfg, err := NewSaramaConfig(Config{
+		SASL: SASL{Enable: true, User: "u", Password: "p"},
+		TLS:  TLS{Enable: true, InsecureSkipVerify: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Net.SASL.Enable || cfg.Net.SASL.Mechanism != sarama.SASLTypePlaintext {
+		t.Fatalf("sasl = %+v", cfg.Net.SASL)
+	}
+	if !cfg.Net.TLS.Enable || !cfg.Net.TLS.Config.InsecureSkipVerify {
+		t.Fatal("tls should be enabled")
+	}
+
+	cfg, err = NewSaramaConfig(Config{SASL: SASL{Enable: true, Mechanism: "scram-sha-512", User: "u", Password: "p"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA512 {
+		t.Fatalf("mechanism = %s", cfg.Net.SASL.Mechanism)
+	}
+	client := cfg.Net.SASL.SCRAMClientGeneratorFunc()
+	if err := client.Begin("u", "p", ""); err != nil {
+		t.Fatal(err)
+	}
+	if first, err := client.Step(""); err != nil || first == "" {
+		t.Fatalf("first message = %q, err = %v", first, err)
+	}
+}
+
+func newTestProducer(t *testing.T, produceErr sarama.KError) *Producer {
+	t.Helper()
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetError(testTopic, 0, produceErr),
+	})
+	p, err := NewProducer(Config{
+		Brokers:        []string{broker.Addr()},
+		Topic:          testTopic,
+		FlushMessages:  10,
+		FlushFrequency: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestProducerPublishBatch(t *testing.T) {
+	p := newTestProducer(t, sarama.ErrNoError)
+	failed, err := p.PublishBatch(context.Background(), [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if err != nil || failed != 0 {
+		t.Fatalf("failed = %d, err = %v", failed, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	failed, err = p.PublishBatch(ctx, [][]byte{[]byte("a")})
+	if !errors.Is(err, context.Canceled) || failed != 1 {
+		t.Fatalf("canceled publish failed = %d, err = %v", failed, err)
+	}
+}
+
+func TestProducerPublishBatchError(t *testing.T) {
+	p := newTestProducer(t, sarama.ErrMessageSizeTooLarge)
+	failed, err := p.PublishBatch(context.Background(), [][]byte{[]byte("a"), []byte("b")})
+	if !errors.Is(err, sarama.ErrMessageSizeTooLarge) {
+		t.Fatalf("err = %v", err)
+	}
+	if failed != 2 {
+		t.Fatalf("failed = %d", failed)
+	}
+}
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func TestGroupHandlerMarksAckedMessages(t *testing.T) {
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 4)}
+	for i := int64(0); i < 4; i++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: testTopic, Offset: i}
+	}
+	close(claim.messages)
+
+	var handled []int64
+	h := &groupHandler{handle: func(msg *sarama.ConsumerMessage) bool {
+		handled = append(handled, msg.Offset)
+		return msg.Offset%2 == 0
+	}}
+	session := &fakeSession{ctx: context.Background()}
+	if err := h.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 4 {
+		t.Fatalf("handled = %v", handled)
+	}
+	if len(session.marked) != 2 || session.marked[0] != 0 || session.marked[1] != 2 {
+		t.Fatalf("marked = %v", session.marked)
+	}
+}
+
+func TestGroupHandlerStopsOnSessionDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage)}
+	h := &groupHandler{handle: func(*sarama.ConsumerMessage) bool { return true }}
+	done := make(chan error, 1)
+	go func() { done <- h.ConsumeClaim(&fakeSession{ctx: ctx}, claim) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ConsumeClaim should return after session done")
+	}
+}
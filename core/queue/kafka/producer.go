@@ -1,24 +1,61 @@
 package kafka
 
 import (
+	"context"
+	"errors"
+
 	"github.com/IBM/sarama"
 )
 
+// Producer 批量写入消息,返回写入失败的数量,sarama 会将同一批消息合并为尽量少的请求
 type Producer struct {
-	P sarama.AsyncProducer
+	p     sarama.SyncProducer
+	topic string
 }
 
-func NewProducer(broker string) (*Producer, error) {
-	p, err := sarama.NewAsyncProducer([]string{broker}, sarama.NewConfig())
+func NewProducer(c Config) (*Producer, error) {
+	cfg, err := NewSaramaConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	p, err := sarama.NewSyncProducer(c.Brokers, cfg)
 	if err != nil {
 		return nil, err
 	}
-	return &Producer{P: p}, nil
+	return newProducer(p, c.Topic), nil
+}
+
+func newProducer(p sarama.SyncProducer, topic string) *Producer {
+	return &Producer{p: p, topic: topic}
+}
+
+// PublishBatch 写入一批消息,err为其中一条失败的原因
+func (p *Producer) PublishBatch(ctx context.Context, messages [][]byte) (failed int, err error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return len(messages), err
+	}
+	batch := make([]*sarama.ProducerMessage, 0, len(messages))
+	for _, message := range messages {
+		batch = append(batch, &sarama.ProducerMessage{Topic: p.topic, Value: sarama.ByteEncoder(message)})
+	}
+	err = p.p.SendMessages(batch)
+	if err == nil {
+		return 0, nil
+	}
+	var producerErrors sarama.ProducerErrors
+	if errors.As(err, &producerErrors) && len(producerErrors) > 0 {
+		return len(producerErrors), producerErrors[0].Err
+	}
+	return len(messages), err
 }
 
+// Close 等待正在发送的消息完成后关闭
 func (p *Producer) Close() error {
-	if p != nil {
-		return p.P.Close()
+	if p == nil {
+		return nil
 	}
-	return nil
+	return p.p.Close()
 }
@@ -0,0 +1,140 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+)
+
+type Config struct {
+	URL      string        // nats地址,多个地址使用逗号分隔
+	Stream   string        // jetstream 名称
+	Subject  string        // 消息发布的subject
+	MaxAge   time.Duration // 消息在stream中保留的时间
+	Replicas int           // stream副本数量
+	Durable  string        // 持久化消费者名称,为空时使用临时消费者,只接收订阅之后的消息
+	Name     string        // 连接名称,便于在nats监控中区分节点
+
+	InactiveThreshold time.Duration // 持久化消费者没有订阅超过该时间后由nats删除,为0时不删除
+}
+
+// Handler 处理一条消息,publishedAt 为消息写入stream的时间,返回false时持久化消费者不进行ack,等待重新投递
+type Handler func(data []byte, publishedAt time.Time) (isAck bool)
+
+type JetStream struct {
+	cfg  Config
+	conn *natsgo.Conn
+	js   natsgo.JetStreamContext
+}
+
+// NewJetStream 连接nats并确保stream存在,stream配置变化时进行更新
+func NewJetStream(cfg Config) (*JetStream, error) {
+	conn, err := natsgo.Connect(cfg.URL, natsgo.Name(cfg.Name), natsgo.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream(natsgo.PublishAsyncMaxPending(4096))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	streamConfig := &natsgo.StreamConfig{
+		Name:      cfg.Stream,
+		Subjects:  []string{cfg.Subject},
+		Retention: natsgo.LimitsPolicy,
+		Storage:   natsgo.FileStorage,
+		MaxAge:    cfg.MaxAge,
+		Replicas:  max(cfg.Replicas, 1),
+	}
+	if _, err = js.StreamInfo(cfg.Stream); errors.Is(err, natsgo.ErrStreamNotFound) {
+		_, err = js.AddStream(streamConfig)
+	} else if err == nil {
+		_, err = js.UpdateStream(streamConfig)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &JetStream{cfg: cfg, conn: conn, js: js}, nil
+}
+
+// PublishBatch 异步发布一批消息并等待全部确认,返回失败的数量和最后一个错误
+func (j *JetStream) PublishBatch(ctx context.Context, messages [][]byte) (failed int, err error) {
+	futures := make([]natsgo.PubAckFuture, 0, len(messages))
+	for _, data := range messages {
+		future, publishErr := j.js.PublishAsync(j.cfg.Subject, data)
+		if publishErr != nil {
+			failed++
+			err = publishErr
+			continue
+		}
+		futures = append(futures, future)
+	}
+	for i, future := range futures {
+		select {
+		case <-future.Ok():
+		case publishErr := <-future.Err():
+			failed++
+			err = publishErr
+		case <-ctx.Done():
+			return failed + len(futures) - i, ctx.Err()
+		}
+	}
+	return failed, err
+}
+
+// Subscribe 每个节点使用独立的消费者实现广播,handler在同一个协程中按顺序调用
+func (j *JetStream) Subscribe(handler Handler) (*natsgo.Subscription, error) {
+	opts := []natsgo.SubOpt{natsgo.BindStream(j.cfg.Stream), natsgo.DeliverNew(), natsgo.AckNone()}
+	if j.cfg.Durable != "" {
+		// 持久化消费者需要预先创建,由订阅创建的消费者会在取消订阅或连接关闭时被删除
+		if err := j.ensureConsumer(); err != nil {
+			return nil, err
+		}
+		opts = []natsgo.SubOpt{natsgo.Bind(j.cfg.Stream, j.cfg.Durable), natsgo.ManualAck()}
+	}
+	return j.js.Subscribe(j.cfg.Subject, func(msg *natsgo.Msg) {
+		publishedAt := time.Now()
+		if meta, err := msg.Metadata(); err == nil {
+			publishedAt = meta.Timestamp
+		}
+		isAck := handler(msg.Data, publishedAt)
+		if j.cfg.Durable != "" && isAck {
+			_ = msg.Ack()
+		}
+	}, opts...)
+}
+
+func (j *JetStream) ensureConsumer() error {
+	info, err := j.js.ConsumerInfo(j.cfg.Stream, j.cfg.Durable)
+	if err == nil {
+		// 已有的消费者保留消费位置,只更新清理时间
+		if info.Config.InactiveThreshold == j.cfg.InactiveThreshold {
+			return nil
+		}
+		consumerConfig := info.Config
+		consumerConfig.InactiveThreshold = j.cfg.InactiveThreshold
+		_, err = j.js.UpdateConsumer(j.cfg.Stream, &consumerConfig)
+		return err
+	}
+	if !errors.Is(err, natsgo.ErrConsumerNotFound) {
+		return err
+	}
+	_, err = j.js.AddConsumer(j.cfg.Stream, &natsgo.ConsumerConfig{
+		Durable:           j.cfg.Durable,
+		DeliverSubject:    natsgo.NewInbox(),
+		DeliverPolicy:     natsgo.DeliverNewPolicy,
+		AckPolicy:         natsgo.AckExplicitPolicy,
+		FilterSubject:     j.cfg.Subject,
+		MaxAckPending:     10000,
+		InactiveThreshold: j.cfg.InactiveThreshold,
+	})
+	return err
+}
+
+// Close 发送缓冲区中的消息后关闭连接
+func (j *JetStream) Close() error {
+	return j.conn.Drain()
+}
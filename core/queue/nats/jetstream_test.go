@@ -0,0 +1,154 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+func runServer(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func newJetStream(t *testing.T, s *server.Server, durable string) *JetStream {
+	t.Helper()
+	j, err := NewJetStream(Config{
+		URL:     s.ClientURL(),
+		Stream:  "ws_cluster_test",
+		Subject: "ws_cluster.test",
+		MaxAge:  time.Minute,
+		Durable: durable,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = j.Close() })
+	return j
+}
+
+type collector struct {
+	mu       sync.Mutex
+	messages []string
+	lagOK    bool
+}
+
+func (c *collector) handle(data []byte, publishedAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, string(data))
+	c.lagOK = time.Since(publishedAt) < time.Minute
+	return true
+}
+
+func (c *collector) wait(t *testing.T, count int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		if len(c.messages) >= count {
+			messages := append([]string(nil), c.messages...)
+			c.mu.Unlock()
+			return messages
+		}
+		c.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.Fatalf("expect %d messages, got %d", count, len(c.messages))
+	return nil
+}
+
+func batch(from, to int) [][]byte {
+	messages := make([][]byte, 0, to-from)
+	for i := from; i < to; i++ {
+		messages = append(messages, []byte(fmt.Sprintf("m%d", i)))
+	}
+	return messages
+}
+
+func TestJetStreamBroadcast(t *testing.T) {
+	s := runServer(t)
+	publisher := newJetStream(t, s, "")
+
+	// 两个节点各自的临时消费者都应按顺序收到全部消息
+	collectors := []*collector{{}, {}}
+	for _, c := range collectors {
+		sub, err := newJetStream(t, s, "").Subscribe(c.handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = sub.Unsubscribe() })
+	}
+
+	failed, err := publisher.PublishBatch(context.Background(), batch(0, 200))
+	if err != nil || failed != 0 {
+		t.Fatalf("publish failed:%d err:%v", failed, err)
+	}
+	for _, c := range collectors {
+		messages := c.wait(t, 200)
+		for i, m := range messages {
+			if m != fmt.Sprintf("m%d", i) {
+				t.Fatalf("message %d out of order:%s", i, m)
+			}
+		}
+		if !c.lagOK {
+			t.Fatal("unexpected publish timestamp")
+		}
+	}
+}
+
+func TestJetStreamDurableResume(t *testing.T) {
+	s := runServer(t)
+	publisher := newJetStream(t, s, "")
+
+	first := &collector{}
+	consumer := newJetStream(t, s, "node-1")
+	if _, err := consumer.Subscribe(first.handle); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publisher.PublishBatch(context.Background(), batch(0, 10)); err != nil {
+		t.Fatal(err)
+	}
+	first.wait(t, 10)
+	// 关闭连接模拟节点重启,持久化消费者应当保留
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := publisher.PublishBatch(context.Background(), batch(10, 20)); err != nil {
+		t.Fatal(err)
+	}
+	second := &collector{}
+	sub, err := newJetStream(t, s, "node-1").Subscribe(second.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sub.Unsubscribe() })
+	messages := second.wait(t, 10)
+	if messages[0] != "m10" || messages[9] != "m19" {
+		t.Fatalf("durable consumer should resume from m10, got %v", messages)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"time"
 
-	"github.com/mtgnorton/ws-cluster/shared"
 	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
 
 	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
 	"github.com/mtgnorton/ws-cluster/core/queue/qtype"
+	"github.com/mtgnorton/ws-cluster/core/queue/slowlog"
+	"github.com/mtgnorton/ws-cluster/core/tap"
 
 	"github.com/mtgnorton/ws-cluster/logger"
 
@@ -26,7 +29,11 @@ type Options struct {
 	Logger             logger.Logger
 	Handlers           map[clustermessage.Type]handler.Handle
 	Prometheus         *wsprometheus.Prometheus
-	RedisClient        *redis.Client
+	Tracer             *wstrace.Tracer
+	RedisClient        redis.UniversalClient // 为空时redis队列使用默认的队列redis,其他队列不依赖redis
+	DeadLetter         *deadletter.DeadLetter
+	Tap                *tap.Tap
+	SlowDispatches     *slowlog.Ring // 最近较慢的消息分发
 	PublishWorkerCount int
 	PublishBatchSize   int
 	PublishTickerMs    time.Duration
@@ -39,11 +46,14 @@ func NewOptions(opts ...Option) Options {
 		Ctx:                context.Background(),
 		Config:             config.DefaultConfig,
 		Topic:              qtype.TopicDefault,
-		Logger:             logger.DefaultLogger,
+		Logger:             logger.DefaultLogger.WithModule(logger.ModuleQueue),
 		Handlers:           make(map[clustermessage.Type]handler.Handle),
 		Prometheus:         wsprometheus.DefaultPrometheus,
-		RedisClient:        shared.GetDefaultRedisQueue(),
-		PublishWorkerCount: 1, // 考虑消息顺序问题暂时不开启多worker
+		Tracer:             wstrace.DefaultTracer,
+		DeadLetter:         deadletter.DefaultDeadLetter,
+		Tap:                tap.DefaultTap,
+		SlowDispatches:     slowlog.DefaultRing,
+		PublishWorkerCount: 1, // 考虑消息顺序问题暂时不开启多worker,redis队列按分片开启worker
 		PublishBatchSize:   500,
 		PublishTickerMs:    5 * time.Millisecond,
 	}
@@ -57,6 +67,7 @@ func NewOptions(opts ...Option) Options {
 		clustermessage.TypeConnect:       sendToServerHandler,
 		clustermessage.TypeDisconnect:    sendToServerHandler,
 		clustermessage.TypeOnlineClients: sendToServerHandler,
+		clustermessage.TypeKick:          handler.NewKickHandler(),
 	}
 	for _, o := range opts {
 		o(&options)
@@ -76,3 +87,9 @@ func WithConfig(c config.Config) Option {
 		o.Config = c
 	}
 }
+
+func WithRedisClient(client redis.UniversalClient) Option {
+	return func(o *Options) {
+		o.RedisClient = client
+	}
+}
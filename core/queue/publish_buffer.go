@@ -0,0 +1,201 @@
+package queue
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+)
+
+const (
+	PublishPolicyBlock = "block" // 本地发布缓冲区满时一直等待,直到写入或调用方取消
+	PublishPolicyDrop  = "drop"  // 本地发布缓冲区满时最多等待 TimeoutMs,超时后丢弃并返回错误
+	PublishPolicySpill = "spill" // 本地发布缓冲区满时写入溢出缓冲区,溢出缓冲区也满时丢弃并返回错误
+)
+
+const (
+	DropReasonTimeout  = "timeout"  // 等待本地发布缓冲区超时
+	DropReasonCanceled = "canceled" // 调用方取消
+	DropReasonOverflow = "overflow" // 溢出缓冲区已满
+)
+
+// PublishDropError 消息没有进入队列,调用方可以稍后重试
+type PublishDropError struct {
+	Reason string
+}
+
+func (e *PublishDropError) Error() string {
+	return "queue publish dropped: " + e.Reason
+}
+
+// IsPublishDropped 判断错误是否为消息被丢弃
+func IsPublishDropped(err error) bool {
+	var dropErr *PublishDropError
+	return errors.As(err, &dropErr)
+}
+
+type publishItem struct {
+	msg        *clustermessage.AffairMsg
+	enqueuedAt time.Time
+}
+
+// publishBuffer 队列的本地发布缓冲区,按配置的策略处理缓冲区满的情况
+// 溢出缓冲区不为空时新的消息也写入溢出缓冲区,保证消息顺序
+type publishBuffer struct {
+	name         string // 日志前缀,如 Redis,Nats
+	opts         option.Options
+	ch           chan *publishItem
+	metricLabels []string
+	lastSlowLog  atomic.Int64
+
+	mu       sync.Mutex
+	overflow *list.List // 溢出缓冲区 value:*publishItem
+	notify   chan struct{}
+}
+
+func newPublishBuffer(name string, opts option.Options, size int, metricLabels []string) *publishBuffer {
+	b := &publishBuffer{
+		name:         name,
+		opts:         opts,
+		ch:           make(chan *publishItem, size),
+		metricLabels: metricLabels,
+		overflow:     list.New(),
+		notify:       make(chan struct{}, 1),
+	}
+	if b.policy() == PublishPolicySpill {
+		go b.infiniteDrainOverflow(opts.Ctx)
+	}
+	return b
+}
+
+func (b *publishBuffer) C() <-chan *publishItem {
+	return b.ch
+}
+
+// Len 本地发布缓冲区和溢出缓冲区中的消息数量
+func (b *publishBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.ch) + b.overflow.Len()
+}
+
+func (b *publishBuffer) Cap() int {
+	return cap(b.ch)
+}
+
+func (b *publishBuffer) policy() string {
+	policy := b.opts.Config.Values().Queue.Publish.Policy
+	if policy == "" {
+		return PublishPolicyDrop
+	}
+	return policy
+}
+
+func (b *publishBuffer) Put(ctx context.Context, m *clustermessage.AffairMsg) error {
+	beginTime := time.Now()
+	item := &publishItem{msg: m, enqueuedAt: beginTime}
+	policy := b.policy()
+	if policy == PublishPolicySpill {
+		return b.putOrSpill(ctx, item)
+	}
+	select {
+	case b.ch <- item:
+		_ = b.opts.Prometheus.GetObserve(wsprometheus.MetricQueuePublishWaitDuration, b.metricLabels, float64(time.Since(beginTime).Microseconds())/1000.0)
+		return nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if policy != PublishPolicyBlock {
+		timeoutMs := b.opts.Config.Values().Queue.Publish.TimeoutMs
+		timer := time.NewTimer(time.Duration(kit.IfElse(timeoutMs > 0, timeoutMs, 5000)) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case b.ch <- item:
+		waitMs := float64(time.Since(beginTime).Microseconds()) / 1000.0
+		_ = b.opts.Prometheus.GetObserve(wsprometheus.MetricQueuePublishWaitDuration, b.metricLabels, waitMs)
+		if waitMs >= 100 && kit.AllowByInterval(&b.lastSlowLog, 2*time.Second) {
+			b.opts.Logger.Warnf(ctx, "%s-Publish local queue wait=%0.2fms,len=%d,cap=%d,type=%s,payload=%s", b.name, waitMs, len(b.ch), cap(b.ch), m.Type, kit.LogSnippet(m.Payload, 240))
+		}
+		return nil
+	case <-ctx.Done():
+		return b.drop(ctx, m, DropReasonCanceled)
+	case <-timeout:
+		return b.drop(ctx, m, DropReasonTimeout)
+	}
+}
+
+func (b *publishBuffer) putOrSpill(ctx context.Context, item *publishItem) error {
+	b.mu.Lock()
+	if b.overflow.Len() == 0 {
+		select {
+		case b.ch <- item:
+			b.mu.Unlock()
+			_ = b.opts.Prometheus.GetObserve(wsprometheus.MetricQueuePublishWaitDuration, b.metricLabels, float64(time.Since(item.enqueuedAt).Microseconds())/1000.0)
+			return nil
+		default:
+		}
+	}
+	maxLen := b.opts.Config.Values().Queue.Publish.SpillMaxLen
+	if b.overflow.Len() >= kit.IfElse(maxLen > 0, maxLen, 100000) {
+		b.mu.Unlock()
+		return b.drop(ctx, item.msg, DropReasonOverflow)
+	}
+	b.overflow.PushBack(item)
+	spilled := b.overflow.Len()
+	b.mu.Unlock()
+
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+	if kit.AllowByInterval(&b.lastSlowLog, 2*time.Second) {
+		b.opts.Logger.Warnf(ctx, "%s-Publish local queue full,spill to overflow,len=%d,overflow=%d", b.name, len(b.ch), spilled)
+	}
+	return nil
+}
+
+// infiniteDrainOverflow 本地发布缓冲区有空间时,按顺序将溢出缓冲区的消息移回
+func (b *publishBuffer) infiniteDrainOverflow(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.notify:
+		case <-ticker.C:
+		}
+		b.mu.Lock()
+		for b.overflow.Len() > 0 {
+			front := b.overflow.Front()
+			select {
+			case b.ch <- front.Value.(*publishItem):
+				b.overflow.Remove(front)
+				continue
+			default:
+			}
+			break
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *publishBuffer) drop(ctx context.Context, m *clustermessage.AffairMsg, reason string) error {
+	p := b.opts.Prometheus
+	_ = p.GetAdd(wsprometheus.MetricQueueDrop, b.metricLabels, 1)
+	_ = p.GetAdd(wsprometheus.MetricProjectDrop, []string{b.metricLabels[0], b.metricLabels[1], p.PIDLabel(m.PID()), reason}, 1)
+	if kit.AllowByInterval(&b.lastSlowLog, 2*time.Second) {
+		b.opts.Logger.Warnf(ctx, "%s-Publish %s, drop msg type:%s,payload:%s", b.name, reason, m.Type, kit.LogSnippet(m.Payload, 240))
+	}
+	return &PublishDropError{Reason: reason}
+}
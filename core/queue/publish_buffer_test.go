@@ -0,0 +1,183 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type testConfig struct {
+	values config.Values
+}
+
+func (c *testConfig) Values() *config.Values {
+	return &c.values
+}
+
+var (
+	testPrometheusOnce sync.Once
+	testPrometheus     *wsprometheus.Prometheus
+)
+
+// enabledPrometheus 指标注册在全局的registry中,整个测试进程只能初始化一次
+func enabledPrometheus() *wsprometheus.Prometheus {
+	testPrometheusOnce.Do(func() {
+		c := &testConfig{}
+		c.values.Prometheus = config.Prometheus{Enable: true, Path: "/queue_test_metrics", Addr: "127.0.0.1:0"}
+		testPrometheus = wsprometheus.New(wsprometheus.WithConfig(c), wsprometheus.WithManager(wsprometheus.NewManager()))
+		testPrometheus.Init()
+	})
+	return testPrometheus
+}
+
+// newTestPublishBuffer 创建容量为1的发布缓冲区,metricLabels使用策略名区分各测试的丢弃计数
+func newTestPublishBuffer(t *testing.T, publish config.QueuePublish) (*publishBuffer, []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	c := &testConfig{}
+	c.values.Queue.Publish = publish
+	opts := option.NewOptions(option.WithContext(ctx), option.WithConfig(c))
+	opts.Prometheus = enabledPrometheus()
+	labels := []string{"1", publish.Policy}
+	return newPublishBuffer("Test", opts, 1, labels), labels
+}
+
+func dropCount(t *testing.T, labels []string) float64 {
+	return counterValue(t, wsprometheus.MetricQueueDrop, labels)
+}
+
+// counterValue 按node,ip两个label读取计数器的当前值
+func counterValue(t *testing.T, name string, labels []string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			values := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				values[label.GetName()] = label.GetValue()
+			}
+			if values["node"] == labels[0] && values["ip"] == labels[1] {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func testMsg(affairID string) *clustermessage.AffairMsg {
+	return &clustermessage.AffairMsg{AffairID: affairID, Type: clustermessage.TypePush, To: &clustermessage.To{PID: "p1"}}
+}
+
+func assertDropped(t *testing.T, err error, reason string) {
+	t.Helper()
+	if !IsPublishDropped(err) {
+		t.Fatalf("want dropped error, got %v", err)
+	}
+	var dropErr *PublishDropError
+	_ = errors.As(err, &dropErr)
+	if dropErr.Reason != reason {
+		t.Fatalf("drop reason = %s, want %s", dropErr.Reason, reason)
+	}
+}
+
+func TestPublishBufferBlock(t *testing.T) {
+	b, labels := newTestPublishBuffer(t, config.QueuePublish{Policy: PublishPolicyBlock, TimeoutMs: 10})
+	ctx := context.Background()
+	drops := dropCount(t, labels)
+	if err := b.Put(ctx, testMsg("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// block策略不受 TimeoutMs 影响,一直等待到调用方取消
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	beginTime := time.Now()
+	err := b.Put(waitCtx, testMsg("2"))
+	if waited := time.Since(beginTime); waited < 100*time.Millisecond {
+		t.Fatalf("block policy returned after %v", waited)
+	}
+	assertDropped(t, err, DropReasonCanceled)
+	if b.Len() != 1 || b.Stats(0).Overflow != 0 {
+		t.Fatalf("len = %d, overflow = %d", b.Len(), b.Stats(0).Overflow)
+	}
+	if got := dropCount(t, labels) - drops; got != 1 {
+		t.Fatalf("drop metric increased %v, want 1", got)
+	}
+
+	// 缓冲区有空间后等待中的消息写入
+	done := make(chan error, 1)
+	go func() { done <- b.Put(ctx, testMsg("3")) }()
+	<-b.C()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if item := <-b.C(); item.msg.AffairID != "3" {
+		t.Fatalf("got %s, want 3", item.msg.AffairID)
+	}
+}
+
+func TestPublishBufferDrop(t *testing.T) {
+	b, labels := newTestPublishBuffer(t, config.QueuePublish{Policy: PublishPolicyDrop, TimeoutMs: 20})
+	ctx := context.Background()
+	drops := dropCount(t, labels)
+	if err := b.Put(ctx, testMsg("1")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		assertDropped(t, b.Put(ctx, testMsg("2")), DropReasonTimeout)
+	}
+	if b.Len() != 1 || b.Stats(0).Overflow != 0 {
+		t.Fatalf("len = %d, overflow = %d", b.Len(), b.Stats(0).Overflow)
+	}
+	if got := dropCount(t, labels) - drops; got != 2 {
+		t.Fatalf("drop metric increased %v, want 2", got)
+	}
+}
+
+func TestPublishBufferSpill(t *testing.T) {
+	b, labels := newTestPublishBuffer(t, config.QueuePublish{Policy: PublishPolicySpill, SpillMaxLen: 2})
+	ctx := context.Background()
+	drops := dropCount(t, labels)
+	for _, id := range []string{"1", "2", "3"} {
+		if err := b.Put(ctx, testMsg(id)); err != nil {
+			t.Fatalf("put %s: %v", id, err)
+		}
+	}
+	if stats := b.Stats(0); stats.Len != 1 || stats.Overflow != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	assertDropped(t, b.Put(ctx, testMsg("4")), DropReasonOverflow)
+	if got := dropCount(t, labels) - drops; got != 1 {
+		t.Fatalf("drop metric increased %v, want 1", got)
+	}
+
+	// 溢出的消息按顺序移回本地发布缓冲区
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case item := <-b.C():
+			if item.msg.AffairID != want {
+				t.Fatalf("got %s, want %s", item.msg.AffairID, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait %s timeout", want)
+		}
+	}
+	if b.Len() != 0 {
+		t.Fatalf("len = %d after drain", b.Len())
+	}
+}
@@ -39,20 +39,38 @@ const (
 	QueueTypeRedisGroup = "redis_group"
 
 	QueueTypeKafka = "kafka"
+
+	QueueTypeNats = "nats"
+
+	QueueTypeMemory = config.QueueMemory
+
+	QueueTypeDual = "dual"
 )
 
 var once sync.Once
 
 func GetQueueInstance(c config.Config) Queue {
 	once.Do(func() {
-		switch c.Values().Queue.Use {
-		case QueueTypeKafka:
-			QueueInstance = NewKafkaQueue(option.WithConfig(c))
-		case QueueTypeRedisGroup:
-			QueueInstance = NewRedisGroupQueue(option.WithConfig(c))
-		default:
-			QueueInstance = NewRedisQueue(option.WithConfig(c))
+		if c.Values().Queue.Use == QueueTypeDual {
+			QueueInstance = NewDualQueue(option.WithConfig(c))
+			return
 		}
+		QueueInstance = newQueue(c.Values().Queue.Use, option.WithConfig(c))
 	})
 	return QueueInstance
 }
+
+func newQueue(use string, opts ...option.Option) Queue {
+	switch use {
+	case QueueTypeKafka:
+		return NewKafkaQueue(opts...)
+	case QueueTypeMemory:
+		return NewMemoryQueue(opts...)
+	case QueueTypeNats:
+		return NewNatsQueue(opts...)
+	case QueueTypeRedisGroup:
+		return NewRedisGroupQueue(opts...)
+	default:
+		return NewRedisQueue(opts...)
+	}
+}
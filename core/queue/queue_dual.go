@@ -0,0 +1,207 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/queue/handler"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/shared/kit/lru"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DualConsumePrimary   = "primary"   // 只消费主队列
+	DualConsumeSecondary = "secondary" // 只消费从队列
+	DualConsumeBoth      = "both"      // 两个队列都消费,按消息ID去重
+)
+
+// dualConsumeKey 集群当前的消费来源,各节点定期读取,切换后所有节点在 dualSyncInterval 内生效
+const (
+	dualConsumeKey   = "ws_cluster:queue_dual_consume"
+	dualSyncInterval = time.Second
+)
+
+// DualSwitcher 可以在运行时切换消费来源的队列
+type DualSwitcher interface {
+	ConsumeSource() string
+	SetConsumeSource(ctx context.Context, source string) error
+}
+
+// dualQueue 同时写入主从两个队列,用于不停机迁移队列
+// 迁移步骤: consume=primary 同时写入 -> 切换为both -> 切换为secondary -> 修改配置只使用从队列
+type dualQueue struct {
+	opts         option.Options
+	primary      Queue
+	secondary    Queue
+	consume      atomic.Value // string
+	redis        redis.UniversalClient
+	metricLabels []string
+	lastLogAt    atomic.Int64
+
+	mu   sync.Mutex
+	seen *lru.Simple[string, struct{}] // 最近处理过的消息ID
+}
+
+func NewDualQueue(opts ...option.Option) Queue {
+	options := option.NewOptions(opts...)
+	c := options.Config.Values().Queue.Dual
+	seen, _ := lru.NewSimple[string, struct{}](kit.IfElse(c.DedupeSize > 0, c.DedupeSize, 100000))
+	q := &dualQueue{
+		opts:         options,
+		redis:        shared.GetRedis(),
+		metricLabels: []string{strconv.FormatInt(shared.GetNodeID(), 10), shared.GetInternalIP()},
+		seen:         seen,
+	}
+	q.consume.Store(kit.IfElse(validDualSource(c.Consume), c.Consume, DualConsumePrimary))
+	q.syncConsumeSource(options.Ctx)
+
+	// 子队列的处理函数替换为先判断消费来源并去重
+	q.primary = newQueue(c.Primary, append(opts, q.wrapHandlers(DualConsumePrimary))...)
+	q.secondary = newQueue(c.Secondary, append(opts, q.wrapHandlers(DualConsumeSecondary))...)
+	go q.infiniteSyncConsumeSource(options.Ctx)
+	options.Logger.Infof(options.Ctx, "Dual queue primary:%s,secondary:%s,consume:%s", c.Primary, c.Secondary, q.ConsumeSource())
+	return q
+}
+
+func validDualSource(source string) bool {
+	return source == DualConsumePrimary || source == DualConsumeSecondary || source == DualConsumeBoth
+}
+
+func (q *dualQueue) wrapHandlers(source string) option.Option {
+	return func(o *option.Options) {
+		handlers := make(map[clustermessage.Type]handler.Handle, len(o.Handlers))
+		for msgType, h := range o.Handlers {
+			handlers[msgType] = &dualHandler{queue: q, source: source, next: h}
+		}
+		o.Handlers = handlers
+	}
+}
+
+func (q *dualQueue) Options() option.Options {
+	return q.opts
+}
+
+// Publish 写入主队列和从队列,只有主队列写入失败时返回错误
+// Inspect 主从两个队列的状态
+func (q *dualQueue) Inspect(ctx context.Context) Inspection {
+	inspection := Inspection{Type: QueueTypeDual}
+	for i, child := range []Queue{q.primary, q.secondary} {
+		if inspector, ok := child.(Inspector); ok {
+			childInspection := inspector.Inspect(ctx)
+			childInspection.Role = kit.IfElse(i == 0, DualConsumePrimary, DualConsumeSecondary)
+			inspection.Queues = append(inspection.Queues, childInspection)
+		}
+	}
+	return inspection
+}
+
+func (q *dualQueue) Publish(ctx context.Context, m *clustermessage.AffairMsg) (err error) {
+	// 没有经过入口的消息(如节点内部产生的消息)在这里附加消息头,两个队列使用相同的消息ID
+	StampHeader(m)
+	ctx, span := startPublishSpan(ctx, q.opts, QueueTypeDual, m)
+	defer func() { wstrace.End(span, err) }()
+	if err := q.primary.Publish(ctx, m); err != nil {
+		return err
+	}
+	if err := q.secondary.Publish(ctx, m); err != nil {
+		_ = q.opts.Prometheus.GetAdd(wsprometheus.MetricQueueDrop, q.metricLabels, 1)
+		if kit.AllowByInterval(&q.lastLogAt, 2*time.Second) {
+			q.opts.Logger.Warnf(ctx, "Dual-Publish secondary error:%v,msg_id:%s", err, m.Header.MsgID)
+		}
+	}
+	return nil
+}
+
+// Consume 子队列创建时已经开始消费,这里只等待结束
+func (q *dualQueue) Consume(ctx context.Context, _ interface{}) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (q *dualQueue) ConsumeSource() string {
+	return q.consume.Load().(string)
+}
+
+// SetConsumeSource 切换整个集群的消费来源
+func (q *dualQueue) SetConsumeSource(ctx context.Context, source string) error {
+	if !validDualSource(source) {
+		return fmt.Errorf("invalid consume source:%s", source)
+	}
+	if err := q.redis.Set(ctx, dualConsumeKey, source, 0).Err(); err != nil {
+		return err
+	}
+	q.setConsumeSource(ctx, source)
+	return nil
+}
+
+func (q *dualQueue) setConsumeSource(ctx context.Context, source string) {
+	if old := q.consume.Swap(source); old != source {
+		q.opts.Logger.Infof(ctx, "Dual queue consume source %s -> %s", old, source)
+	}
+}
+
+func (q *dualQueue) syncConsumeSource(ctx context.Context) {
+	source, err := q.redis.Get(ctx, dualConsumeKey).Result()
+	if err != nil {
+		if err != redis.Nil && kit.AllowByInterval(&q.lastLogAt, 2*time.Second) {
+			q.opts.Logger.Warnf(ctx, "Dual queue read consume source error:%v", err)
+		}
+		return
+	}
+	if validDualSource(source) {
+		q.setConsumeSource(ctx, source)
+	}
+}
+
+func (q *dualQueue) infiniteSyncConsumeSource(ctx context.Context) {
+	ticker := time.NewTicker(dualSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.syncConsumeSource(ctx)
+		}
+	}
+}
+
+// firstSeen 记录消息ID,返回该消息是否第一次出现
+func (q *dualQueue) firstSeen(msgID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.seen.Contains(msgID) {
+		return false
+	}
+	q.seen.Add(msgID, struct{}{})
+	return true
+}
+
+type dualHandler struct {
+	queue  *dualQueue
+	source string
+	next   handler.Handle
+}
+
+// Handle 不属于当前消费来源的消息直接确认,重复的消息只处理一次
+func (h *dualHandler) Handle(ctx context.Context, msg *clustermessage.AffairMsg) (isAck bool) {
+	current := h.queue.ConsumeSource()
+	if current != DualConsumeBoth && current != h.source {
+		return true
+	}
+	if msg.Header != nil && msg.Header.MsgID != "" && !h.queue.firstSeen(msg.Header.MsgID) {
+		return true
+	}
+	return h.next.Handle(ctx, msg)
+}
@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/queue/handler"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+	"github.com/mtgnorton/ws-cluster/shared"
+)
+
+type recordHandler struct {
+	mu        sync.Mutex
+	affairIDs []string
+}
+
+func (h *recordHandler) Handle(_ context.Context, msg *clustermessage.AffairMsg) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.affairIDs = append(h.affairIDs, msg.AffairID)
+	return true
+}
+
+func (h *recordHandler) handled() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.affairIDs...)
+}
+
+// 两个内存队列同时写入,both 模式下同一条消息只处理一次,切换为 secondary 后只处理从队列的消息
+func TestDualQueueConsumeSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 消费来源保存在redis中,清理其他测试或者节点留下的值
+	shared.GetRedis().Del(ctx, dualConsumeKey)
+	defer shared.GetRedis().Del(context.Background(), dualConsumeKey)
+
+	c := &testConfig{}
+	c.values.Queue.Dual = config.DualQueue{Primary: QueueTypeMemory, Secondary: QueueTypeMemory, Consume: DualConsumeBoth}
+	h := &recordHandler{}
+	q := NewDualQueue(option.WithContext(ctx), option.WithConfig(c), func(o *option.Options) {
+		o.Handlers = map[clustermessage.Type]handler.Handle{clustermessage.TypePush: h}
+	})
+	switcher := q.(DualSwitcher)
+	if source := switcher.ConsumeSource(); source != DualConsumeBoth {
+		t.Fatalf("consume source = %s", source)
+	}
+
+	publish := func(affairID string) {
+		t.Helper()
+		msg := &clustermessage.AffairMsg{AffairID: affairID, Type: clustermessage.TypePush, To: &clustermessage.To{PID: "p1"}}
+		if err := q.Publish(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		// 等待两个子队列都分发完成
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	publish("both")
+	if got := h.handled(); len(got) != 1 || got[0] != "both" {
+		t.Fatalf("both mode handled %v, want exactly once", got)
+	}
+
+	if err := switcher.SetConsumeSource(ctx, DualConsumeSecondary); err != nil {
+		t.Fatal(err)
+	}
+	publish("secondary")
+	if got := h.handled(); len(got) != 2 || got[1] != "secondary" {
+		t.Fatalf("secondary mode handled %v", got)
+	}
+	if err := switcher.SetConsumeSource(ctx, "unknown"); err == nil {
+		t.Fatal("invalid consume source should be rejected")
+	}
+}
@@ -0,0 +1,275 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
+	"github.com/mtgnorton/ws-cluster/core/queue/kafka"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+
+	"github.com/IBM/sarama"
+)
+
+// 使用kafka实现的队列,每个节点使用独立的消费组,所有节点都能收到所有消息
+type kafkaQueue struct {
+	opts         option.Options
+	config       kafka.Config
+	producer     *kafka.Producer
+	metricLabels []string
+	buffer       *publishBuffer
+	publishWg    sync.WaitGroup
+	lastSlowLog  atomic.Int64
+}
+
+func NewKafkaQueue(opts ...option.Option) (q Queue) {
+	defer func() {
+		go func() {
+			_ = q.Consume(q.Options().Ctx, nil)
+		}()
+	}()
+	options := option.NewOptions(opts...)
+
+	var (
+		nodeID = shared.GetNodeID()
+		ip     = shared.GetInternalIP()
+	)
+	kafkaConfig := NewKafkaConfig(options.Config, string(options.Topic))
+	kafkaConfig.FlushMessages = options.PublishBatchSize
+	producer, err := kafka.NewProducer(kafkaConfig)
+	if err != nil {
+		panic(err)
+	}
+
+	kq := &kafkaQueue{
+		opts:         options,
+		config:       kafkaConfig,
+		producer:     producer,
+		metricLabels: []string{strconv.FormatInt(nodeID, 10), ip},
+	}
+	kq.buffer = newPublishBuffer("Kafka", options, 100000, kq.metricLabels)
+	kq.publishWg.Add(options.PublishWorkerCount)
+	for i := 0; i < options.PublishWorkerCount; i++ {
+		go kq.publishLoop(options.Ctx, i)
+	}
+	return kq
+}
+
+// NewKafkaConfig 根据配置文件生成kafka的连接配置,每个节点使用独立的消费组
+// 消费组使用重启后不变的节点标识,节点重启后从已提交的位置继续消费
+func NewKafkaConfig(conf config.Config, topic string) kafka.Config {
+	var (
+		c     = conf.Values().Queue.Kafka
+		owner = "ws-cluster-node-" + kafkaName(offsetOwner(conf, shared.GetNodeID()))
+	)
+	return kafka.Config{
+		Brokers:       strings.Split(c.Broker, ","),
+		Version:       c.Version,
+		Topic:         topic,
+		Group:         owner,
+		ClientID:      owner,
+		InitialOffset: c.InitialOffset,
+		SASL: kafka.SASL{
+			Enable:    c.SASL.Enable,
+			Mechanism: c.SASL.Mechanism,
+			User:      c.SASL.User,
+			Password:  c.SASL.Password,
+		},
+		TLS: kafka.TLS{
+			Enable:             c.TLS.Enable,
+			CAFile:             c.TLS.CAFile,
+			CertFile:           c.TLS.CertFile,
+			KeyFile:            c.TLS.KeyFile,
+			InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+		},
+	}
+}
+
+// kafkaName 替换client id中不允许使用的字符,client id只能包含字母,数字,'.','_'和'-'
+func kafkaName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+func (q *kafkaQueue) Options() option.Options {
+	return q.opts
+}
+
+// Inspect 本地发布缓冲区的状态
+func (q *kafkaQueue) Inspect(_ context.Context) Inspection {
+	return Inspection{Type: QueueTypeKafka, Buffers: []BufferStats{q.buffer.Stats(0)}}
+}
+
+func (q *kafkaQueue) Publish(ctx context.Context, m *clustermessage.AffairMsg) (err error) {
+	ctx, span := startPublishSpan(ctx, q.opts, QueueTypeKafka, m)
+	defer func() { wstrace.End(span, err) }()
+	return q.buffer.Put(ctx, m)
+}
+
+func (q *kafkaQueue) publishLoop(ctx context.Context, workerID int) {
+	logger := q.opts.Logger
+	batchSize := q.opts.PublishBatchSize
+	cache := make([]*clustermessage.AffairMsg, 0, batchSize)
+	ticker := time.NewTicker(q.opts.PublishTickerMs)
+	defer ticker.Stop()
+	defer q.publishWg.Done()
+
+	flush := func() {
+		if len(cache) > 0 {
+			q.publish(ctx, cache)
+			cache = cache[:0]
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if len(cache) > 0 {
+				q.publish(context.Background(), cache)
+			}
+			logger.Infof(ctx, "Kafka-publishLoop worker-%d exit", workerID)
+			return
+		case item := <-q.buffer.C():
+			cache = append(cache, item.msg)
+			for len(cache) < batchSize {
+				select {
+				case item := <-q.buffer.C():
+					cache = append(cache, item.msg)
+				default:
+					goto batchReady
+				}
+			}
+		batchReady:
+			if len(cache) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (q *kafkaQueue) publish(ctx context.Context, msgs []*clustermessage.AffairMsg) {
+	logger := q.opts.Logger
+	beginTime := time.Now()
+	messages := make([][]byte, 0, len(msgs))
+	for _, m := range msgs {
+		messageBytes, err := clustermessage.PackAffair(m)
+		if err != nil {
+			logger.Infof(ctx, "Kafka-publish msg:%+v packAffair failed,error: %v", m, err)
+			continue
+		}
+		messages = append(messages, messageBytes)
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	failed, err := q.producer.PublishBatch(ctx, messages)
+	if err != nil {
+		_ = q.opts.Prometheus.GetAdd(wsprometheus.MetricQueueDrop, q.metricLabels, float64(failed))
+		if kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+			logger.Warnf(ctx, "Kafka-publish failed count:%d/%d, error:%v", failed, len(messages), err)
+		}
+	}
+	validCount := len(messages) - failed
+	if validCount <= 0 {
+		return
+	}
+	_ = q.opts.Prometheus.GetAdd(wsprometheus.MerticQueueEnter, q.metricLabels, float64(validCount))
+	_ = q.opts.Prometheus.GetObserve(
+		wsprometheus.MertricQueueEnterDuration,
+		q.metricLabels,
+		float64(time.Since(beginTime).Microseconds())/1000.0/float64(validCount),
+	)
+}
+
+// Consume 加入本节点的消费组,每个分区的消息在sarama的协程中按顺序处理,直到ctx结束
+func (q *kafkaQueue) Consume(ctx context.Context, _ interface{}) (err error) {
+	logger := q.opts.Logger
+	group, err := kafka.NewConsumerGroup(ctx, q.config, func(msg *sarama.ConsumerMessage) bool {
+		return q.handle(ctx, msg)
+	}, func(err error) {
+		if kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+			logger.Warnf(ctx, "Kafka-Consume error:%v", err)
+		}
+	})
+	if err != nil {
+		logger.Errorf(ctx, "Kafka-Consume create consumer group error:%v", err)
+		return err
+	}
+	<-ctx.Done()
+	if err := group.Close(); err != nil {
+		logger.Warnf(ctx, "Kafka-Consume close consumer group error:%v", err)
+	}
+	// 等待所有发送协程把缓存的消息交给生产者后再关闭
+	q.publishWg.Wait()
+	if err := q.producer.Close(); err != nil {
+		logger.Warnf(ctx, "Kafka-Consume close producer error:%v", err)
+	}
+	logger.Infof(ctx, "Kafka-Consume exit")
+	return nil
+}
+
+func (q *kafkaQueue) handle(ctx context.Context, msg *sarama.ConsumerMessage) (isAck bool) {
+	var (
+		logger    = q.opts.Logger
+		p         = q.opts.Prometheus
+		beginTime = time.Now()
+		source    = "kafka:" + msg.Topic
+		sourceID  = fmt.Sprintf("%d-%d", msg.Partition, msg.Offset)
+	)
+	defer func() {
+		_ = p.GetAdd(wsprometheus.MetricQueueOut, q.metricLabels, 1)
+		_ = p.GetObserve(wsprometheus.MetricQueueHandleDuration, q.metricLabels, float64(time.Since(beginTime).Milliseconds()))
+	}()
+	concreteMsg, err := clustermessage.ParseAffair(msg.Value)
+	if err != nil {
+		logger.Warnf(ctx, "Kafka-Consume failed to decode msg: %s,err:%v", kit.LogSnippet(msg.Value, 240), err)
+		writeDeadLetter(ctx, q.opts, deadletter.ReasonDecode, err.Error(), msg.Value, nil, source, sourceID)
+		return true
+	}
+	msgType := string(concreteMsg.Type)
+	lagMs := float64(time.Since(msg.Timestamp).Microseconds()) / 1000.0
+	_ = p.GetObserve(wsprometheus.MetricQueueLagDuration, append(q.metricLabels, msgType), lagMs)
+	if lagMs >= 1000 && kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+		logger.Warnf(ctx, "Kafka-Consume lag=%0.2fms,partition=%d,offset=%d,type=%s,payload=%s", lagMs, msg.Partition, msg.Offset, msgType, kit.LogSnippet(concreteMsg.Payload, 240))
+	}
+	if isStaleMessage(q.opts, msgType, lagMs) {
+		_ = p.GetAdd(wsprometheus.MetricQueueStaleSkip, append(q.metricLabels, msgType), 1)
+		return true
+	}
+	isAck, ok := handleMessage(ctx, q.opts, concreteMsg, msg.Value, source, sourceID)
+	if !ok {
+		logger.Warnf(ctx, "Kafka-Consume failed to find handler for msg: %s", kit.LogSnippet(msg.Value, 240))
+		return true
+	}
+	dispatchMs := float64(time.Since(beginTime).Microseconds()) / 1000.0
+	_ = p.GetObserve(wsprometheus.MetricQueueDispatchDuration, append(q.metricLabels, msgType), dispatchMs)
+	if dispatchMs >= slowDispatchMs {
+		recordSlowDispatch(q.opts, source, sourceID, concreteMsg, dispatchMs)
+		if kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+			logger.Warnf(ctx, "Kafka-Consume dispatch slow=%0.2fms,type=%s,payload=%s", dispatchMs, msgType, kit.LogSnippet(concreteMsg.Payload, 240))
+		}
+	}
+	if !isAck {
+		logger.Warnf(ctx, "Kafka-Consume msg not ack,partition=%d,offset=%d,type=%s", msg.Partition, msg.Offset, msgType)
+	}
+	return isAck
+}
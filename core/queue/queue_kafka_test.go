@@ -0,0 +1,32 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/mtgnorton/ws-cluster/core/queue/kafka"
+)
+
+// 消费组不随动态nodeID变化,重启后使用已提交的位置
+func TestKafkaConfigStableGroup(t *testing.T) {
+	c := &testConfig{}
+	c.values.Node = 7
+	kc := NewKafkaConfig(c, "ws_cluster_test")
+	if kc.Group != "ws-cluster-node-7" || kc.ClientID != kc.Group {
+		t.Fatalf("group = %s, client id = %s", kc.Group, kc.ClientID)
+	}
+
+	// 未配置node时使用主机名和端口,需要是合法的client id
+	c.values.Node = 0
+	c.values.WsServer.Port = 8084
+	kc = NewKafkaConfig(c, "ws_cluster_test")
+	if kc.Group != NewKafkaConfig(c, "ws_cluster_test").Group {
+		t.Fatal("group should be stable")
+	}
+	cfg, err := kafka.NewSaramaConfig(kc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("client id %s invalid:%v", kc.ClientID, err)
+	}
+}
@@ -0,0 +1,121 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+)
+
+// 内存队列,消息直接分发给本节点的处理器,只能单节点部署
+type memoryQueue struct {
+	opts         option.Options
+	metricLabels []string
+	buffer       *publishBuffer
+	lastSlowLog  atomic.Int64
+}
+
+func NewMemoryQueue(opts ...option.Option) (q Queue) {
+	defer func() {
+		go func() {
+			_ = q.Consume(q.Options().Ctx, nil)
+		}()
+	}()
+	options := option.NewOptions(opts...)
+	size := options.Config.Values().Queue.Memory.Size
+
+	mq := &memoryQueue{
+		opts:         options,
+		metricLabels: []string{strconv.FormatInt(shared.GetNodeID(), 10), shared.GetInternalIP()},
+	}
+	mq.buffer = newPublishBuffer("Memory", options, kit.IfElse(size > 0, size, 100000), mq.metricLabels)
+	return mq
+}
+
+func (q *memoryQueue) Options() option.Options {
+	return q.opts
+}
+
+// Inspect 本地队列的状态
+func (q *memoryQueue) Inspect(_ context.Context) Inspection {
+	return Inspection{Type: QueueTypeMemory, Buffers: []BufferStats{q.buffer.Stats(0)}}
+}
+
+func (q *memoryQueue) Publish(ctx context.Context, m *clustermessage.AffairMsg) (err error) {
+	ctx, span := startPublishSpan(ctx, q.opts, QueueTypeMemory, m)
+	defer func() { wstrace.End(span, err) }()
+	if err := q.buffer.Put(ctx, m); err != nil {
+		return err
+	}
+	_ = q.opts.Prometheus.GetAdd(wsprometheus.MerticQueueEnter, q.metricLabels, 1)
+	return nil
+}
+
+// Consume 按批次从本地队列取出消息,在同一个协程中按顺序分发
+func (q *memoryQueue) Consume(ctx context.Context, _ interface{}) (err error) {
+	var (
+		logger    = q.opts.Logger
+		batchSize = q.opts.PublishBatchSize
+		batch     = make([]*publishItem, 0, batchSize)
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Infof(ctx, "Memory-Consume exit")
+			return
+		case item := <-q.buffer.C():
+			batch = append(batch, item)
+			for len(batch) < batchSize {
+				select {
+				case item := <-q.buffer.C():
+					batch = append(batch, item)
+				default:
+					goto batchReady
+				}
+			}
+		batchReady:
+			q.dispatch(ctx, batch)
+			clear(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (q *memoryQueue) dispatch(ctx context.Context, batch []*publishItem) {
+	var (
+		logger    = q.opts.Logger
+		p         = q.opts.Prometheus
+		beginTime = time.Now()
+	)
+	for _, item := range batch {
+		msgType := string(item.msg.Type)
+		lagMs := float64(time.Since(item.enqueuedAt).Microseconds()) / 1000.0
+		_ = p.GetObserve(wsprometheus.MetricQueueLagDuration, append(q.metricLabels, msgType), lagMs)
+		if lagMs >= 1000 && kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+			logger.Warnf(ctx, "Memory-Consume lag=%0.2fms,type=%s,msg_count=%d,payload=%s", lagMs, msgType, len(batch), kit.LogSnippet(item.msg.Payload, 240))
+		}
+		dispatchBegin := time.Now()
+		if _, ok := handleMessage(ctx, q.opts, item.msg, nil, "memory", ""); !ok {
+			logger.Warnf(ctx, "Memory-Consume failed to find handler for msg type:%s", msgType)
+			continue
+		}
+		dispatchMs := float64(time.Since(dispatchBegin).Microseconds()) / 1000.0
+		_ = p.GetObserve(wsprometheus.MetricQueueDispatchDuration, append(q.metricLabels, msgType), dispatchMs)
+		if dispatchMs >= slowDispatchMs {
+			recordSlowDispatch(q.opts, "memory", "", item.msg, dispatchMs)
+			if kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+				logger.Warnf(ctx, "Memory-Consume dispatch slow=%0.2fms,type=%s,payload=%s", dispatchMs, msgType, kit.LogSnippet(item.msg.Payload, 240))
+			}
+		}
+	}
+	_ = p.GetObserve(wsprometheus.MetricQueueHandleDuration, q.metricLabels, float64(time.Since(beginTime).Milliseconds())/float64(len(batch)))
+	_ = p.GetAdd(wsprometheus.MetricQueueOut, q.metricLabels, float64(len(batch)))
+}
@@ -0,0 +1,217 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
+	"github.com/mtgnorton/ws-cluster/core/queue/nats"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+)
+
+// natsNameReplacer 替换nats消费者名称中不允许使用的字符
+var natsNameReplacer = strings.NewReplacer(".", "_", "*", "_", ">", "_", "/", "_", "\\", "_", " ", "_")
+
+// 使用nats jetstream实现的队列,每个节点使用独立的消费者,所有节点都能收到所有消息
+type natsQueue struct {
+	opts         option.Options
+	js           *nats.JetStream
+	metricLabels []string
+	buffer       *publishBuffer
+	lastSlowLog  atomic.Int64
+}
+
+func NewNatsQueue(opts ...option.Option) (q Queue) {
+	defer func() {
+		go func() {
+			_ = q.Consume(q.Options().Ctx, nil)
+		}()
+	}()
+	options := option.NewOptions(opts...)
+
+	var (
+		c      = options.Config.Values().Queue.Nats
+		nodeID = shared.GetNodeID()
+		ip     = shared.GetInternalIP()
+	)
+	natsConfig := nats.Config{
+		URL:      c.URL,
+		Stream:   kit.IfElse(c.Stream != "", c.Stream, "ws_cluster"),
+		MaxAge:   time.Duration(kit.IfElse(c.MaxAge > 0, c.MaxAge, 600)) * time.Second,
+		Replicas: c.Replicas,
+		Name:     fmt.Sprintf("ws-cluster-node-%d", nodeID),
+	}
+	natsConfig.Subject = natsConfig.Stream + "." + string(options.Topic)
+	if c.Durable {
+		// 动态获取的nodeID重启后会变化,持久化消费者使用重启后不变的节点标识
+		natsConfig.Durable = "node-" + natsNameReplacer.Replace(offsetOwner(options.Config, nodeID))
+		natsConfig.InactiveThreshold = time.Duration(kit.IfElse(c.InactiveThreshold > 0, c.InactiveThreshold, 86400)) * time.Second
+	}
+	js, err := nats.NewJetStream(natsConfig)
+	if err != nil {
+		panic(err)
+	}
+
+	nq := &natsQueue{
+		opts:         options,
+		js:           js,
+		metricLabels: []string{strconv.FormatInt(nodeID, 10), ip},
+	}
+	nq.buffer = newPublishBuffer("Nats", options, 100000, nq.metricLabels)
+	for i := 0; i < options.PublishWorkerCount; i++ {
+		go nq.publishLoop(options.Ctx, i)
+	}
+	return nq
+}
+
+func (q *natsQueue) Options() option.Options {
+	return q.opts
+}
+
+// Inspect 本地发布缓冲区的状态
+func (q *natsQueue) Inspect(_ context.Context) Inspection {
+	return Inspection{Type: QueueTypeNats, Buffers: []BufferStats{q.buffer.Stats(0)}}
+}
+
+func (q *natsQueue) Publish(ctx context.Context, m *clustermessage.AffairMsg) (err error) {
+	ctx, span := startPublishSpan(ctx, q.opts, QueueTypeNats, m)
+	defer func() { wstrace.End(span, err) }()
+	return q.buffer.Put(ctx, m)
+}
+
+func (q *natsQueue) publishLoop(ctx context.Context, workerID int) {
+	logger := q.opts.Logger
+	batchSize := q.opts.PublishBatchSize
+	cache := make([]*clustermessage.AffairMsg, 0, batchSize)
+	ticker := time.NewTicker(q.opts.PublishTickerMs)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(cache) > 0 {
+			q.publish(ctx, cache)
+			cache = cache[:0]
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if len(cache) > 0 {
+				flushCtx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+				q.publish(flushCtx, cache)
+				cancel()
+			}
+			logger.Infof(ctx, "Nats-publishLoop worker-%d exit", workerID)
+			return
+		case item := <-q.buffer.C():
+			cache = append(cache, item.msg)
+			for len(cache) < batchSize {
+				select {
+				case item := <-q.buffer.C():
+					cache = append(cache, item.msg)
+				default:
+					goto batchReady
+				}
+			}
+		batchReady:
+			if len(cache) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (q *natsQueue) publish(ctx context.Context, msgs []*clustermessage.AffairMsg) {
+	logger := q.opts.Logger
+	beginTime := time.Now()
+	messages := make([][]byte, 0, len(msgs))
+	for _, m := range msgs {
+		messageBytes, err := clustermessage.PackAffair(m)
+		if err != nil {
+			logger.Infof(ctx, "Nats-publish msg:%+v packAffair failed,error: %v", m, err)
+			continue
+		}
+		messages = append(messages, messageBytes)
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	failed, err := q.js.PublishBatch(ctx, messages)
+	if err != nil {
+		logger.Warnf(ctx, "Nats-publish failed count:%d, error:%v", failed, err)
+		_ = q.opts.Prometheus.GetAdd(wsprometheus.MetricQueueDrop, q.metricLabels, float64(failed))
+	}
+	validCount := len(messages) - failed
+	if validCount <= 0 {
+		return
+	}
+	_ = q.opts.Prometheus.GetAdd(wsprometheus.MerticQueueEnter, q.metricLabels, float64(validCount))
+	_ = q.opts.Prometheus.GetObserve(
+		wsprometheus.MertricQueueEnterDuration,
+		q.metricLabels,
+		float64(time.Since(beginTime).Microseconds())/1000.0/float64(validCount),
+	)
+}
+
+// Consume 订阅jetstream,消息在nats的回调协程中按顺序处理,直到ctx结束
+func (q *natsQueue) Consume(ctx context.Context, _ interface{}) (err error) {
+	var (
+		logger = q.opts.Logger
+		p      = q.opts.Prometheus
+	)
+	sub, err := q.js.Subscribe(func(data []byte, publishedAt time.Time) bool {
+		beginTime := time.Now()
+		defer func() {
+			_ = p.GetAdd(wsprometheus.MetricQueueOut, q.metricLabels, 1)
+			_ = p.GetObserve(wsprometheus.MetricQueueHandleDuration, q.metricLabels, float64(time.Since(beginTime).Milliseconds()))
+		}()
+		concreteMsg, err := clustermessage.ParseAffair(data)
+		if err != nil {
+			logger.Warnf(ctx, "Nats-Consume failed to decode msg: %s,err:%v", kit.LogSnippet(data, 240), err)
+			writeDeadLetter(ctx, q.opts, deadletter.ReasonDecode, err.Error(), data, nil, "nats", "")
+			return true
+		}
+		msgType := string(concreteMsg.Type)
+		lagMs := float64(time.Since(publishedAt).Microseconds()) / 1000.0
+		_ = p.GetObserve(wsprometheus.MetricQueueLagDuration, append(q.metricLabels, msgType), lagMs)
+		if lagMs >= 1000 && kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+			logger.Warnf(ctx, "Nats-Consume lag=%0.2fms,type=%s,payload=%s", lagMs, msgType, kit.LogSnippet(concreteMsg.Payload, 240))
+		}
+		isAck, ok := handleMessage(ctx, q.opts, concreteMsg, data, "nats", "")
+		if !ok {
+			logger.Warnf(ctx, "Nats-Consume failed to find handler for msg: %s", kit.LogSnippet(data, 240))
+			return true
+		}
+		dispatchMs := float64(time.Since(beginTime).Microseconds()) / 1000.0
+		_ = p.GetObserve(wsprometheus.MetricQueueDispatchDuration, append(q.metricLabels, msgType), dispatchMs)
+		if dispatchMs >= slowDispatchMs {
+			recordSlowDispatch(q.opts, "nats", "", concreteMsg, dispatchMs)
+			if kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+				logger.Warnf(ctx, "Nats-Consume dispatch slow=%0.2fms,type=%s,payload=%s", dispatchMs, msgType, kit.LogSnippet(concreteMsg.Payload, 240))
+			}
+		}
+		return isAck
+	})
+	if err != nil {
+		logger.Errorf(ctx, "Nats-Consume subscribe error:%v", err)
+		return err
+	}
+	<-ctx.Done()
+	_ = sub.Unsubscribe()
+	_ = q.js.Close()
+	logger.Infof(ctx, "Nats-Consume exit")
+	return nil
+}
@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/queue/handler"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsgo "github.com/nats-io/nats.go"
+)
+
+func runNatsServer(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+// newTestNatsQueue 创建nats队列,返回的cancel用于模拟节点下线
+func newTestNatsQueue(t *testing.T, url string, durable bool, h handler.Handle) (*natsQueue, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	c := &testConfig{}
+	c.values.Node = 7
+	c.values.Queue.Nats = config.Nats{URL: url, Stream: "ws_cluster_queue_test", MaxAge: 60, Durable: durable}
+	q := NewNatsQueue(option.WithContext(ctx), option.WithConfig(c), func(o *option.Options) {
+		o.Handlers = map[clustermessage.Type]handler.Handle{clustermessage.TypePush: h}
+		o.Prometheus = enabledPrometheus()
+	})
+	return q.(*natsQueue), cancel
+}
+
+func waitHandled(t *testing.T, h *recordHandler, count int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := h.handled(); len(got) >= count {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("want %d messages, handled %v", count, h.handled())
+	return nil
+}
+
+func publishRange(t *testing.T, q Queue, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := q.Publish(context.Background(), testMsg(fmt.Sprintf("m%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 消息经过发布缓冲区写入stream,分发给处理函数,节点重启后持久化消费者继续消费下线期间的消息
+func TestNatsQueueDurableResume(t *testing.T) {
+	s := runNatsServer(t)
+	first := &recordHandler{}
+	q, stop := newTestNatsQueue(t, s.ClientURL(), true, first)
+	// 等待订阅完成
+	time.Sleep(200 * time.Millisecond)
+	enter := counterValue(t, wsprometheus.MerticQueueEnter, q.metricLabels)
+	out := counterValue(t, wsprometheus.MetricQueueOut, q.metricLabels)
+
+	publishRange(t, q, 0, 5)
+	if got := waitHandled(t, first, 5); got[0] != "m0" || got[4] != "m4" {
+		t.Fatalf("handled %v", got)
+	}
+	if got := counterValue(t, wsprometheus.MerticQueueEnter, q.metricLabels) - enter; got != 5 {
+		t.Fatalf("queue_enter increased %v, want 5", got)
+	}
+	if got := counterValue(t, wsprometheus.MetricQueueOut, q.metricLabels) - out; got != 5 {
+		t.Fatalf("queue_out increased %v, want 5", got)
+	}
+
+	// 持久化消费者的名称不依赖动态获取的nodeID,并且设置了清理时间
+	conn, err := natsgo.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := js.ConsumerInfo("ws_cluster_queue_test", "node-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Config.InactiveThreshold != 24*time.Hour {
+		t.Fatalf("inactive threshold = %v", info.Config.InactiveThreshold)
+	}
+
+	// 节点下线期间由其他节点发布消息
+	stop()
+	time.Sleep(200 * time.Millisecond)
+	publisher, _ := newTestNatsQueue(t, s.ClientURL(), false, &recordHandler{})
+	publishRange(t, publisher, 5, 10)
+	time.Sleep(200 * time.Millisecond)
+
+	second := &recordHandler{}
+	newTestNatsQueue(t, s.ClientURL(), true, second)
+	got := waitHandled(t, second, 5)
+	if len(got) != 5 || got[0] != "m5" || got[4] != "m9" {
+		t.Fatalf("restarted node should resume from m5, handled %v", got)
+	}
+}
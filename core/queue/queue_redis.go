@@ -2,22 +2,29 @@ package queue
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/mtgnorton/ws-cluster/shared"
 	"github.com/mtgnorton/ws-cluster/shared/kit"
 	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
 
 	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
 	"github.com/mtgnorton/ws-cluster/core/queue/option"
+	"github.com/mtgnorton/ws-cluster/core/queue/spill"
 
 	"github.com/redis/go-redis/v9"
 )
 
 // 使用xread实现的队列
+// 按pid哈希拆分为多个stream,每个分片有独立的发布协程和消费协程,同一项目的消息保持顺序
 type redisQueue struct {
 	opts         option.Options
 	startTime    time.Time
@@ -25,11 +32,25 @@ type redisQueue struct {
 	consumeTimes atomic.Int64
 	nodeID       int64
 	nodeIP       string
+	offsetOwner  string // 保存消费位置使用的节点标识
 	metricLabels []string
-	msgCh        chan *clustermessage.AffairMsg
+	shards       []*redisShard
 	lastSlowLog  atomic.Int64
 }
 
+type redisShard struct {
+	index  int
+	topic  string
+	buffer *publishBuffer
+	spill  *spill.Spill // 本地溢写文件,未开启时为nil
+	labels []string     // node,ip,shard
+	readID atomic.Value // string 最后消费的消息ID,供Inspect读取
+
+	// 以下字段只在分片的消费协程中访问
+	savedID string    // 最后保存的消费位置
+	savedAt time.Time // 最后保存消费位置的时间
+}
+
 func NewRedisQueue(opts ...option.Option) (q Queue) {
 	defer func() {
 		go func() {
@@ -37,6 +58,9 @@ func NewRedisQueue(opts ...option.Option) (q Queue) {
 		}()
 	}()
 	options := option.NewOptions(opts...)
+	if options.RedisClient == nil {
+		options.RedisClient = shared.GetDefaultRedisQueue()
+	}
 
 	ip := shared.GetInternalIP()
 	nodeID := shared.GetNodeID()
@@ -48,51 +72,64 @@ func NewRedisQueue(opts ...option.Option) (q Queue) {
 		consumeTimes: atomic.Int64{},
 		nodeID:       nodeID,
 		nodeIP:       ip,
+		offsetOwner:  offsetOwner(options.Config, nodeID),
 		metricLabels: []string{strconv.FormatInt(nodeID, 10), ip},
-		msgCh:        make(chan *clustermessage.AffairMsg, 100000),
+	}
+	shardCount := max(options.Config.Values().Queue.RedisShards, 1)
+	for i := 0; i < shardCount; i++ {
+		topic := options.Topic
+		// 只有一个分片时沿用原有的stream,升级时不丢失消息
+		if shardCount > 1 {
+			topic = fmt.Sprintf("%s:%d", options.Topic, i)
+		}
+		// cluster模式下每个分片使用单独的hash tag,分片分布在不同的节点上
+		topic = kit.RedisKey(options.RedisClient, topic, "")
+		rq.shards = append(rq.shards, &redisShard{
+			index:  i,
+			topic:  topic,
+			buffer: newPublishBuffer("Redis", options, max(100000/shardCount, 10000), rq.metricLabels),
+			labels: []string{strconv.FormatInt(nodeID, 10), ip, strconv.Itoa(i)},
+			spill:  rq.openSpill(topic),
+		})
 	}
 	go rq.monitor(options.Ctx)
 	go rq.xTrimLoop(options.Ctx)
-	for i := 0; i < options.PublishWorkerCount; i++ {
-		go rq.publishLoop(options.Ctx, i)
+	// 每个分片只有一个发布协程,保证同一分片内的顺序
+	for _, shard := range rq.shards {
+		go rq.publishLoop(options.Ctx, shard)
+		if shard.spill != nil {
+			go rq.replayLoop(options.Ctx, shard)
+		}
 	}
 	return rq
 }
 
+// shardOf 按消息所属的项目选择分片
+func (q *redisQueue) shardOf(m *clustermessage.AffairMsg) *redisShard {
+	if len(q.shards) == 1 {
+		return q.shards[0]
+	}
+	pid := ""
+	if m.Source != nil {
+		pid = m.Source.PID
+	} else if m.To != nil {
+		pid = m.To.PID
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(pid))
+	return q.shards[h.Sum32()%uint32(len(q.shards))]
+}
+
 func (q *redisQueue) Options() option.Options {
 	return q.opts
 }
-func (q *redisQueue) Publish(ctx context.Context, m *clustermessage.AffairMsg) error {
-	beginTime := time.Now()
-	select {
-	case q.msgCh <- m:
-		_ = q.opts.Prometheus.GetObserve(wsprometheus.MetricQueuePublishWaitDuration, q.metricLabels, float64(time.Since(beginTime).Microseconds())/1000.0)
-		return nil
-	default:
-	}
-
-	timer := time.NewTimer(time.Second * 5)
-	defer timer.Stop()
-	select {
-	case q.msgCh <- m:
-		waitMs := float64(time.Since(beginTime).Microseconds()) / 1000.0
-		_ = q.opts.Prometheus.GetObserve(wsprometheus.MetricQueuePublishWaitDuration, q.metricLabels, waitMs)
-		if waitMs >= 100 && kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
-			q.opts.Logger.Warnf(ctx, "Redis-Publish local queue wait=%0.2fms,len=%d,cap=%d,type=%s,payload=%s", waitMs, len(q.msgCh), cap(q.msgCh), m.Type, kit.LogSnippet(m.Payload, 240))
-		}
-		return nil
-	case <-ctx.Done():
-		q.opts.Logger.Warnf(ctx, "Redis-Publish canceled, drop msg:%+v", m)
-		_ = q.opts.Prometheus.GetAdd(wsprometheus.MetricQueueDrop, q.metricLabels, 1)
-		return nil
-	case <-timer.C:
-		q.opts.Logger.Warnf(ctx, "Redis-Publish timeout, drop msg:%+v", m)
-		_ = q.opts.Prometheus.GetAdd(wsprometheus.MetricQueueDrop, q.metricLabels, 1)
-		return nil
-	}
+func (q *redisQueue) Publish(ctx context.Context, m *clustermessage.AffairMsg) (err error) {
+	ctx, span := startPublishSpan(ctx, q.opts, QueueTypeRedis, m)
+	defer func() { wstrace.End(span, err) }()
+	return q.shardOf(m).buffer.Put(ctx, m)
 }
 
-func (q *redisQueue) publishLoop(ctx context.Context, workerID int) {
+func (q *redisQueue) publishLoop(ctx context.Context, shard *redisShard) {
 
 	logger := q.opts.Logger
 	batchSize := q.opts.PublishBatchSize
@@ -103,7 +140,7 @@ func (q *redisQueue) publishLoop(ctx context.Context, workerID int) {
 
 	flush := func() {
 		if len(cache) > 0 {
-			q.publish(ctx, cache)
+			q.publish(ctx, shard, cache)
 			cache = cache[:0]
 		}
 	}
@@ -113,17 +150,20 @@ func (q *redisQueue) publishLoop(ctx context.Context, workerID int) {
 		case <-ctx.Done():
 			if len(cache) > 0 {
 				flushCtx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-				q.publish(flushCtx, cache)
+				q.publish(flushCtx, shard, cache)
 				cancel()
 			}
-			logger.Infof(ctx, "Redis-publishLoop worker-%d exit", workerID)
+			if shard.spill != nil {
+				_ = shard.spill.Close()
+			}
+			logger.Infof(ctx, "Redis-publishLoop shard-%d exit", shard.index)
 			return
-		case m := <-q.msgCh:
-			cache = append(cache, m)
+		case item := <-shard.buffer.C():
+			cache = append(cache, item.msg)
 			for len(cache) < batchSize {
 				select {
-				case msg := <-q.msgCh:
-					cache = append(cache, msg)
+				case item := <-shard.buffer.C():
+					cache = append(cache, item.msg)
 				default:
 					goto batchReady
 				}
@@ -138,12 +178,11 @@ func (q *redisQueue) publishLoop(ctx context.Context, workerID int) {
 	}
 }
 
-func (q *redisQueue) publish(ctx context.Context, msgs []*clustermessage.AffairMsg) {
+func (q *redisQueue) publish(ctx context.Context, shard *redisShard, msgs []*clustermessage.AffairMsg) {
 	logger := q.opts.Logger
 	beginTime := time.Now()
-	pipe := q.opts.RedisClient.Pipeline()
-	topic := string(q.opts.Topic)
-	validCount := 0
+	topic := shard.topic
+	messages := make([][]byte, 0, len(msgs))
 
 	for _, m := range msgs {
 		messageBytes, err := clustermessage.PackAffair(m)
@@ -151,24 +190,43 @@ func (q *redisQueue) publish(ctx context.Context, msgs []*clustermessage.AffairM
 			logger.Infof(ctx, "Redis-publish msg:%+v packAffair failed,error: %v", m, err)
 			continue
 		}
-		_ = pipe.Do(ctx, "XADD", topic, "*", "m", messageBytes)
-		validCount++
+		messages = append(messages, messageBytes)
 	}
 
-	if validCount == 0 {
+	if len(messages) == 0 {
 		return
 	}
 
-	cmds, err := pipe.Exec(ctx)
-	if err != nil {
-		logger.Warnf(ctx, "Redis-publish pipe.Exec failed, error:%v", err)
+	// 本地文件中还有未重新写入的消息时,新的消息也写入本地文件,保证顺序
+	if shard.spill != nil && shard.spill.Pending() && q.spillMessages(ctx, shard, messages) {
 		return
 	}
-	for _, cmd := range cmds {
+
+	pipe := q.opts.RedisClient.Pipeline()
+	for _, message := range messages {
+		_ = pipe.Do(ctx, "XADD", topic, "*", "m", message)
+	}
+	cmds, err := pipe.Exec(ctx)
+	failed := make([][]byte, 0)
+	for i, cmd := range cmds {
 		if cmd.Err() != nil {
-			logger.Warnf(ctx, "Redis-publish exec cmd xadd failed, error:%v", cmd.Err())
+			failed = append(failed, messages[i])
+		}
+	}
+	// 连接失败时命令本身没有错误,整批都没有写入
+	if err != nil && len(failed) == 0 {
+		failed = messages
+	}
+	if len(failed) > 0 {
+		spilled := q.spillMessages(ctx, shard, failed)
+		if kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+			logger.Warnf(ctx, "Redis-publish xadd failed %d/%d messages, spill:%v, error:%v", len(failed), len(messages), spilled, err)
 		}
 	}
+	validCount := len(messages) - len(failed)
+	if validCount == 0 {
+		return
+	}
 	q.publishTimes.Add(int64(validCount))
 	_ = q.opts.Prometheus.GetAdd(wsprometheus.MerticQueueEnter, q.metricLabels, float64(validCount))
 	_ = q.opts.Prometheus.GetObserve(
@@ -179,30 +237,58 @@ func (q *redisQueue) publish(ctx context.Context, msgs []*clustermessage.AffairM
 
 }
 
-// Consume 开启一个协程，不断地从redis中读取消息
+// Consume 每个分片开启一个协程，不断地从redis中读取消息,分片之间并行处理
 func (q *redisQueue) Consume(ctx context.Context, _ interface{}) (err error) {
+	var wg sync.WaitGroup
+	for _, shard := range q.shards {
+		wg.Add(1)
+		go func(shard *redisShard) {
+			defer wg.Done()
+			q.consumeShard(ctx, shard)
+		}(shard)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (q *redisQueue) consumeShard(ctx context.Context, shard *redisShard) {
 	var (
 		queueRedis = q.opts.RedisClient
 		logger     = q.opts.Logger
-		topic      = q.opts.Topic
+		topic      = shard.topic
 		p          = q.opts.Prometheus
 	)
 
-	var currentID = "$"
+	// 每次读取使用 "$" 会漏掉两次读取之间写入的消息,因此从保存的位置或启动时stream中最后一条消息之后开始读取
+	var (
+		currentID = q.startID(ctx, shard)
+		readErr   bool
+	)
+	shard.readID.Store(currentID)
 
 	f := func() {
+		// 连接恢复后从断开前的位置继续,但不早于 MaxAge 之前
+		if readErr {
+			currentID = q.clampID(currentID)
+		}
 		streams, err := queueRedis.XRead(ctx, &redis.XReadArgs{
 			Streams: []string{topic},
 			Count:   500,
 			Block:   time.Millisecond * 10,
 			ID:      currentID,
 		}).Result()
-		// 没有消息
-		if err == redis.Nil {
+		if err != nil && err != redis.Nil {
+			if kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+				logger.Warnf(ctx, "Redis-Consume failed to read %s:%v", topic, err)
+			}
+			readErr = true
+			time.Sleep(100 * time.Millisecond)
 			return
 		}
-		if err != nil {
-			logger.Warnf(ctx, "Redis-Consume failed to read:%v", err)
+		readErr = false
+		// 没有消息
+		if err == redis.Nil {
+			q.saveOffset(ctx, shard, currentID, false)
 			return
 		}
 		if len(streams) == 0 {
@@ -217,18 +303,22 @@ func (q *redisQueue) Consume(ctx context.Context, _ interface{}) (err error) {
 			if messageCount > 0 {
 				averageTime = time.Since(beginTime).Milliseconds() / int64(messageCount)
 			}
+			q.saveOffset(ctx, shard, currentID, false)
+			shard.readID.Store(currentID)
 			q.consumeTimes.Add(int64(messageCount))
 			_ = p.GetObserve(wsprometheus.MetricQueueHandleDuration, q.metricLabels, float64(averageTime))
 			_ = p.GetAdd(wsprometheus.MetricQueueOut, q.metricLabels, float64(messageCount))
 			totalMs := float64(time.Since(beginTime).Microseconds()) / 1000.0
 			if totalMs >= 1000 && kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
-				logger.Warnf(ctx, "Redis-Consume slow batch=%0.2fms,msg_count=%d,publish_times=%d,consume_times=%d,current_id=%s,samples=%s", totalMs, messageCount, q.publishTimes.Load(), q.consumeTimes.Load(), currentID, kit.JoinLogSnippets(batchSamples))
+				logger.Warnf(ctx, "Redis-Consume slow batch=%0.2fms,shard=%d,msg_count=%d,publish_times=%d,consume_times=%d,current_id=%s,samples=%s", totalMs, shard.index, messageCount, q.publishTimes.Load(), q.consumeTimes.Load(), currentID, kit.JoinLogSnippets(batchSamples))
 			}
 		}()
 
 		for _, msg := range streams[0].Messages {
 			msgType := "unknown"
 			lagMs := streamMessageLagMs(msg.ID)
+			// 无法处理的消息同样跳过,避免重复读取
+			currentID = msg.ID
 			rawMsg, ok := msg.Values["m"]
 			if !ok {
 				logger.Warnf(ctx, "Redis-Consume failed to read msg field m, msgID:%s", msg.ID)
@@ -251,6 +341,7 @@ func (q *redisQueue) Consume(ctx context.Context, _ interface{}) (err error) {
 					batchSamples = append(batchSamples, kit.LogSnippet(concreteMsgBytes, 160))
 				}
 				logger.Warnf(ctx, "Redis-Consume failed to decode msg: %s,err:%v", string(concreteMsgBytes), err)
+				writeDeadLetter(ctx, q.opts, deadletter.ReasonDecode, err.Error(), concreteMsgBytes, nil, "redis:"+topic, msg.ID)
 				continue
 			}
 			msgType = string(concreteMsg.Type)
@@ -258,28 +349,37 @@ func (q *redisQueue) Consume(ctx context.Context, _ interface{}) (err error) {
 				batchSamples = append(batchSamples, kit.LogSnippet(concreteMsg.Payload, 160))
 			}
 			_ = p.GetObserve(wsprometheus.MetricQueueLagDuration, append(q.metricLabels, msgType), lagMs)
+			_ = p.GetSet(wsprometheus.MetricQueueShardLag, shard.labels, lagMs)
 			if lagMs >= 1000 && kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
-				logger.Warnf(ctx, "Redis-Consume lag=%0.2fms,msg_id=%s,type=%s,msg_count=%d,payload=%s", lagMs, msg.ID, msgType, messageCount, kit.LogSnippet(concreteMsg.Payload, 240))
+				logger.Warnf(ctx, "Redis-Consume lag=%0.2fms,shard=%d,msg_id=%s,type=%s,msg_count=%d,payload=%s", lagMs, shard.index, msg.ID, msgType, messageCount, kit.LogSnippet(concreteMsg.Payload, 240))
 			}
-			if _, ok := q.opts.Handlers[concreteMsg.Type]; !ok {
-				logger.Warnf(ctx, "Redis-Consume failed to find handler for msg: %s", string(concreteMsgBytes))
+			if q.isStale(msgType, lagMs) {
+				_ = p.GetAdd(wsprometheus.MetricQueueStaleSkip, append(q.metricLabels, msgType), 1)
 				continue
 			}
 			dispatchBegin := time.Now()
-			q.opts.Handlers[concreteMsg.Type].Handle(ctx, concreteMsg)
+			if _, ok := handleMessage(ctx, q.opts, concreteMsg, concreteMsgBytes, "redis:"+topic, msg.ID); !ok {
+				logger.Warnf(ctx, "Redis-Consume failed to find handler for msg: %s", string(concreteMsgBytes))
+				continue
+			}
 			dispatchMs := float64(time.Since(dispatchBegin).Microseconds()) / 1000.0
 			_ = p.GetObserve(wsprometheus.MetricQueueDispatchDuration, append(q.metricLabels, msgType), dispatchMs)
-			if dispatchMs >= 50 && kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
-				logger.Warnf(ctx, "Redis-Consume dispatch slow=%0.2fms,type=%s,msg_id=%s,payload=%s", dispatchMs, msgType, msg.ID, kit.LogSnippet(concreteMsg.Payload, 240))
+			if dispatchMs >= slowDispatchMs {
+				recordSlowDispatch(q.opts, "redis:"+topic, msg.ID, concreteMsg, dispatchMs)
+				if kit.AllowByInterval(&q.lastSlowLog, 2*time.Second) {
+					logger.Warnf(ctx, "Redis-Consume dispatch slow=%0.2fms,type=%s,msg_id=%s,payload=%s", dispatchMs, msgType, msg.ID, kit.LogSnippet(concreteMsg.Payload, 240))
+				}
 			}
-			currentID = msg.ID
 		}
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			logger.Infof(ctx, "Redis-Consume exit")
+			saveCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			q.saveOffset(saveCtx, shard, currentID, true)
+			cancel()
+			logger.Infof(ctx, "Redis-Consume shard-%d exit", shard.index)
 			return
 		default:
 			f()
@@ -287,6 +387,25 @@ func (q *redisQueue) Consume(ctx context.Context, _ interface{}) (err error) {
 	}
 }
 
+// lastStreamID 返回stream中最后一条消息的ID,stream为空时返回 "0-0"
+func (q *redisQueue) lastStreamID(ctx context.Context, topic string) string {
+	for {
+		messages, err := q.opts.RedisClient.XRevRangeN(ctx, topic, "+", "-", 1).Result()
+		if err == nil {
+			if len(messages) == 0 {
+				return "0-0"
+			}
+			return messages[0].ID
+		}
+		q.opts.Logger.Warnf(ctx, "Redis-Consume failed to read last id of %s:%v", topic, err)
+		select {
+		case <-ctx.Done():
+			return "$"
+		case <-time.After(time.Second):
+		}
+	}
+}
+
 func (q *redisQueue) monitor(ctx context.Context) error {
 	// 定期打印连接池状态
 	ticker := time.NewTicker(30 * time.Second)
@@ -295,14 +414,54 @@ func (q *redisQueue) monitor(ctx context.Context) error {
 		select {
 		case <-ticker.C:
 			stats := q.opts.RedisClient.PoolStats()
-			q.opts.Logger.Infof(ctx, "Redis pool stats: TotalConns=%d, IdleConns=%d Hits=%d,Misses=%d Timeouts=%d, msgCh len=%d cap=%d",
-				stats.TotalConns, stats.IdleConns, stats.Hits, stats.Misses, stats.Timeouts, len(q.msgCh), cap(q.msgCh))
+			pending, capacity := 0, 0
+			for _, shard := range q.shards {
+				pending += shard.buffer.Len()
+				capacity += shard.buffer.Cap()
+			}
+			q.opts.Logger.Infof(ctx, "Redis pool stats: TotalConns=%d, IdleConns=%d Hits=%d,Misses=%d Timeouts=%d, shards=%d msgCh len=%d cap=%d",
+				stats.TotalConns, stats.IdleConns, stats.Hits, stats.Misses, stats.Timeouts, len(q.shards), pending, capacity)
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
+// Inspect 每个分片的本地发布缓冲区,stream长度,本节点的消费位置和连接池状态
+func (q *redisQueue) Inspect(ctx context.Context) Inspection {
+	inspection := Inspection{Type: QueueTypeRedis, Pool: NewPoolStats(q.opts.RedisClient)}
+	for _, shard := range q.shards {
+		inspection.Buffers = append(inspection.Buffers, shard.buffer.Stats(shard.index))
+		stream := StreamStats{Shard: shard.index, Stream: shard.topic}
+		stream.LastReadID, _ = shard.readID.Load().(string)
+		stream.SpillPending = shard.spill != nil && shard.spill.Pending()
+		pipe := q.opts.RedisClient.Pipeline()
+		lenCmd := pipe.XLen(ctx, shard.topic)
+		lastCmd := pipe.XRevRangeN(ctx, shard.topic, "+", "-", 1)
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			stream.Error = err.Error()
+		}
+		stream.Length = lenCmd.Val()
+		if last := lastCmd.Val(); len(last) > 0 {
+			stream.LastEntryID = last[0].ID
+			// 两个ID都带有写入时的毫秒时间戳,差值为本节点落后的时间
+			if readMs, ok := streamIDMs(stream.LastReadID); ok && readMs > 0 {
+				if lastMs, ok := streamIDMs(stream.LastEntryID); ok && lastMs > readMs {
+					stream.ReadLagMs = float64(lastMs - readMs)
+				}
+			}
+		}
+		inspection.Streams = append(inspection.Streams, stream)
+	}
+	return inspection
+}
+
+// streamIDMs 返回stream消息ID中的毫秒时间戳
+func streamIDMs(id string) (int64, bool) {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return ms, err == nil
+}
+
 func streamMessageLagMs(id string) float64 {
 	parts := strings.SplitN(id, "-", 2)
 	if len(parts) == 0 {
@@ -332,15 +491,21 @@ func (q *redisQueue) xTrimLoop(ctx context.Context) {
 		case <-ticker.C:
 			beginTime := time.Now()
 			// 计算10分钟前的时间戳
-			minTime := time.Now().Add(-10 * time.Minute).UnixMilli()
-			c, err := q.opts.RedisClient.XTrimMinIDApprox(ctx, q.opts.Topic, strconv.FormatInt(minTime, 10), trimLimitBatch).Result()
-			if err != nil {
-				q.opts.Logger.Warnf(ctx, "xTrimLoop failed to trim err:%v", err)
-				continue
+			minTime := strconv.FormatInt(time.Now().Add(-10*time.Minute).UnixMilli(), 10)
+			var trimmed, remain int64
+			for _, shard := range q.shards {
+				c, err := q.opts.RedisClient.XTrimMinIDApprox(ctx, shard.topic, minTime, trimLimitBatch).Result()
+				if err != nil {
+					q.opts.Logger.Warnf(ctx, "xTrimLoop failed to trim %s err:%v", shard.topic, err)
+					continue
+				}
+				trimmed += c
+				if time.Since(lastLog) >= logInterval {
+					remain += q.opts.RedisClient.XLen(ctx, shard.topic).Val()
+				}
 			}
 			if time.Since(lastLog) >= logInterval {
-				xLen := q.opts.RedisClient.XLen(ctx, q.opts.Topic).Val()
-				q.opts.Logger.Infof(ctx, "xTrimLoop trim count:%d,remain %d,consume :%v ms", c, xLen, time.Since(beginTime).Milliseconds())
+				q.opts.Logger.Infof(ctx, "xTrimLoop trim count:%d,remain %d,shards:%d,consume :%v ms", trimmed, remain, len(q.shards), time.Since(beginTime).Milliseconds())
 				lastLog = time.Now()
 			}
 		}
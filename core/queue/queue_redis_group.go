@@ -6,7 +6,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
 	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
 
 	"github.com/sasha-s/go-deadlock"
 
@@ -18,7 +21,8 @@ import (
 
 type redisGroupQueue struct {
 	opts            option.Options
-	redisClient     *redis.Client
+	redisClient     redis.UniversalClient
+	stream          string // cluster模式下带有hash tag的stream名称
 	groupName       string
 	consumerName    string
 	lastReceiveTime time.Time
@@ -34,11 +38,12 @@ func NewRedisGroupQueue(opts ...option.Option) (q Queue) {
 	options := option.NewOptions(opts...)
 
 	c := options.Config
-	redisClient := redis.NewClient(&redis.Options{Addr: c.Values().Queue.Redis.Addr, Password: c.Values().Queue.Redis.Password, Username: c.Values().Queue.Redis.User, DB: c.Values().Queue.Redis.DB})
+	redisClient := shared.NewRedisClient(c.Values().Queue.Redis)
 
 	return &redisGroupQueue{
 		opts:         options,
 		redisClient:  redisClient,
+		stream:       kit.RedisKey(redisClient, options.Topic, ""),
 		groupName:    "group-" + fmt.Sprint(options.Config.Values().Node),
 		consumerName: "Redis-Consumer-" + fmt.Sprint(options.Config.Values().Node),
 	}
@@ -47,12 +52,26 @@ func NewRedisGroupQueue(opts ...option.Option) (q Queue) {
 func (q *redisGroupQueue) Options() option.Options {
 	return q.opts
 }
-func (q *redisGroupQueue) Publish(ctx context.Context, m *clustermessage.AffairMsg) error {
+
+// Inspect stream长度和连接池状态
+func (q *redisGroupQueue) Inspect(ctx context.Context) Inspection {
+	stream := StreamStats{Stream: q.stream}
+	length, err := q.redisClient.XLen(ctx, q.stream).Result()
+	if err != nil {
+		stream.Error = err.Error()
+	}
+	stream.Length = length
+	return Inspection{Type: QueueTypeRedisGroup, Streams: []StreamStats{stream}, Pool: NewPoolStats(q.redisClient)}
+}
+
+func (q *redisGroupQueue) Publish(ctx context.Context, m *clustermessage.AffairMsg) (err error) {
+	ctx, span := startPublishSpan(ctx, q.opts, QueueTypeRedisGroup, m)
+	defer func() { wstrace.End(span, err) }()
 	messageBytes, err := clustermessage.PackAffair(m)
 	if err != nil {
 		return err
 	}
-	topic := q.opts.Topic
+	topic := q.stream
 	if len(messageBytes) > 1000 {
 		// q.opts.Logger.Debugf(ctx, "publish topic:%s,m:%s", topic, messageBytes[:100])
 
@@ -78,7 +97,7 @@ func (q *redisGroupQueue) Consume(ctx context.Context, _ interface{}) (err error
 	var (
 		queueRedis = q.redisClient
 		logger     = q.opts.Logger
-		topic      = q.opts.Topic
+		topic      = q.stream
 	)
 	r1, err := queueRedis.XGroupCreateMkStream(ctx, topic, q.groupName, "$").Result()
 	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
@@ -136,7 +155,7 @@ func (q *redisGroupQueue) consume(ctx context.Context) {
 	var (
 		queueRedis = q.redisClient
 		logger     = q.opts.Logger
-		topic      = q.opts.Topic
+		topic      = q.stream
 		p          = q.opts.Prometheus
 	)
 
@@ -194,7 +213,10 @@ func (q *redisGroupQueue) consume(ctx context.Context) {
 				continue
 			}
 
-			if isAck := q.opts.Handlers[concreteMsg.Type].Handle(ctx, concreteMsg); isAck {
+			handleCtx, span := startConsumeSpan(ctx, q.opts, "redis_group:"+topic, concreteMsg)
+			isAck := q.opts.Handlers[concreteMsg.Type].Handle(clustermessage.NewContext(handleCtx, concreteMsg), concreteMsg)
+			span.End()
+			if isAck {
 				_, err := queueRedis.XAck(ctx, string(topic), q.groupName, msg.ID).Result()
 				if err != nil {
 					logger.Warnf(ctx, "Redis-Consume failed to ack msg: %s,err:%v", msg.Values["m"].(string), err)
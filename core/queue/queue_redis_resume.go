@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// offsetKeyPrefix 节点在各分片上最后处理的消息ID
+const offsetKeyPrefix = "ws_cluster:queue_offset:"
+
+func (q *redisQueue) offsetKey(shard *redisShard) string {
+	return fmt.Sprintf("%s%s:%s", offsetKeyPrefix, q.offsetOwner, shard.topic)
+}
+
+// offsetOwner 保存消费位置使用的节点标识,需要在重启后保持不变,nats持久化消费者,kafka消费组和本地溢写目录也使用该标识
+// 动态获取的nodeID重启后会变化,配置了node时使用node,否则使用主机名和ws端口
+func offsetOwner(c config.Config, nodeID int64) string {
+	values := c.Values()
+	if values.Node > 0 {
+		return strconv.FormatInt(values.Node, 10)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return strconv.FormatInt(nodeID, 10)
+	}
+	return hostname + ":" + strconv.Itoa(values.WsServer.Port)
+}
+
+// resumeMaxAge 最多回溯的时间,未开启时返回0
+func (q *redisQueue) resumeMaxAge() time.Duration {
+	resume := q.opts.Config.Values().Queue.Resume
+	if !resume.Enable {
+		return 0
+	}
+	return time.Duration(max(resume.MaxAge, 1)) * time.Second
+}
+
+// startID 返回分片开始消费的位置
+// 有保存的位置时从该位置继续,但不早于 MaxAge 之前,否则从stream中最后一条消息之后开始
+func (q *redisQueue) startID(ctx context.Context, shard *redisShard) string {
+	maxAge := q.resumeMaxAge()
+	if maxAge == 0 {
+		return q.lastStreamID(ctx, shard.topic)
+	}
+	savedID, err := q.opts.RedisClient.Get(ctx, q.offsetKey(shard)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			q.opts.Logger.Warnf(ctx, "Redis-Consume failed to load offset of %s:%v", shard.topic, err)
+		}
+		return q.lastStreamID(ctx, shard.topic)
+	}
+	resumeID := q.clampID(savedID)
+	q.opts.Logger.Infof(ctx, "Redis-Consume %s resume from %s,saved %s", shard.topic, resumeID, savedID)
+	return resumeID
+}
+
+// clampID 超过 MaxAge 的位置调整为 MaxAge 之前,未开启时原样返回
+func (q *redisQueue) clampID(id string) string {
+	maxAge := q.resumeMaxAge()
+	if maxAge == 0 {
+		return id
+	}
+	if time.Duration(streamMessageLagMs(id))*time.Millisecond <= maxAge {
+		return id
+	}
+	return strconv.FormatInt(time.Now().Add(-maxAge).UnixMilli(), 10) + "-0"
+}
+
+// saveOffset 按间隔保存分片的消费位置,force为true时立即保存
+func (q *redisQueue) saveOffset(ctx context.Context, shard *redisShard, id string, force bool) {
+	maxAge := q.resumeMaxAge()
+	if maxAge == 0 || id == "" || id == shard.savedID {
+		return
+	}
+	interval := time.Duration(q.opts.Config.Values().Queue.Resume.SaveIntervalMs) * time.Millisecond
+	if !force && time.Since(shard.savedAt) < max(interval, 100*time.Millisecond) {
+		return
+	}
+	// 位置只在 MaxAge 内有意义,过期后自然删除
+	if err := q.opts.RedisClient.Set(ctx, q.offsetKey(shard), id, maxAge).Err(); err != nil {
+		q.opts.Logger.Warnf(ctx, "Redis-Consume failed to save offset of %s:%v", shard.topic, err)
+		return
+	}
+	shard.savedID, shard.savedAt = id, time.Now()
+}
+
+// isStale 消息是否超过了所属类型的有效期
+func (q *redisQueue) isStale(msgType string, lagMs float64) bool {
+	return isStaleMessage(q.opts, msgType, lagMs)
+}
+
+// isStaleMessage 消息等待时间超过该类型的有效期
+func isStaleMessage(opts option.Options, msgType string, lagMs float64) bool {
+	freshness := opts.Config.Values().Queue.Resume.Freshness[msgType]
+	return freshness > 0 && lagMs > float64(freshness)
+}
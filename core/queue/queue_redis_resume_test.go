@@ -0,0 +1,26 @@
+package queue
+
+import (
+	"os"
+	"testing"
+)
+
+// 消费位置的key不能依赖重启后会变化的动态nodeID
+func TestOffsetOwnerStable(t *testing.T) {
+	c := &testConfig{}
+	c.values.WsServer.Port = 8084
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	if got, want := offsetOwner(c, 3), hostname+":8084"; got != want {
+		t.Fatalf("owner = %s, want %s", got, want)
+	}
+	if offsetOwner(c, 3) != offsetOwner(c, 4) {
+		t.Fatal("owner should not change with dynamic node id")
+	}
+	c.values.Node = 7
+	if got := offsetOwner(c, 3); got != "7" {
+		t.Fatalf("owner = %s, want configured node 7", got)
+	}
+}
@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/core/queue/spill"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/shared/kit/retry"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+)
+
+const spillReplayBatch = 500
+
+// openSpill 打开分片的本地溢写文件,未开启或打开失败时返回nil,此时写入失败的消息直接丢弃
+func (q *redisQueue) openSpill(topic string) *spill.Spill {
+	c := q.opts.Config.Values().Queue.Spill
+	if !c.Enable {
+		return nil
+	}
+	// 使用重启后不变的节点标识,重启后继续重放上次运行写入的消息
+	replacer := strings.NewReplacer(":", "_", "/", "_")
+	dir := filepath.Join(
+		kit.IfElse(c.Dir != "", c.Dir, "./spill"),
+		"node-"+replacer.Replace(q.offsetOwner),
+		replacer.Replace(topic),
+	)
+	s, err := spill.Open(spill.Options{
+		Dir:      dir,
+		MaxBytes: int64(max(c.MaxMB, 1)) << 20,
+		MaxAge:   time.Duration(c.MaxAge) * time.Second,
+		OnDrop: func(reason string, count int) {
+			_ = q.opts.Prometheus.GetAdd(wsprometheus.MetricQueueSpillDrop, append(q.metricLabels, reason), float64(count))
+			q.opts.Logger.Warnf(q.opts.Ctx, "Redis-Spill %s drop %d messages,reason:%s", topic, count, reason)
+		},
+	})
+	if err != nil {
+		q.opts.Logger.Warnf(q.opts.Ctx, "Redis-Spill open %s error:%v,spill disabled", dir, err)
+		return nil
+	}
+	if s.Pending() {
+		q.opts.Logger.Infof(q.opts.Ctx, "Redis-Spill %s found %d bytes to replay", topic, s.Size())
+	}
+	return s
+}
+
+// spillMessages 将写入redis失败的消息保存到本地文件,返回是否保存成功
+func (q *redisQueue) spillMessages(ctx context.Context, shard *redisShard, messages [][]byte) bool {
+	if shard.spill == nil || len(messages) == 0 {
+		return false
+	}
+	if err := shard.spill.Append(messages); err != nil {
+		q.opts.Logger.Warnf(ctx, "Redis-Spill %s append %d messages error:%v", shard.topic, len(messages), err)
+		return false
+	}
+	_ = q.opts.Prometheus.GetSet(wsprometheus.MetricQueueSpillBytes, shard.labels, float64(shard.spill.Size()))
+	return true
+}
+
+// replayLoop redis恢复后按顺序将本地文件中的消息重新写入,失败时按退避时间重试
+func (q *redisQueue) replayLoop(ctx context.Context, shard *redisShard) {
+	var (
+		logger  = q.opts.Logger
+		backoff = retry.NewBackoff(retry.WithMin(time.Second), retry.WithMax(30*time.Second), retry.WithJitter(true))
+		wait    = time.Second
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = time.Second
+		if !shard.spill.Pending() {
+			continue
+		}
+		err := q.opts.RedisClient.Ping(ctx).Err()
+		if err == nil {
+			var count int
+			count, err = shard.spill.Replay(spillReplayBatch, func(messages [][]byte) error {
+				return q.xadd(ctx, shard.topic, messages)
+			})
+			if count > 0 {
+				q.publishTimes.Add(int64(count))
+				_ = q.opts.Prometheus.GetAdd(wsprometheus.MerticQueueEnter, q.metricLabels, float64(count))
+				logger.Infof(ctx, "Redis-Spill %s replayed %d messages,remain %d bytes", shard.topic, count, shard.spill.Size())
+			}
+		}
+		_ = q.opts.Prometheus.GetSet(wsprometheus.MetricQueueSpillBytes, shard.labels, float64(shard.spill.Size()))
+		if err != nil {
+			wait = backoff.Duration()
+			logger.Warnf(ctx, "Redis-Spill %s replay error:%v,retry after %s", shard.topic, err, wait)
+			continue
+		}
+		backoff.Reset()
+	}
+}
+
+// xadd 使用pipeline写入一批消息,任意一条失败时返回错误,重放时整批重试
+func (q *redisQueue) xadd(ctx context.Context, topic string, messages [][]byte) error {
+	pipe := q.opts.RedisClient.Pipeline()
+	for _, message := range messages {
+		_ = pipe.Do(ctx, "XADD", topic, "*", "m", message)
+	}
+	cmds, err := pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			return cmd.Err()
+		}
+	}
+	return nil
+}
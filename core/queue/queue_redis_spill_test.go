@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+)
+
+// newSpillQueue 模拟一次进程启动,每次启动动态获取的nodeID不同
+func newSpillQueue(c config.Config, nodeID int64) *redisQueue {
+	return &redisQueue{
+		opts:         option.NewOptions(option.WithConfig(c)),
+		nodeID:       nodeID,
+		offsetOwner:  offsetOwner(c, nodeID),
+		metricLabels: []string{fmt.Sprint(nodeID), "127.0.0.1"},
+	}
+}
+
+// 重启后nodeID变化,仍然能重放上次运行写入本地文件的消息
+func TestSpillReopenAfterRestart(t *testing.T) {
+	c := &testConfig{}
+	c.values.WsServer.Port = 8084
+	c.values.Queue.Spill = config.QueueSpill{Enable: true, Dir: t.TempDir(), MaxMB: 1}
+
+	s := newSpillQueue(c, 1).openSpill("ws_queue_stream")
+	if s == nil {
+		t.Fatal("open spill failed")
+	}
+	if err := s.Append([][]byte{[]byte("m0"), []byte("m1")}); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+
+	s = newSpillQueue(c, 2).openSpill("ws_queue_stream")
+	if s == nil {
+		t.Fatal("reopen spill failed")
+	}
+	defer s.Close()
+	if !s.Pending() {
+		t.Fatal("messages written by previous run should be pending")
+	}
+	var got []string
+	if _, err := s.Replay(10, func(messages [][]byte) error {
+		for _, m := range messages {
+			got = append(got, string(m))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "m0" || got[1] != "m1" {
+		t.Fatalf("replayed %v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package slowlog
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var DefaultRing = NewRing(200)
+
+// Entry 一次较慢的消息分发
+type Entry struct {
+	At         time.Time `json:"at"`
+	Source     string    `json:"source"`              // redis:topic,kafka:topic,nats,memory
+	SourceID   string    `json:"source_id,omitempty"` // 消息在队列中的位置,如stream id,partition-offset
+	Type       string    `json:"type"`
+	PID        string    `json:"pid,omitempty"`
+	AffairID   string    `json:"affair_id,omitempty"`
+	MsgID      string    `json:"msg_id,omitempty"`
+	DispatchMs float64   `json:"dispatch_ms"`
+	Payload    string    `json:"payload,omitempty"` // 截断后的内容
+}
+
+// Ring 保存最近的较慢分发,写满后覆盖最早的记录,并发安全
+type Ring struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+func NewRing(size int) *Ring {
+	if size <= 0 {
+		size = 1
+	}
+	return &Ring{entries: make([]Entry, size)}
+}
+
+func (r *Ring) Add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = e
+	r.next++
+	if r.next == len(r.entries) {
+		r.next, r.full = 0, true
+	}
+}
+
+// Slowest 返回最近的记录中最慢的n条,按耗时从高到低排序,n小于等于0时返回全部
+func (r *Ring) Slowest(n int) []Entry {
+	r.mu.Lock()
+	count := r.next
+	if r.full {
+		count = len(r.entries)
+	}
+	list := make([]Entry, count)
+	copy(list, r.entries[:count])
+	r.mu.Unlock()
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].DispatchMs > list[j].DispatchMs })
+	if n > 0 && n < len(list) {
+		list = list[:n]
+	}
+	return list
+}
+
+// Len 当前保存的记录数量
+func (r *Ring) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.full {
+		return len(r.entries)
+	}
+	return r.next
+}
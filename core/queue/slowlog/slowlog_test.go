@@ -0,0 +1,56 @@
+package slowlog
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRingSlowest(t *testing.T) {
+	r := NewRing(3)
+	if got := r.Slowest(0); len(got) != 0 {
+		t.Fatalf("empty ring should return nothing, got %v", got)
+	}
+	r.Add(Entry{SourceID: "1", DispatchMs: 60})
+	r.Add(Entry{SourceID: "2", DispatchMs: 200})
+	if got := r.Slowest(0); len(got) != 2 || got[0].SourceID != "2" || got[1].SourceID != "1" {
+		t.Fatalf("slowest = %+v", got)
+	}
+
+	// 写满后覆盖最早的记录
+	r.Add(Entry{SourceID: "3", DispatchMs: 80})
+	r.Add(Entry{SourceID: "4", DispatchMs: 50})
+	got := r.Slowest(0)
+	if len(got) != 3 || r.Len() != 3 {
+		t.Fatalf("len = %d, slowest = %+v", r.Len(), got)
+	}
+	for i, want := range []string{"2", "3", "4"} {
+		if got[i].SourceID != want {
+			t.Fatalf("slowest[%d] = %s, want %s", i, got[i].SourceID, want)
+		}
+	}
+	if got := r.Slowest(1); len(got) != 1 || got[0].SourceID != "2" {
+		t.Fatalf("top 1 = %+v", got)
+	}
+}
+
+func TestRingConcurrent(t *testing.T) {
+	r := NewRing(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				r.Add(Entry{SourceID: strconv.Itoa(i), DispatchMs: float64(j)})
+				if j%100 == 0 {
+					_ = r.Slowest(10)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if r.Len() != 100 {
+		t.Fatalf("len = %d", r.Len())
+	}
+}
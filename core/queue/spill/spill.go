@@ -0,0 +1,396 @@
+package spill
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DropReasonExpired  = "expired"  // 超过 MaxAge 的消息
+	DropReasonOverflow = "overflow" // 超过 MaxBytes 时丢弃最早的分段
+	DropReasonCorrupt  = "corrupt"  // 分段文件损坏,如写入过程中进程退出
+)
+
+const (
+	segmentSuffix = ".spill"
+	headerSize    = 16 // 4字节长度 + 4字节crc32 + 8字节写入时间(ms)
+	maxRecordSize = 64 * 1024 * 1024
+)
+
+var ErrClosed = errors.New("spill closed")
+
+type Options struct {
+	Dir          string
+	MaxBytes     int64         // 所有分段文件的最大字节数,超过时丢弃最早的分段
+	MaxAge       time.Duration // 超过该时间的消息在重放时丢弃,为0时不限制
+	SegmentBytes int64         // 单个分段文件的大小,超过后写入新的分段
+	// OnDrop 消息被丢弃时调用,用于统计
+	OnDrop func(reason string, count int)
+}
+
+type segment struct {
+	seq     uint64
+	path    string
+	size    int64
+	records int
+}
+
+// Spill 基于本地文件的溢写日志,消息按写入顺序分段保存,重放成功后删除
+// 进程重启后会继续重放之前未完成的分段,同一条消息可能被重放多次
+type Spill struct {
+	opts Options
+
+	mu       sync.Mutex
+	segments []*segment // 按写入顺序排列,最后一个为正在写入的分段
+	active   *os.File   // 正在写入的分段,为空时下次写入创建新的分段
+	size     int64
+	closed   bool
+
+	replayMu     sync.Mutex
+	replaying    uint64 // 正在重放的分段,不会因为超过 MaxBytes 被删除
+	replayOffset int64  // 正在重放的分段中已重放的位置
+}
+
+// Open 打开目录中的溢写日志,目录不存在时创建
+func Open(opts Options) (*Spill, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("spill dir is required")
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 1 << 30
+	}
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = min(4<<20, max(opts.MaxBytes/4, 1))
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Spill{opts: opts}
+	entries, err := os.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg := &segment{seq: seq, path: filepath.Join(opts.Dir, name)}
+		seg.size, seg.records, err = scanSegment(seg.path)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+	return s, nil
+}
+
+// Size 未重放的消息占用的字节数
+func (s *Spill) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Pending 是否有未重放的消息
+func (s *Spill) Pending() bool {
+	return s.Size() > 0
+}
+
+// Append 按顺序写入一批消息,超过 MaxBytes 时先丢弃最早的分段
+func (s *Spill) Append(payloads [][]byte) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	buf := make([]byte, 0, 4096)
+	for _, payload := range payloads {
+		buf = appendRecord(buf, now, payload)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.makeRoom(int64(len(buf)))
+	if s.active == nil || s.segments[len(s.segments)-1].size >= s.opts.SegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	seg := s.segments[len(s.segments)-1]
+	n, err := s.active.Write(buf)
+	seg.size += int64(n)
+	s.size += int64(n)
+	if err != nil {
+		// 写入一半的记录在重放时会被识别为损坏,不再继续写入该分段
+		_ = s.active.Close()
+		s.active = nil
+		return err
+	}
+	seg.records += len(payloads)
+	return nil
+}
+
+// rotate 关闭正在写入的分段,创建新的分段
+func (s *Spill) rotate() error {
+	if s.active != nil {
+		_ = s.active.Close()
+		s.active = nil
+	}
+	var seq uint64 = 1
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+	path := filepath.Join(s.opts.Dir, fmt.Sprintf("%016d%s", seq, segmentSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.segments = append(s.segments, &segment{seq: seq, path: path})
+	return nil
+}
+
+// makeRoom 丢弃最早的分段直到可以写入 n 字节,正在重放和正在写入的分段除外
+func (s *Spill) makeRoom(n int64) {
+	for s.size+n > s.opts.MaxBytes {
+		index := -1
+		for i, seg := range s.segments {
+			if seg.seq == s.replaying || (s.active != nil && i == len(s.segments)-1) {
+				continue
+			}
+			index = i
+			break
+		}
+		if index < 0 {
+			return
+		}
+		seg := s.segments[index]
+		_ = os.Remove(seg.path)
+		s.segments = append(s.segments[:index], s.segments[index+1:]...)
+		s.size -= seg.size
+		s.drop(DropReasonOverflow, seg.records)
+	}
+}
+
+// Replay 按写入顺序将消息分批交给 fn,fn 返回错误时停止,下次从失败的批次继续
+// 同一时间只能有一个调用方重放
+func (s *Spill) Replay(batchSize int, fn func(payloads [][]byte) error) (count int, err error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	for {
+		seg := s.nextSegment()
+		if seg == nil {
+			return count, nil
+		}
+		n, err := s.replaySegment(seg, batchSize, fn)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+// nextSegment 返回最早的分段,正在写入的分段会先被关闭
+func (s *Spill) nextSegment() *segment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segments) == 0 {
+		s.replaying = 0
+		return nil
+	}
+	seg := s.segments[0]
+	if seg.seq != s.replaying {
+		s.replaying = seg.seq
+		s.replayOffset = 0
+	}
+	if s.active != nil && len(s.segments) == 1 {
+		_ = s.active.Close()
+		s.active = nil
+	}
+	return seg
+}
+
+func (s *Spill) replaySegment(seg *segment, batchSize int, fn func(payloads [][]byte) error) (count int, err error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.removeSegment(seg)
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(s.replayOffset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var (
+		reader   = bufio.NewReaderSize(f, 64*1024)
+		offset   = s.replayOffset
+		batch    = make([][]byte, 0, batchSize)
+		batchEnd = offset
+		expired  = 0
+		minTime  int64
+	)
+	if s.opts.MaxAge > 0 {
+		minTime = time.Now().Add(-s.opts.MaxAge).UnixMilli()
+	}
+	flush := func() error {
+		if len(batch) > 0 {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			count += len(batch)
+			batch = batch[:0]
+		}
+		s.replayOffset = batchEnd
+		return nil
+	}
+	for {
+		createdAt, payload, n, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.drop(DropReasonCorrupt, max(seg.records-count-expired-len(batch), 1))
+			break
+		}
+		offset += n
+		batchEnd = offset
+		if createdAt < minTime {
+			expired++
+			continue
+		}
+		batch = append(batch, payload)
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return count, err
+	}
+	if expired > 0 {
+		s.drop(DropReasonExpired, expired)
+	}
+	s.removeSegment(seg)
+	return count, nil
+}
+
+func (s *Spill) removeSegment(seg *segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = os.Remove(seg.path)
+	for i, item := range s.segments {
+		if item == seg {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			s.size -= seg.size
+			break
+		}
+	}
+	s.replaying = 0
+	s.replayOffset = 0
+}
+
+func (s *Spill) drop(reason string, count int) {
+	if s.opts.OnDrop != nil && count > 0 {
+		s.opts.OnDrop(reason, count)
+	}
+}
+
+// Close 关闭正在写入的分段,未重放的消息保留在磁盘中
+func (s *Spill) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active = nil
+	return err
+}
+
+func appendRecord(buf []byte, createdAt int64, payload []byte) []byte {
+	var header [headerSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint64(header[8:16], uint64(createdAt))
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(header[8:16])
+	_, _ = crc.Write(payload)
+	binary.BigEndian.PutUint32(header[4:8], crc.Sum32())
+	buf = append(buf, header[:]...)
+	return append(buf, payload...)
+}
+
+// readRecord 读取一条记录,返回写入时间、内容和记录占用的字节数
+func readRecord(r io.Reader) (createdAt int64, payload []byte, n int64, err error) {
+	var header [headerSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, 0, errors.New("truncated record header")
+		}
+		return 0, nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return 0, nil, 0, fmt.Errorf("invalid record length %d", length)
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, 0, errors.New("truncated record payload")
+	}
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(header[8:16])
+	_, _ = crc.Write(payload)
+	if crc.Sum32() != binary.BigEndian.Uint32(header[4:8]) {
+		return 0, nil, 0, errors.New("record checksum mismatch")
+	}
+	return int64(binary.BigEndian.Uint64(header[8:16])), payload, headerSize + int64(length), nil
+}
+
+// scanSegment 统计分段文件中有效的记录数量
+func scanSegment(path string) (size int64, records int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	reader := bufio.NewReaderSize(f, 64*1024)
+	for {
+		if _, _, _, err := readRecord(reader); err != nil {
+			break
+		}
+		records++
+	}
+	return info.Size(), records, nil
+}
@@ -0,0 +1,199 @@
+package spill
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func payloads(from, to int) [][]byte {
+	result := make([][]byte, 0, to-from)
+	for i := from; i < to; i++ {
+		result = append(result, []byte(strconv.Itoa(i)))
+	}
+	return result
+}
+
+func collect(t *testing.T, s *Spill, batchSize int) []string {
+	t.Helper()
+	var got []string
+	_, err := s.Replay(batchSize, func(batch [][]byte) error {
+		for _, payload := range batch {
+			got = append(got, string(payload))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replay error:%v", err)
+	}
+	return got
+}
+
+func assertSequence(t *testing.T, got []string, from, to int) {
+	t.Helper()
+	if len(got) != to-from {
+		t.Fatalf("expect %d messages, got %d", to-from, len(got))
+	}
+	for i, value := range got {
+		if value != strconv.Itoa(from+i) {
+			t.Fatalf("expect %d at %d, got %s", from+i, i, value)
+		}
+	}
+}
+
+func TestAppendReplayOrder(t *testing.T) {
+	s, err := Open(Options{Dir: t.TempDir(), SegmentBytes: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := s.Append(payloads(i*50, (i+1)*50)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.Pending() {
+		t.Fatal("expect pending")
+	}
+	assertSequence(t, collect(t, s, 64), 0, 500)
+	if s.Pending() || s.Size() != 0 {
+		t.Fatalf("expect empty after replay, size:%d", s.Size())
+	}
+}
+
+func TestReplayResumeAfterError(t *testing.T) {
+	s, err := Open(Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Append(payloads(0, 100))
+
+	var got []string
+	calls := 0
+	count, err := s.Replay(10, func(batch [][]byte) error {
+		calls++
+		if calls == 4 {
+			return errors.New("broker down")
+		}
+		for _, payload := range batch {
+			got = append(got, string(payload))
+		}
+		return nil
+	})
+	if err == nil || count != 30 {
+		t.Fatalf("expect error after 30 messages, count:%d err:%v", count, err)
+	}
+	// 写入失败期间新的消息追加在后面
+	_ = s.Append(payloads(100, 120))
+	got = append(got, collect(t, s, 10)...)
+	assertSequence(t, got, 0, 120)
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir, SegmentBytes: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Append(payloads(0, 60))
+	size := s.Size()
+	_ = s.Close()
+	if err := s.Append(payloads(60, 61)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+
+	s, err = Open(Options{Dir: dir, SegmentBytes: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != size {
+		t.Fatalf("expect size %d after reopen, got %d", size, s.Size())
+	}
+	_ = s.Append(payloads(60, 80))
+	assertSequence(t, collect(t, s, 7), 0, 80)
+}
+
+func TestMaxBytesDropsOldest(t *testing.T) {
+	dropped := map[string]int{}
+	s, err := Open(Options{
+		Dir:          t.TempDir(),
+		MaxBytes:     1024,
+		SegmentBytes: 256,
+		OnDrop: func(reason string, count int) {
+			dropped[reason] += count
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := s.Append(payloads(i, i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Size() > 1024 {
+		t.Fatalf("expect size <= 1024, got %d", s.Size())
+	}
+	got := collect(t, s, 100)
+	if dropped[DropReasonOverflow]+len(got) != 200 {
+		t.Fatalf("expect dropped + replayed = 200, dropped:%d replayed:%d", dropped[DropReasonOverflow], len(got))
+	}
+	// 保留的是最新的消息
+	assertSequence(t, got, 200-len(got), 200)
+}
+
+func TestMaxAge(t *testing.T) {
+	dropped := map[string]int{}
+	s, err := Open(Options{
+		Dir:    t.TempDir(),
+		MaxAge: 50 * time.Millisecond,
+		OnDrop: func(reason string, count int) {
+			dropped[reason] += count
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Append(payloads(0, 10))
+	time.Sleep(100 * time.Millisecond)
+	_ = s.Append(payloads(10, 15))
+	assertSequence(t, collect(t, s, 100), 10, 15)
+	if dropped[DropReasonExpired] != 10 {
+		t.Fatalf("expect 10 expired, got %d", dropped[DropReasonExpired])
+	}
+}
+
+func TestCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Append(payloads(0, 10))
+	_ = s.Close()
+
+	// 模拟写入过程中进程退出
+	entries, _ := os.ReadDir(dir)
+	f, err := os.OpenFile(dir+"/"+entries[0].Name(), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 9, 1, 2})
+	_ = f.Close()
+
+	dropped := map[string]int{}
+	s, err = Open(Options{Dir: dir, OnDrop: func(reason string, count int) {
+		dropped[reason] += count
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSequence(t, collect(t, s, 100), 0, 10)
+	if dropped[DropReasonCorrupt] == 0 {
+		t.Fatal("expect corrupt tail reported")
+	}
+	if s.Pending() {
+		t.Fatal("expect empty after replay")
+	}
+}
@@ -0,0 +1,30 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startPublishSpan 开始发布消息的span,并将链路上下文写入消息头,消费的节点从消息头恢复链路
+func startPublishSpan(ctx context.Context, opts option.Options, backend string, m *clustermessage.AffairMsg) (context.Context, trace.Span) {
+	ctx, span := opts.Tracer.Start(ctx, "queue.publish", attribute.String("queue", backend), attribute.String("type", string(m.Type)))
+	// 已经携带链路上下文的消息(如同时写入两个队列)不再覆盖,消息可能已经交给其他队列的发布协程
+	if m.Header == nil || len(m.Header.Trace) == 0 {
+		opts.Tracer.Inject(ctx, m)
+	}
+	return ctx, span
+}
+
+// startConsumeSpan 从消息头恢复上游链路后开始消费消息的span
+func startConsumeSpan(ctx context.Context, opts option.Options, source string, m *clustermessage.AffairMsg) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("source", source), attribute.String("type", string(m.Type))}
+	if m.Header != nil && m.Header.MsgID != "" {
+		attrs = append(attrs, attribute.String("msg_id", m.Header.MsgID))
+	}
+	return opts.Tracer.Start(opts.Tracer.Extract(ctx, m), "queue.consume", attrs...)
+}
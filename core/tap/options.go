@@ -0,0 +1,66 @@
+package tap
+
+import (
+	"context"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/logger"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Options struct {
+	Ctx       context.Context
+	Config    config.Config
+	Redis     redis.UniversalClient // 为空时使用默认的redis,单节点部署不使用redis
+	Logger    logger.Logger
+	Interval  time.Duration // 从redis同步所有节点订阅条件的间隔
+	QueueSize int           // 本地待发送消息记录的缓冲数量,超过后丢弃
+}
+
+func NewOptions(opts ...Option) *Options {
+	opt := &Options{
+		Ctx:       context.Background(),
+		Config:    config.DefaultConfig,
+		Logger:    logger.DefaultLogger,
+		Interval:  time.Second,
+		QueueSize: 1000,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return opt
+}
+
+type Option func(*Options)
+
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Ctx = ctx
+	}
+}
+
+func WithConfig(c config.Config) Option {
+	return func(o *Options) {
+		o.Config = c
+	}
+}
+
+func WithRedis(redis redis.UniversalClient) Option {
+	return func(o *Options) {
+		o.Redis = redis
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+func WithInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.Interval = interval
+	}
+}
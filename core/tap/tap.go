@@ -0,0 +1,327 @@
+package tap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/core/tap/tapevent"
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	filtersKey         = "ws_cluster:tap:filters" // 所有节点的订阅条件 hash field:tapID value:Filter
+	eventChannelPrefix = "ws_cluster:tap:event:"  // 订阅者所在节点通过该频道接收所有节点的消息记录
+)
+
+var ErrDisabled = fmt.Errorf("tap is disabled")
+
+type (
+	Filter = tapevent.Filter
+	Point  = tapevent.Point
+	Event  = tapevent.Event
+)
+
+var DefaultTap = NewTap()
+
+// Tap 管理端订阅实时消息
+// 订阅条件写入redis,每个节点定时同步所有的订阅条件,消息经过入口,消费和投递时匹配订阅条件,
+// 采样,限流并脱敏后通过redis的pub/sub发送到订阅者所在的节点,单节点部署时在本地直接发送
+type Tap struct {
+	opts       *Options
+	startOnce  sync.Once
+	nodeID     int64
+	standalone bool
+
+	filters atomic.Pointer[[]*Filter] // 所有节点的订阅条件
+
+	mu   sync.Mutex
+	subs map[string]*subscription // 本节点的订阅 key:tapID
+
+	pending     chan *Event
+	rates       sync.Map // key:tapID value:*rateWindow
+	lastDropLog atomic.Int64
+}
+
+type subscription struct {
+	filter *Filter
+	events chan *Event
+}
+
+type rateWindow struct {
+	mu     sync.Mutex
+	second int64
+	count  int
+}
+
+// NewTap 创建时不读取配置,DefaultTap 在包初始化时创建,配置和redis在 Start 时才使用
+func NewTap(opts ...Option) *Tap {
+	t := &Tap{
+		opts: NewOptions(opts...),
+		subs: make(map[string]*subscription),
+	}
+	t.filters.Store(&[]*Filter{})
+	t.pending = make(chan *Event, t.opts.QueueSize)
+	return t
+}
+
+// Start 读取节点id和配置,开启时启动发送和同步订阅条件的协程,只执行一次
+// Subscribe 和 Observe 会自动调用
+func (t *Tap) Start() {
+	t.startOnce.Do(func() {
+		t.nodeID = shared.GetNodeID()
+		t.standalone = t.opts.Config.Values().Standalone()
+		if !t.standalone && t.opts.Redis == nil {
+			t.opts.Redis = shared.GetRedis()
+		}
+		if !t.opts.Config.Values().Tap.Enable {
+			return
+		}
+		go t.sendLoop()
+		if !t.standalone {
+			go t.syncLoop()
+		}
+	})
+}
+
+// Subscribe 订阅符合条件的消息,直到ctx结束或超过配置的最长时间,返回的done在订阅结束后关闭
+// deliver在同一个协程中按顺序调用
+func (t *Tap) Subscribe(ctx context.Context, filter Filter, deliver func(*Event)) (tapID string, done <-chan struct{}, err error) {
+	t.Start()
+	c := t.opts.Config.Values().Tap
+	if !c.Enable {
+		return "", nil, ErrDisabled
+	}
+	if filter.PID == "" && filter.UID == "" && filter.CID == "" {
+		return "", nil, fmt.Errorf("at least one of pid,uid,cid is required")
+	}
+	if filter.Sample < 0 || filter.Sample > 1 {
+		return "", nil, fmt.Errorf("sample should be in [0,1],0 means no sampling")
+	}
+	ttl := time.Duration(c.TTL) * time.Second
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	filter.ID = shared.GetSnowflakeNode().Generate().String()
+	filter.Node = t.nodeID
+	filter.ExpireAt = time.Now().Add(ttl).UnixMilli()
+	ctx, cancel := context.WithTimeout(ctx, ttl)
+
+	sub := &subscription{filter: &filter, events: make(chan *Event, t.opts.QueueSize)}
+	receive := func() {}
+	if !t.standalone {
+		// 先订阅频道再写入订阅条件,不会漏掉其他节点的消息记录
+		ps := t.opts.Redis.Subscribe(ctx, eventChannelPrefix+filter.ID)
+		if _, err := ps.Receive(ctx); err != nil {
+			cancel()
+			_ = ps.Close()
+			return "", nil, err
+		}
+		bytes, err := json.Marshal(&filter)
+		if err == nil {
+			err = t.opts.Redis.HSet(ctx, filtersKey, filter.ID, bytes).Err()
+		}
+		if err != nil {
+			cancel()
+			_ = ps.Close()
+			return "", nil, err
+		}
+		receive = func() {
+			defer ps.Close()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case m, ok := <-ps.Channel():
+					if !ok {
+						return
+					}
+					e := &Event{}
+					if err := json.Unmarshal([]byte(m.Payload), e); err != nil {
+						continue
+					}
+					select {
+					case sub.events <- e:
+					default:
+					}
+				}
+			}
+		}
+	}
+
+	t.mu.Lock()
+	t.subs[filter.ID] = sub
+	t.mu.Unlock()
+	t.refreshFilters(nil)
+	t.opts.Logger.Infof(ctx, "Tap subscribe:%s,pid:%s,uid:%s,cid:%s,types:%v,sample:%v", filter.ID, filter.PID, filter.UID, filter.CID, filter.Types, filter.Sample)
+
+	doneCh := make(chan struct{})
+	go receive()
+	go func() {
+		defer close(doneCh)
+		defer cancel()
+		defer t.unsubscribe(filter.ID)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-sub.events:
+				deliver(e)
+			}
+		}
+	}()
+	return filter.ID, doneCh, nil
+}
+
+// Observe 记录消息经过的位置,没有订阅时直接返回
+func (t *Tap) Observe(ctx context.Context, p Point) {
+	t.Start()
+	filters := *t.filters.Load()
+	if len(filters) == 0 || p.Msg == nil {
+		return
+	}
+	var (
+		c   = t.opts.Config.Values().Tap
+		now = time.Now()
+	)
+	for _, f := range filters {
+		if f.ExpireAt <= now.UnixMilli() || !f.Match(&p) || !f.Sampled(p.Msg) || !t.allow(f.ID, now, c.MaxRate) {
+			continue
+		}
+		e := tapevent.NewEvent(f.ID, t.nodeID, now.UnixMicro(), &p, c.RedactFields, c.PayloadLimit)
+		select {
+		case t.pending <- e:
+		default:
+			if kit.AllowByInterval(&t.lastDropLog, 2*time.Second) {
+				t.opts.Logger.Warnf(ctx, "Tap pending queue full,drop event of tap:%s", f.ID)
+			}
+		}
+	}
+}
+
+func (t *Tap) unsubscribe(tapID string) {
+	t.mu.Lock()
+	delete(t.subs, tapID)
+	t.mu.Unlock()
+	t.rates.Delete(tapID)
+	t.refreshFilters(nil)
+	if !t.standalone {
+		if err := t.opts.Redis.HDel(context.Background(), filtersKey, tapID).Err(); err != nil {
+			t.opts.Logger.Warnf(context.Background(), "Tap unsubscribe:%s error:%v", tapID, err)
+		}
+	}
+	t.opts.Logger.Infof(context.Background(), "Tap unsubscribe:%s", tapID)
+}
+
+// allow 每个订阅在本节点上每秒最多推送maxRate条消息记录,maxRate小于等于0时不限制
+func (t *Tap) allow(tapID string, now time.Time, maxRate int) bool {
+	if maxRate <= 0 {
+		return true
+	}
+	v, _ := t.rates.LoadOrStore(tapID, &rateWindow{})
+	w := v.(*rateWindow)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if second := now.Unix(); second != w.second {
+		w.second, w.count = second, 0
+	}
+	if w.count >= maxRate {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// sendLoop 将消息记录发送到订阅者所在的节点,单节点部署时直接交给本地的订阅
+func (t *Tap) sendLoop() {
+	ctx := t.opts.Ctx
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-t.pending:
+			if t.standalone {
+				t.mu.Lock()
+				sub, ok := t.subs[e.TapID]
+				t.mu.Unlock()
+				if ok {
+					select {
+					case sub.events <- e:
+					default:
+					}
+				}
+				continue
+			}
+			bytes, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if err := t.opts.Redis.Publish(ctx, eventChannelPrefix+e.TapID, bytes).Err(); err != nil && kit.AllowByInterval(&t.lastDropLog, 2*time.Second) {
+				t.opts.Logger.Warnf(ctx, "Tap publish event of tap:%s error:%v", e.TapID, err)
+			}
+		}
+	}
+}
+
+// syncLoop 定时从redis同步所有节点的订阅条件,删除订阅节点异常退出后遗留的过期条件
+func (t *Tap) syncLoop() {
+	ctx := t.opts.Ctx
+	ticker := time.NewTicker(t.opts.Interval)
+	defer ticker.Stop()
+	var lastErrLog atomic.Int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		values, err := t.opts.Redis.HGetAll(ctx, filtersKey).Result()
+		if err != nil {
+			if kit.AllowByInterval(&lastErrLog, 10*time.Second) {
+				t.opts.Logger.Warnf(ctx, "Tap sync filters error:%v", err)
+			}
+			continue
+		}
+		now := time.Now().UnixMilli()
+		remote := make([]*Filter, 0, len(values))
+		for id, value := range values {
+			f := &Filter{}
+			if err := json.Unmarshal([]byte(value), f); err != nil || f.ExpireAt <= now {
+				t.opts.Redis.HDel(ctx, filtersKey, id)
+				continue
+			}
+			remote = append(remote, f)
+		}
+		t.refreshFilters(remote)
+	}
+}
+
+// refreshFilters 合并redis中的和本节点的订阅条件,remote为空时保留上一次同步的结果
+func (t *Tap) refreshFilters(remote []*Filter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if remote == nil {
+		for _, f := range *t.filters.Load() {
+			if f.Node != t.nodeID {
+				remote = append(remote, f)
+			}
+		}
+	}
+	filters := make([]*Filter, 0, len(remote)+len(t.subs))
+	for _, f := range remote {
+		if _, ok := t.subs[f.ID]; !ok && f.Node != t.nodeID {
+			filters = append(filters, f)
+		}
+	}
+	for _, sub := range t.subs {
+		filters = append(filters, sub.filter)
+	}
+	t.filters.Store(&filters)
+}
@@ -0,0 +1,93 @@
+package tap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/tap/tapevent"
+)
+
+type testConfig struct {
+	values *config.Values
+}
+
+func (c testConfig) Values() *config.Values {
+	return c.values
+}
+
+func newStandaloneTap(t *testing.T, maxRate int) *Tap {
+	t.Helper()
+	// 不依赖配置文件,单节点模式只需要队列类型和tap配置
+	values := config.Values{
+		Queue: config.Queue{Use: config.QueueMemory},
+		Tap:   config.Tap{Enable: true, TTL: 60, MaxRate: maxRate, RedactFields: []string{"token"}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewTap(WithContext(ctx), WithConfig(testConfig{values: &values}))
+}
+
+func TestStandaloneSubscribe(t *testing.T) {
+	tp := newStandaloneTap(t, 2)
+	msg := &clustermessage.AffairMsg{
+		Type:    clustermessage.TypePush,
+		To:      &clustermessage.To{PID: "77", UIDs: []string{"u1"}},
+		Payload: map[string]interface{}{"token": "secret"},
+	}
+	// 没有订阅时直接返回
+	tp.Observe(context.Background(), Point{Stage: tapevent.StageIngest, Msg: msg})
+
+	if _, _, err := tp.Subscribe(context.Background(), Filter{}, func(*Event) {}); err == nil {
+		t.Fatal("filter without pid,uid,cid should be rejected")
+	}
+	if _, _, err := tp.Subscribe(context.Background(), Filter{UID: "u1", Sample: 1.5}, func(*Event) {}); err == nil {
+		t.Fatal("sample greater than 1 should be rejected")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan *Event, 10)
+	tapID, done, err := tp.Subscribe(ctx, Filter{UID: "u1"}, func(e *Event) { events <- e })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp.Observe(context.Background(), Point{Stage: tapevent.StageIngest, Source: "http", Msg: msg})
+	tp.Observe(context.Background(), Point{Stage: tapevent.StageDeliver, Msg: msg, UID: "u2", CID: "c2"})
+	tp.Observe(context.Background(), Point{Stage: tapevent.StageDeliver, Msg: msg, UID: "u1", CID: "c1"})
+	// 超过每秒的限制
+	tp.Observe(context.Background(), Point{Stage: tapevent.StageDeliver, Msg: msg, UID: "u1", CID: "c3"})
+
+	var got []*Event
+	timeout := time.After(time.Second)
+	for len(got) < 2 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-timeout:
+			t.Fatalf("events = %d", len(got))
+		}
+	}
+	if got[0].TapID != tapID || got[0].Stage != tapevent.StageIngest || got[1].TargetCID != "c1" {
+		t.Fatalf("events = %+v %+v", got[0], got[1])
+	}
+	if got[0].Payload != `{"token":"***"}` {
+		t.Fatalf("payload = %s", got[0].Payload)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("subscription should end after ctx canceled")
+	}
+	if filters := *tp.filters.Load(); len(filters) != 0 {
+		t.Fatalf("filters = %d after unsubscribe", len(filters))
+	}
+}
@@ -0,0 +1,226 @@
+package tapevent
+
+import (
+	"bytes"
+	"hash/fnv"
+	"strings"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// 消息在集群中经过的位置
+const (
+	StageIngest  = "ingest"  // http或ws入口,写入队列前
+	StageConsume = "consume" // 从队列中消费
+	StageDeliver = "deliver" // 写入连接的发送队列
+)
+
+const redacted = "***"
+
+// Filter 订阅条件,为空的字段不参与匹配
+type Filter struct {
+	ID       string   `json:"id"`
+	PID      string   `json:"pid,omitempty"`
+	UID      string   `json:"uid,omitempty"`
+	CID      string   `json:"cid,omitempty"`
+	Types    []string `json:"types,omitempty"`
+	Sample   float64  `json:"sample,omitempty"`    // 采样率[0,1],为0或1时不采样,记录全部消息
+	Node     int64    `json:"node,omitempty"`      // 订阅所在的节点
+	ExpireAt int64    `json:"expire_at,omitempty"` // 过期时间,unix毫秒
+}
+
+// Point 观测点上的消息,UID和CID为投递的目标连接,只有deliver时有值
+type Point struct {
+	Stage  string
+	Source string // http,http_stream,ws,redis:topic,kafka:topic等
+	Msg    *clustermessage.AffairMsg
+	UID    string
+	CID    string
+}
+
+// Event 推送给订阅者的消息记录
+type Event struct {
+	TapID         string  `json:"tap_id"`
+	Stage         string  `json:"stage"`
+	Source        string  `json:"source,omitempty"`
+	Node          int64   `json:"node"`
+	At            int64   `json:"at"`                        // 经过该位置的时间,unix微秒
+	SinceIngestMs float64 `json:"since_ingest_ms,omitempty"` // 距离进入集群的时间
+	MsgID         string  `json:"msg_id,omitempty"`
+	IngestNode    int64   `json:"ingest_node,omitempty"`
+	AffairID      string  `json:"affair_id,omitempty"`
+	Type          string  `json:"type"`
+	PID           string  `json:"pid,omitempty"`
+	FromUID       string  `json:"from_uid,omitempty"`
+	FromCID       string  `json:"from_cid,omitempty"`
+	ToUIDs        int     `json:"to_uids,omitempty"` // 指定的接收用户数量,为0时发送给项目的所有用户
+	ToCIDs        int     `json:"to_cids,omitempty"`
+	TargetUID     string  `json:"target_uid,omitempty"`
+	TargetCID     string  `json:"target_cid,omitempty"`
+	PayloadSize   int     `json:"payload_size"`
+	Payload       string  `json:"payload,omitempty"` // 脱敏并截断后的内容
+}
+
+// Match 判断消息是否符合订阅条件
+// 没有指定接收用户的推送会发送给项目的所有用户,在投递前也视为匹配uid和cid
+func (f *Filter) Match(p *Point) bool {
+	msg := p.Msg
+	if f.PID != "" && msg.PID() != f.PID {
+		return false
+	}
+	if len(f.Types) > 0 && !contains(f.Types, string(msg.Type)) {
+		return false
+	}
+	if f.UID != "" && !matchID(f.UID, p.UID, msg, func(s *clustermessage.Source) string { return s.UID }, func(t *clustermessage.To) []string { return t.UIDs }) {
+		return false
+	}
+	if f.CID != "" && !matchID(f.CID, p.CID, msg, func(s *clustermessage.Source) string { return s.CID }, func(t *clustermessage.To) []string { return t.CIDs }) {
+		return false
+	}
+	return true
+}
+
+func matchID(want, target string, msg *clustermessage.AffairMsg, source func(*clustermessage.Source) string, to func(*clustermessage.To) []string) bool {
+	if target != "" {
+		return target == want
+	}
+	if msg.Source != nil && source(msg.Source) == want {
+		return true
+	}
+	if msg.To != nil {
+		if len(msg.To.UIDs) == 0 && len(msg.To.CIDs) == 0 {
+			return true
+		}
+		return contains(to(msg.To), want)
+	}
+	return false
+}
+
+// Sampled 按消息ID采样,同一条消息在所有节点和位置上的结果相同,可以看到完整的路径
+func (f *Filter) Sampled(msg *clustermessage.AffairMsg) bool {
+	if f.Sample <= 0 || f.Sample >= 1 {
+		return true
+	}
+	key := msg.AffairID
+	if msg.Header != nil && msg.Header.MsgID != "" {
+		key = msg.Header.MsgID
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(f.ID))
+	_, _ = h.Write([]byte(key))
+	return float64(h.Sum32()%10000) < f.Sample*10000
+}
+
+// NewEvent 生成消息记录,payload中名称在redactFields中的字段(不区分大小写)替换为***,超过limit字节时截断
+func NewEvent(tapID string, node int64, at int64, p *Point, redactFields []string, limit int) *Event {
+	msg := p.Msg
+	e := &Event{
+		TapID:     tapID,
+		Stage:     p.Stage,
+		Source:    p.Source,
+		Node:      node,
+		At:        at,
+		AffairID:  msg.AffairID,
+		Type:      string(msg.Type),
+		PID:       msg.PID(),
+		TargetUID: p.UID,
+		TargetCID: p.CID,
+	}
+	if msg.Header != nil {
+		e.MsgID = msg.Header.MsgID
+		e.IngestNode = msg.Header.Node
+		if msg.Header.IngestAt > 0 {
+			e.SinceIngestMs = float64(at-msg.Header.IngestAt) / 1000.0
+		}
+	}
+	if msg.Source != nil {
+		e.FromUID, e.FromCID = msg.Source.UID, msg.Source.CID
+	}
+	if msg.To != nil {
+		e.ToUIDs, e.ToCIDs = len(msg.To.UIDs), len(msg.To.CIDs)
+	}
+	e.Payload, e.PayloadSize = Redact(msg.Payload, redactFields, limit)
+	return e
+}
+
+// Redact 返回脱敏并截断后的payload和原始大小
+func Redact(payload interface{}, fields []string, limit int) (string, int) {
+	if payload == nil {
+		return "", 0
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", 0
+	}
+	size := len(raw)
+	if len(fields) > 0 {
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err == nil {
+			if b, err := json.Marshal(redactValue(v, fields)); err == nil {
+				raw = b
+			}
+		}
+	}
+	if limit > 0 && len(raw) > limit {
+		return string(raw[:limit]) + "...", size
+	}
+	return string(raw), size
+}
+
+func redactValue(v interface{}, fields []string) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if containsFold(fields, k) {
+				value[k] = redacted
+				continue
+			}
+			value[k] = redactValue(item, fields)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(item, fields)
+		}
+		return value
+	case string:
+		// 推送内容常为JSON字符串,解析后同样脱敏
+		if len(value) > 1 && (value[0] == '{' || value[0] == '[') {
+			var inner interface{}
+			decoder := json.NewDecoder(strings.NewReader(value))
+			decoder.UseNumber()
+			if err := decoder.Decode(&inner); err == nil {
+				if b, err := json.Marshal(redactValue(inner, fields)); err == nil {
+					return string(b)
+				}
+			}
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
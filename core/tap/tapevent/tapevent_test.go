@@ -0,0 +1,104 @@
+package tapevent
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+)
+
+func TestFilterMatch(t *testing.T) {
+	request := &clustermessage.AffairMsg{Type: clustermessage.TypeRequest, Source: &clustermessage.Source{PID: "77", UID: "u1", CID: "c1"}}
+	pushToUser := &clustermessage.AffairMsg{Type: clustermessage.TypePush, To: &clustermessage.To{PID: "77", UIDs: []string{"u2", "u1"}}}
+	pushToProject := &clustermessage.AffairMsg{Type: clustermessage.TypePush, To: &clustermessage.To{PID: "77"}}
+
+	cases := []struct {
+		name   string
+		filter Filter
+		point  Point
+		want   bool
+	}{
+		{"pid", Filter{PID: "77"}, Point{Msg: request}, true},
+		{"other pid", Filter{PID: "88"}, Point{Msg: pushToUser}, false},
+		{"type", Filter{Types: []string{"push"}}, Point{Msg: request}, false},
+		{"source uid", Filter{UID: "u1"}, Point{Msg: request}, true},
+		{"source cid", Filter{CID: "c2"}, Point{Msg: request}, false},
+		{"to uids", Filter{PID: "77", UID: "u1", Types: []string{"push"}}, Point{Msg: pushToUser}, true},
+		{"not in to uids", Filter{UID: "u3"}, Point{Msg: pushToUser}, false},
+		{"project push before deliver", Filter{UID: "u3"}, Point{Msg: pushToProject}, true},
+		{"project push delivered to uid", Filter{UID: "u3"}, Point{Stage: StageDeliver, Msg: pushToProject, UID: "u3", CID: "c3"}, true},
+		{"project push delivered to other uid", Filter{UID: "u3"}, Point{Stage: StageDeliver, Msg: pushToProject, UID: "u4", CID: "c4"}, false},
+		{"deliver cid", Filter{CID: "c3"}, Point{Stage: StageDeliver, Msg: pushToUser, UID: "u1", CID: "c3"}, true},
+	}
+	for _, c := range cases {
+		if got := c.filter.Match(&c.point); got != c.want {
+			t.Errorf("%s: match = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFilterSampled(t *testing.T) {
+	f := &Filter{ID: "tap1", Sample: 0.3}
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		msg := &clustermessage.AffairMsg{Header: &clustermessage.Header{MsgID: strconv.Itoa(i)}}
+		first := f.Sampled(msg)
+		// 同一条消息的结果相同
+		if f.Sampled(msg) != first {
+			t.Fatal("sample should be stable for the same message")
+		}
+		if first {
+			sampled++
+		}
+	}
+	if sampled < 2700 || sampled > 3300 {
+		t.Fatalf("sampled = %d, want about 3000", sampled)
+	}
+	if !(&Filter{}).Sampled(&clustermessage.AffairMsg{}) {
+		t.Fatal("sample 0 should keep all messages")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	payload := map[string]interface{}{
+		"Token":  "abc",
+		"amount": 12345678901234567,
+		"list":   []interface{}{map[string]interface{}{"password": "p"}},
+		"data":   `{"phone":"13800000000","name":"n"}`,
+	}
+	got, size := Redact(payload, []string{"token", "password", "phone"}, 0)
+	for _, secret := range []string{"abc", `"p"`, "13800000000"} {
+		if strings.Contains(got, secret) {
+			t.Fatalf("%s should be redacted: %s", secret, got)
+		}
+	}
+	if !strings.Contains(got, "12345678901234567") || !strings.Contains(got, `\"name\":\"n\"`) {
+		t.Fatalf("other fields should be kept: %s", got)
+	}
+	if size == 0 {
+		t.Fatal("size should be the original size")
+	}
+
+	got, size = Redact(strings.Repeat("a", 100), nil, 10)
+	if got != `"aaaaaaaaa...` || size != 102 {
+		t.Fatalf("truncated = %q, size = %d", got, size)
+	}
+	if got, size := Redact(nil, nil, 10); got != "" || size != 0 {
+		t.Fatalf("nil payload = %q, %d", got, size)
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	msg := &clustermessage.AffairMsg{
+		AffairID: "a1",
+		Type:     clustermessage.TypePush,
+		To:       &clustermessage.To{PID: "77", UIDs: []string{"u1"}},
+		Header:   &clustermessage.Header{MsgID: "m1", IngestAt: 1_000_000, Node: 2},
+		Payload:  "hello",
+	}
+	e := NewEvent("tap1", 3, 1_002_500, &Point{Stage: StageDeliver, Source: "redis:t", Msg: msg, UID: "u1", CID: "c1"}, nil, 0)
+	if e.SinceIngestMs != 2.5 || e.MsgID != "m1" || e.IngestNode != 2 || e.PID != "77" || e.ToUIDs != 1 || e.TargetCID != "c1" || e.Payload != `"hello"` {
+		t.Fatalf("event = %+v", e)
+	}
+}
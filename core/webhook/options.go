@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Options struct {
+	Ctx        context.Context
+	Config     config.Config
+	Logger     logger.Logger
+	Redis      redis.UniversalClient
+	Prometheus *wsprometheus.Prometheus
+	HttpClient *http.Client
+}
+
+func NewOptions(opts ...Option) *Options {
+	opt := &Options{
+		Ctx:        context.Background(),
+		Config:     config.DefaultConfig,
+		Logger:     logger.DefaultLogger,
+		Redis:      shared.GetRedis(),
+		Prometheus: wsprometheus.DefaultPrometheus,
+		HttpClient: &http.Client{},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return opt
+}
+
+type Option func(*Options)
+
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Ctx = ctx
+	}
+}
+
+func WithConfig(c config.Config) Option {
+	return func(o *Options) {
+		o.Config = c
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+func WithRedis(redis redis.UniversalClient) Option {
+	return func(o *Options) {
+		o.Redis = redis
+	}
+}
+
+func WithHttpClient(client *http.Client) Option {
+	return func(o *Options) {
+		o.HttpClient = client
+	}
+}
@@ -0,0 +1,240 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/shared/kit/retry"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/redis/go-redis/v9"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	HeaderEvent     = "X-Ws-Event"     // 事件类型
+	HeaderDelivery  = "X-Ws-Delivery"  // 事件唯一ID,重试时不变,业务系统可用于去重
+	HeaderTimestamp = "X-Ws-Timestamp" // 签名时间戳,单位秒
+	HeaderSignature = "X-Ws-Signature" // 签名,参见 Sign
+
+	deadLetterKey = "ws_webhook_dead_letter" // 投递失败的事件 stream
+)
+
+const (
+	resultSuccess = "success"
+	resultDead    = "dead"
+	resultDropped = "dropped"
+)
+
+var DefaultWebhook = NewWebhook()
+
+// Event 投递给业务系统的事件内容
+type Event struct {
+	ID        string              `json:"id"`
+	Event     clustermessage.Type `json:"event"` // connect, disconnect, request
+	PID       string              `json:"pid"`
+	UID       string              `json:"uid"`
+	CID       string              `json:"cid"`
+	Node      int64               `json:"node"`      // 产生事件的节点
+	Timestamp int64               `json:"timestamp"` // 事件产生时间,单位毫秒
+	AffairID  string              `json:"affair_id,omitempty"`
+	Payload   interface{}         `json:"payload,omitempty"`
+}
+
+type delivery struct {
+	project config.WebhookProject
+	event   *Event
+	body    []byte
+}
+
+// Webhook 将用户的连接、断开、请求事件投递到业务系统配置的地址
+// 事件在产生事件的节点投递,因此每个事件只会被投递一次
+type Webhook struct {
+	opts          *Options
+	projects      map[string]config.WebhookProject // key:pid
+	ch            chan *delivery
+	metricLabels  []string
+	lastDropLogAt atomic.Int64
+}
+
+func NewWebhook(opts ...Option) *Webhook {
+	options := NewOptions(opts...)
+	c := options.Config.Values().Webhook
+	w := &Webhook{
+		opts:         options,
+		projects:     make(map[string]config.WebhookProject),
+		metricLabels: []string{strconv.FormatInt(shared.GetNodeID(), 10), shared.GetInternalIP()},
+	}
+	if !c.Enable {
+		return w
+	}
+	for _, project := range c.Projects {
+		if project.PID == "" || project.URL == "" {
+			continue
+		}
+		w.projects[project.PID] = project
+	}
+	w.ch = make(chan *delivery, kit.IfElse(c.QueueSize > 0, c.QueueSize, 10000))
+	for i := 0; i < kit.IfElse(c.Workers > 0, c.Workers, 8); i++ {
+		go w.worker(options.Ctx)
+	}
+	return w
+}
+
+// Notify 如果消息所属的项目配置了webhook并订阅了该事件,则异步投递,本地缓冲区满时丢弃
+func (w *Webhook) Notify(ctx context.Context, msg *clustermessage.AffairMsg) {
+	if w.ch == nil || msg.Source == nil {
+		return
+	}
+	project, ok := w.projects[msg.Source.PID]
+	if !ok || !subscribed(project, msg.Type) {
+		return
+	}
+	event := &Event{
+		ID:        shared.GetSnowflakeNode().Generate().String(),
+		Event:     msg.Type,
+		PID:       msg.Source.PID,
+		UID:       msg.Source.UID,
+		CID:       msg.Source.CID,
+		Node:      shared.GetNodeID(),
+		Timestamp: time.Now().UnixMilli(),
+		AffairID:  msg.AffairID,
+		Payload:   msg.Payload,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		w.opts.Logger.Warnf(ctx, "Webhook marshal event error:%v", err)
+		return
+	}
+	select {
+	case w.ch <- &delivery{project: project, event: event, body: body}:
+	default:
+		_ = w.opts.Prometheus.GetAdd(wsprometheus.MetricWebhookDelivery, append(w.metricLabels, string(msg.Type), resultDropped), 1)
+		if kit.AllowByInterval(&w.lastDropLogAt, 2*time.Second) {
+			w.opts.Logger.Warnf(ctx, "Webhook queue full,dropped,pid=%s,event=%s,len=%d", event.PID, event.Event, len(w.ch))
+		}
+	}
+}
+
+func (w *Webhook) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-w.ch:
+			w.deliver(ctx, d)
+		}
+	}
+}
+
+// deliver 按照退避策略重试投递,全部失败后写入死信
+func (w *Webhook) deliver(ctx context.Context, d *delivery) {
+	var (
+		c       = w.opts.Config.Values().Webhook
+		lastErr error
+		labels  = append(w.metricLabels, string(d.event.Event))
+	)
+	_, times := retry.RetryN(c.MaxRetries, func(ctx context.Context) (interface{}, error) {
+		lastErr = w.post(ctx, d)
+		return nil, lastErr
+	}, retry.WithContext(ctx), retry.WithBackoff(retry.NewBackoff(retry.WithJitter(true))))
+
+	if lastErr == nil {
+		_ = w.opts.Prometheus.GetAdd(wsprometheus.MetricWebhookDelivery, append(labels, resultSuccess), 1)
+		return
+	}
+	_ = w.opts.Prometheus.GetAdd(wsprometheus.MetricWebhookDelivery, append(labels, resultDead), 1)
+	w.opts.Logger.Warnf(ctx, "Webhook deliver failed after %d times,pid=%s,event=%s,id=%s,err:%v", times, d.event.PID, d.event.Event, d.event.ID, lastErr)
+	// 单节点部署没有redis,只记录日志
+	if w.opts.Config.Values().Standalone() {
+		w.opts.Logger.Warnf(ctx, "Webhook dead letter body:%s", kit.LogSnippet(d.body, 240))
+		return
+	}
+
+	err := w.opts.Redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: deadLetterKey,
+		MaxLen: kit.IfElse(c.DeadLetterMaxLen > 0, c.DeadLetterMaxLen, 10000),
+		Approx: true,
+		Values: map[string]interface{}{
+			"pid":      d.event.PID,
+			"event":    string(d.event.Event),
+			"url":      d.project.URL,
+			"body":     string(d.body),
+			"error":    lastErr.Error(),
+			"attempts": times,
+			"node":     d.event.Node,
+		},
+	}).Err()
+	if err != nil {
+		w.opts.Logger.Warnf(ctx, "Webhook write dead letter error:%v,body:%s", err, kit.LogSnippet(d.body, 240))
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, d *delivery) error {
+	timeout := time.Duration(w.opts.Config.Values().Webhook.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.project.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(d.event.Event))
+	req.Header.Set(HeaderDelivery, d.event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(d.project.Secret, timestamp, d.body))
+
+	beginTime := time.Now()
+	resp, err := w.opts.HttpClient.Do(req)
+	_ = w.opts.Prometheus.GetObserve(wsprometheus.MetricWebhookDeliveryDuration, append(w.metricLabels, string(d.event.Event)), float64(time.Since(beginTime).Microseconds())/1000.0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code:%d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign 签名为 hex(hmac_sha256(secret, timestamp + "." + body)),业务系统使用相同的方式校验
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func subscribed(project config.WebhookProject, msgType clustermessage.Type) bool {
+	if len(project.Events) == 0 {
+		return true
+	}
+	for _, event := range project.Events {
+		if event == string(msgType) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/shared"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type testConfig struct {
+	values config.Values
+}
+
+func (c *testConfig) Values() *config.Values {
+	return &c.values
+}
+
+var (
+	testPrometheusOnce sync.Once
+	testPrometheus     *wsprometheus.Prometheus
+)
+
+// enabledPrometheus 指标注册在全局的registry中,整个测试进程只能初始化一次
+func enabledPrometheus() *wsprometheus.Prometheus {
+	testPrometheusOnce.Do(func() {
+		c := &testConfig{}
+		c.values.Prometheus = config.Prometheus{Enable: true, Path: "/webhook_test_metrics", Addr: "127.0.0.1:0"}
+		testPrometheus = wsprometheus.New(wsprometheus.WithConfig(c), wsprometheus.WithManager(wsprometheus.NewManager()))
+		testPrometheus.Init()
+	})
+	return testPrometheus
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// recorder 记录收到的投递,disconnect 事件返回500
+type recorder struct {
+	mu       sync.Mutex
+	requests []received
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, received{header: req.Header.Clone(), body: body})
+	r.mu.Unlock()
+	if req.Header.Get(HeaderEvent) == string(clustermessage.TypeDisconnect) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *recorder) byEvent(event clustermessage.Type) []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []received
+	for _, req := range r.requests {
+		if req.header.Get(HeaderEvent) == string(event) {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+func deliveryCount(t *testing.T, event clustermessage.Type, result string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != wsprometheus.MetricWebhookDelivery {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			values := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				values[label.GetName()] = label.GetValue()
+			}
+			if values["event"] == string(event) && values["result"] == result {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func waitFor(t *testing.T, msg string, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	if got, want := Sign("secret", "1700000000", body), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Fatalf("sign = %s, want %s", got, want)
+	}
+	if Sign("secret", "1700000001", body) == Sign("secret", "1700000000", body) {
+		t.Fatal("timestamp should be signed")
+	}
+}
+
+// 只投递订阅的事件,签名和时间戳可以校验,5xx 重试后写入死信
+func TestWebhookDeliver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	c := &testConfig{}
+	// 非单节点部署,死信写入redis
+	c.values.Queue.Use = "redis"
+	c.values.Webhook = config.Webhook{
+		Enable:     true,
+		Workers:    1,
+		TimeoutMs:  500,
+		MaxRetries: 3,
+		Projects: []config.WebhookProject{{
+			PID:    "p1",
+			URL:    server.URL,
+			Secret: "secret",
+			Events: []string{string(clustermessage.TypeConnect), string(clustermessage.TypeDisconnect)},
+		}},
+	}
+	w := NewWebhook(WithContext(ctx), WithConfig(c), func(o *Options) { o.Prometheus = enabledPrometheus() })
+
+	redisClient := shared.GetRedis()
+	lastDead := "0"
+	if entries := redisClient.XRevRangeN(ctx, deadLetterKey, "+", "-", 1).Val(); len(entries) > 0 {
+		lastDead = entries[0].ID
+	}
+	successes := deliveryCount(t, clustermessage.TypeConnect, resultSuccess)
+	deads := deliveryCount(t, clustermessage.TypeDisconnect, resultDead)
+
+	source := &clustermessage.Source{PID: "p1", UID: "u1", CID: "c1"}
+	// request 没有订阅,不会投递
+	w.Notify(ctx, &clustermessage.AffairMsg{Type: clustermessage.TypeRequest, Source: source, AffairID: "a0"})
+	w.Notify(ctx, &clustermessage.AffairMsg{Type: clustermessage.TypeConnect, Source: source, AffairID: "a1"})
+	waitFor(t, "connect not delivered", func() bool { return len(rec.byEvent(clustermessage.TypeConnect)) == 1 })
+
+	req := rec.byEvent(clustermessage.TypeConnect)[0]
+	timestamp := req.header.Get(HeaderTimestamp)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)) > time.Minute {
+		t.Fatalf("invalid timestamp %s", timestamp)
+	}
+	if req.header.Get(HeaderSignature) != Sign("secret", timestamp, req.body) {
+		t.Fatalf("invalid signature %s", req.header.Get(HeaderSignature))
+	}
+	event := &Event{}
+	if err := json.Unmarshal(req.body, event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != req.header.Get(HeaderDelivery) || event.PID != "p1" || event.UID != "u1" || event.AffairID != "a1" {
+		t.Fatalf("event = %+v", event)
+	}
+	waitFor(t, "success metric not increased", func() bool {
+		return deliveryCount(t, clustermessage.TypeConnect, resultSuccess)-successes == 1
+	})
+
+	w.Notify(ctx, &clustermessage.AffairMsg{Type: clustermessage.TypeDisconnect, Source: source, AffairID: "a2"})
+	waitFor(t, "dead metric not increased", func() bool {
+		return deliveryCount(t, clustermessage.TypeDisconnect, resultDead)-deads == 1
+	})
+	attempts := rec.byEvent(clustermessage.TypeDisconnect)
+	if len(attempts) != 3 {
+		t.Fatalf("disconnect attempts = %d, want 3", len(attempts))
+	}
+	for _, attempt := range attempts {
+		if attempt.header.Get(HeaderDelivery) != attempts[0].header.Get(HeaderDelivery) {
+			t.Fatal("delivery id should not change when retrying")
+		}
+	}
+	if got := rec.byEvent(clustermessage.TypeRequest); len(got) != 0 {
+		t.Fatalf("unsubscribed request delivered %d times", len(got))
+	}
+
+	entries, err := redisClient.XRange(ctx, deadLetterKey, "("+lastDead, "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("dead letters = %d, want 1", len(entries))
+	}
+	values := entries[0].Values
+	if values["event"] != string(clustermessage.TypeDisconnect) || values["pid"] != "p1" || values["attempts"] != "3" || values["body"] != string(attempts[0].body) {
+		t.Fatalf("dead letter = %v", values)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/shared/auth"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+type DeadLetterList struct {
+	List []*deadletter.Entry `json:"list"`
+	Next string              `json:"next"` // 下一页的start,为空时没有更多
+}
+
+// 死信列表
+//
+//	@Summary		查看队列中无法处理的消息
+//	@Description	按时间从新到旧返回,需要管理端的token
+//	@ID				dead-letter-list
+//	@Produce		json
+//	@Param			token	query		string			true	"管理端签名"
+//	@Param			start	query		string			false	"从该ID开始(包含),为空时从最新的开始"
+//	@Param			count	query		int				false	"返回数量,默认50,最大500"
+//	@Success		200		{object}	DeadLetterList	"{"code":1,"msg":"success","payload":{"list":[],"next":""}}"
+//	@Router			/dead_letters [get]
+func (g gfServer) deadLetterListHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	entries, next, err := g.opts.deadLetter.List(r.Context(), r.Get("start").String(), r.Get("count").Int64())
+	if err != nil {
+		r.Response.WriteJson(clustermessage.NewErrorResp(err.Error()))
+		return
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(&DeadLetterList{List: entries, Next: next}))
+}
+
+// 死信详情
+//
+//	@Summary		查看一条死信
+//	@ID				dead-letter-get
+//	@Produce		json
+//	@Param			token	query		string				true	"管理端签名"
+//	@Param			id		path		string				true	"死信ID"
+//	@Success		200		{object}	deadletter.Entry	"{"code":1,"msg":"success","payload":{}}"
+//	@Router			/dead_letters/{id} [get]
+func (g gfServer) deadLetterGetHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	entry, err := g.opts.deadLetter.Get(r.Context(), r.Get("id").String())
+	if err != nil {
+		r.Response.WriteJson(clustermessage.NewErrorResp(err.Error()))
+		return
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(entry))
+}
+
+// 重新投递死信
+//
+//	@Summary		将死信重新写入队列,成功后删除死信
+//	@Description	message不为空时使用修正后的消息替换原始消息,格式与原始消息相同
+//	@ID				dead-letter-reinject
+//	@Produce		json
+//	@Param			token	query		string	true	"管理端签名"
+//	@Param			id		path		string	true	"死信ID"
+//	@Param			message	query		string	false	"修正后的消息"
+//	@Success		200		{string}	string	"{"code":1,"msg":"success"}"
+//	@Router			/dead_letters/{id}/reinject [post]
+func (g gfServer) deadLetterReinjectHandler(r *ghttp.Request) {
+	var (
+		ctx    = r.Context()
+		id     = r.Get("id").String()
+		logger = g.opts.logger
+	)
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	entry, err := g.opts.deadLetter.Get(ctx, id)
+	if err != nil {
+		r.Response.WriteJson(clustermessage.NewErrorResp(err.Error()))
+		return
+	}
+	raw := entry.Message
+	if message := r.Get("message").String(); message != "" {
+		raw = message
+	}
+	msg, err := clustermessage.ParseAffair([]byte(raw))
+	if err != nil {
+		r.Response.WriteJson(clustermessage.NewErrorResp("parse message error:" + err.Error()))
+		return
+	}
+	// 重新生成消息头,避免被当作重复消息
+	msg.Header = nil
+	queue.StampHeader(msg)
+	if err := g.opts.queue.Publish(ctx, msg); err != nil {
+		logger.Warnf(ctx, "reinject dead letter %s error:%s", id, err.Error())
+		r.Response.WriteJson(clustermessage.NewErrorResp(kit.IfElse(queue.IsPublishDropped(err), "service busy, please retry", "publish message error")))
+		return
+	}
+	if err := g.opts.deadLetter.Delete(ctx, id); err != nil {
+		logger.Warnf(ctx, "delete dead letter %s after reinject error:%s", id, err.Error())
+	}
+	logger.Infof(ctx, "reinject dead letter %s,reason:%s,type:%s", id, entry.Reason, msg.Type)
+	r.Response.WriteJson(clustermessage.NewSuccessResp())
+}
+
+// 删除死信
+//
+//	@Summary		删除一条死信
+//	@ID				dead-letter-delete
+//	@Produce		json
+//	@Param			token	query		string	true	"管理端签名"
+//	@Param			id		path		string	true	"死信ID"
+//	@Success		200		{string}	string	"{"code":1,"msg":"success"}"
+//	@Router			/dead_letters/{id} [delete]
+func (g gfServer) deadLetterDeleteHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	if err := g.opts.deadLetter.Delete(r.Context(), r.Get("id").String()); err != nil {
+		r.Response.WriteJson(clustermessage.NewErrorResp(err.Error()))
+		return
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessResp())
+}
+
+// authAdmin 校验管理端的token,返回不为空的errMsg代表校验失败
+func authAdmin(token string) (errMsg string) {
+	userData, err := auth.Decode(token)
+	if err != nil {
+		return "token error"
+	}
+	if userData.ClientType != int(client.CTypeAdmin) {
+		return "permission denied"
+	}
+	return ""
+}
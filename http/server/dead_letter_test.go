@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/core/queue/handler"
+	"github.com/mtgnorton/ws-cluster/core/queue/option"
+	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/shared/auth"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+type testConfig struct {
+	values config.Values
+}
+
+func (c *testConfig) Values() *config.Values {
+	return &c.values
+}
+
+type publishedHandler chan *clustermessage.AffairMsg
+
+func (h publishedHandler) Handle(_ context.Context, msg *clustermessage.AffairMsg) bool {
+	h <- msg
+	return true
+}
+
+type deadLetterResp struct {
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+	Payload struct {
+		deadletter.Entry
+		List []*deadletter.Entry `json:"list"`
+		Next string              `json:"next"`
+	} `json:"payload"`
+}
+
+// newDeadLetterServer 启动只注册死信接口的服务,重新投递的消息写入published
+func newDeadLetterServer(t *testing.T, d *deadletter.DeadLetter) (baseURL string, published publishedHandler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	published = make(publishedHandler, 10)
+	s := gfServer{
+		opts: Options{
+			ctx:        ctx,
+			logger:     logger.DefaultLogger,
+			deadLetter: d,
+			queue: queue.NewMemoryQueue(option.WithContext(ctx), func(o *option.Options) {
+				o.Handlers = map[clustermessage.Type]handler.Handle{clustermessage.TypePush: published}
+			}),
+		},
+		// g.Server 按名称返回同一个实例,每次使用新的名称
+		server: g.Server(fmt.Sprintf("dead_letter_test_%d", time.Now().UnixNano())),
+	}
+	s.server.BindHandler("GET:/dead_letters", s.deadLetterListHandler)
+	s.server.BindHandler("GET:/dead_letters/{id}", s.deadLetterGetHandler)
+	s.server.BindHandler("DELETE:/dead_letters/{id}", s.deadLetterDeleteHandler)
+	s.server.BindHandler("POST:/dead_letters/{id}/reinject", s.deadLetterReinjectHandler)
+	s.server.SetAddr("127.0.0.1:0")
+	s.server.SetDumpRouterMap(false)
+	if err := s.server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.server.Shutdown() })
+	return fmt.Sprintf("http://127.0.0.1:%d", s.server.GetListenedPort()), published
+}
+
+func call(t *testing.T, method, u string, query url.Values) *deadLetterResp {
+	t.Helper()
+	req, err := http.NewRequest(method, u+"?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	result := &deadLetterResp{}
+	if err := json.Unmarshal(body, result); err != nil {
+		t.Fatalf("parse %s error:%v", body, err)
+	}
+	return result
+}
+
+// listSource 通过列表接口逐页查找指定来源的死信,找到want条后返回
+func listSource(t *testing.T, baseURL, token, source string, want int) []*deadletter.Entry {
+	t.Helper()
+	var (
+		result []*deadletter.Entry
+		query  = url.Values{"token": {token}, "count": {"1"}}
+	)
+	for {
+		resp := call(t, http.MethodGet, baseURL+"/dead_letters", query)
+		if resp.Code != 1 || len(resp.Payload.List) > 1 {
+			t.Fatalf("list = %+v", resp)
+		}
+		for _, e := range resp.Payload.List {
+			if e.Source == source {
+				result = append(result, e)
+			}
+		}
+		if len(result) == want {
+			return result
+		}
+		if resp.Payload.Next == "" {
+			t.Fatalf("found %d entries of %s, want %d", len(result), source, want)
+		}
+		query.Set("start", resp.Payload.Next)
+	}
+}
+
+func TestDeadLetterHandlers(t *testing.T) {
+	c := &testConfig{}
+	c.values.Queue.Use = "redis"
+	c.values.Queue.DeadLetter = config.DeadLetter{Enable: true}
+	d := deadletter.NewDeadLetter(deadletter.WithConfig(c))
+	baseURL, published := newDeadLetterServer(t, d)
+	ctx := context.Background()
+	source := "test:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	// 原始消息带有旧的消息头,重新投递时需要重新生成
+	original := &clustermessage.AffairMsg{AffairID: "a1", Type: clustermessage.TypePush, To: &clustermessage.To{PID: "p1"}, Header: &clustermessage.Header{MsgID: "old", Node: 999}}
+	raw, err := clustermessage.PackAffair(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Add(ctx, &deadletter.Entry{Reason: deadletter.ReasonPanic, Error: "boom", Source: source, SourceID: "1", Message: string(raw)})
+	d.Add(ctx, &deadletter.Entry{Reason: deadletter.ReasonPanic, Error: "boom", Source: source, SourceID: "2", Message: string(raw)})
+
+	admin := url.Values{"token": {auth.MustEncode(&auth.UserData{PID: "p1", UID: "admin", ClientType: int(client.CTypeAdmin)})}}
+	user := url.Values{"token": {auth.MustEncode(&auth.UserData{PID: "p1", UID: "u1", ClientType: int(client.CTypeUser)})}}
+	if resp := call(t, http.MethodGet, baseURL+"/dead_letters", user); resp.Code != 0 || resp.Msg != "permission denied" {
+		t.Fatalf("user token should be rejected, got %+v", resp)
+	}
+
+	// 每页1条,翻页读取本测试写入的死信,从新到旧排列
+	entries := listSource(t, baseURL, admin.Get("token"), source, 2)
+	newest, oldest := entries[0], entries[1]
+	if newest.SourceID != "2" || oldest.SourceID != "1" {
+		t.Fatalf("entries = %+v,%+v", newest, oldest)
+	}
+
+	resp := call(t, http.MethodGet, baseURL+"/dead_letters/"+oldest.ID, admin)
+	if resp.Code != 1 || resp.Payload.ID != oldest.ID || resp.Payload.Message != string(raw) {
+		t.Fatalf("get = %+v", resp)
+	}
+
+	// 重新投递后重新生成消息头并删除死信
+	resp = call(t, http.MethodPost, baseURL+"/dead_letters/"+oldest.ID+"/reinject", admin)
+	if resp.Code != 1 {
+		t.Fatalf("reinject = %+v", resp)
+	}
+	select {
+	case msg := <-published:
+		if msg.AffairID != "a1" || msg.Header == nil || msg.Header.MsgID == "" || msg.Header.MsgID == "old" || msg.Header.Node == 999 {
+			t.Fatalf("reinjected msg = %+v,header = %+v", msg, msg.Header)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reinjected message not published")
+	}
+	if resp = call(t, http.MethodGet, baseURL+"/dead_letters/"+oldest.ID, admin); resp.Code != 0 || resp.Msg != deadletter.ErrNotFound.Error() {
+		t.Fatalf("reinjected entry should be deleted, got %+v", resp)
+	}
+
+	// 使用修正后的消息重新投递
+	fixed := url.Values{"token": admin["token"], "message": {`{"affair_id":"a2","type":"push","to":{"pid":"p1"}}`}}
+	if resp = call(t, http.MethodPost, baseURL+"/dead_letters/"+newest.ID+"/reinject", fixed); resp.Code != 1 {
+		t.Fatalf("reinject fixed = %+v", resp)
+	}
+	select {
+	case msg := <-published:
+		if msg.AffairID != "a2" {
+			t.Fatalf("reinjected fixed msg = %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fixed message not published")
+	}
+
+	d.Add(ctx, &deadletter.Entry{Reason: deadletter.ReasonDecode, Error: "bad", Source: source, SourceID: "3", Message: "bad"})
+	entries = listSource(t, baseURL, admin.Get("token"), source, 1)
+	if resp = call(t, http.MethodDelete, baseURL+"/dead_letters/"+entries[0].ID, admin); resp.Code != 1 {
+		t.Fatalf("delete = %+v", resp)
+	}
+	if _, err := d.Get(ctx, entries[0].ID); !errors.Is(err, deadletter.ErrNotFound) {
+		t.Fatalf("deleted entry error = %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/core/queue/slowlog"
+	"github.com/mtgnorton/ws-cluster/shared"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+const (
+	defaultDebugTop = 20
+	maxDebugTop     = 500
+)
+
+// debugOverview 本节点的运行状态
+type debugOverview struct {
+	Node           int64             `json:"node"`
+	IP             string            `json:"ip"`
+	Goroutines     int               `json:"goroutines"`
+	Queue          *queue.Inspection `json:"queue,omitempty"`
+	RedisPool      *queue.PoolStats  `json:"redis_pool,omitempty"` // 非队列使用的redis连接池,单节点部署时为空
+	Clients        []debugClient     `json:"clients"`
+	SlowDispatches []slowlog.Entry   `json:"slow_dispatches"`
+}
+
+// debugClient 客户端的发送队列状态
+type debugClient struct {
+	CID         string    `json:"cid"`
+	UID         string    `json:"uid,omitempty"`
+	PID         string    `json:"pid"`
+	Type        string    `json:"type"`
+	QueueLen    int       `json:"queue_len"`
+	QueueCap    int       `json:"queue_cap"`
+	Drops       int64     `json:"drops"`
+	MsgsOut     int64     `json:"msgs_out"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// 运行状态
+//
+//	@Summary		查看本节点的运行状态
+//	@Description	返回本节点的协程数,队列的本地发布缓冲区,stream长度和消费位置,redis连接池,发送队列最长的客户端和最近较慢的消息分发
+//	@Description	只包含本节点的数据,需要分别请求每个节点
+//	@ID				debug-overview
+//	@Produce		json
+//	@Param			token	query		string	true	"管理端签名"
+//	@Param			top		query		int		false	"客户端和较慢分发返回的数量,默认20,最大500"
+//	@Param			sort	query		string	false	"客户端的排序方式,queue(默认)按发送队列长度,drops按丢弃的消息数量"
+//	@Success		200		{object}	debugOverview
+//	@Router			/debug/ws-cluster [get]
+func (g gfServer) debugHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	top := debugTop(r)
+	overview := debugOverview{
+		Node:           shared.GetNodeID(),
+		IP:             shared.GetInternalIP(),
+		Goroutines:     runtime.NumGoroutine(),
+		Queue:          g.inspectQueue(r.Context()),
+		Clients:        g.topClients(r.Context(), top, r.Get("sort").String()),
+		SlowDispatches: g.slowDispatches(top),
+	}
+	if !g.opts.config.Values().Standalone() {
+		overview.RedisPool = queue.NewPoolStats(shared.GetRedis())
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(overview))
+}
+
+// 队列状态
+//
+//	@Summary		查看本节点的队列状态
+//	@Description	本地发布缓冲区的使用率,redis队列每个分片的stream长度,最后一条消息的ID和本节点最后消费的消息ID,以及连接池状态
+//	@ID				debug-queue
+//	@Produce		json
+//	@Param			token	query		string	true	"管理端签名"
+//	@Success		200		{object}	queue.Inspection
+//	@Router			/debug/ws-cluster/queue [get]
+func (g gfServer) debugQueueHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	inspection := g.inspectQueue(r.Context())
+	if inspection == nil {
+		r.Response.WriteJson(clustermessage.NewErrorResp("queue does not support inspect"))
+		return
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(inspection))
+}
+
+// 客户端发送队列
+//
+//	@Summary		查看本节点发送队列最长或丢弃消息最多的客户端
+//	@ID				debug-clients
+//	@Produce		json
+//	@Param			token	query		string	true	"管理端签名"
+//	@Param			top		query		int		false	"返回的数量,默认20,最大500"
+//	@Param			sort	query		string	false	"queue(默认)按发送队列长度,drops按丢弃的消息数量"
+//	@Success		200		{array}		debugClient
+//	@Router			/debug/ws-cluster/clients [get]
+func (g gfServer) debugClientsHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(g.topClients(r.Context(), debugTop(r), r.Get("sort").String())))
+}
+
+// 较慢的消息分发
+//
+//	@Summary		查看本节点最近较慢的消息分发
+//	@Description	记录单条消息分发超过50ms的最近200次,按耗时从高到低返回
+//	@ID				debug-slow-dispatches
+//	@Produce		json
+//	@Param			token	query		string	true	"管理端签名"
+//	@Param			top		query		int		false	"返回的数量,默认20,最大500"
+//	@Success		200		{array}		slowlog.Entry
+//	@Router			/debug/ws-cluster/slow [get]
+func (g gfServer) debugSlowHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(g.slowDispatches(debugTop(r))))
+}
+
+func debugTop(r *ghttp.Request) int {
+	top := r.Get("top").Int()
+	if top <= 0 {
+		return defaultDebugTop
+	}
+	return min(top, maxDebugTop)
+}
+
+func (g gfServer) inspectQueue(ctx context.Context) *queue.Inspection {
+	inspector, ok := g.opts.queue.(queue.Inspector)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	inspection := inspector.Inspect(ctx)
+	return &inspection
+}
+
+func (g gfServer) slowDispatches(top int) []slowlog.Entry {
+	ring := g.opts.queue.Options().SlowDispatches
+	if ring == nil {
+		return []slowlog.Entry{}
+	}
+	return ring.Slowest(top)
+}
+
+// topClients 本节点的服务端和用户端中发送队列最长或丢弃消息最多的客户端,发送队列为空且没有丢弃消息的客户端不返回
+func (g gfServer) topClients(ctx context.Context, top int, sortBy string) []debugClient {
+	clients := make([]debugClient, 0)
+	add := func(c client.Client) {
+		stats := c.Stats()
+		if stats.QueueLen == 0 && stats.Drops == 0 {
+			return
+		}
+		cid, uid, pid := c.GetIDs()
+		clients = append(clients, debugClient{
+			CID:         cid,
+			UID:         uid,
+			PID:         pid,
+			Type:        c.Type().String(),
+			QueueLen:    stats.QueueLen,
+			QueueCap:    stats.QueueCap,
+			Drops:       stats.Drops,
+			MsgsOut:     stats.MsgsOut,
+			ConnectedAt: stats.ConnectedAt,
+		})
+	}
+	for _, project := range g.opts.manager.Projects(ctx) {
+		for _, c := range project.Servers {
+			add(c)
+		}
+		for _, c := range project.Clients {
+			add(c)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if sortBy == "drops" && clients[i].Drops != clients[j].Drops {
+			return clients[i].Drops > clients[j].Drops
+		}
+		if clients[i].QueueLen != clients[j].QueueLen {
+			return clients[i].QueueLen > clients[j].QueueLen
+		}
+		return clients[i].Drops > clients[j].Drops
+	})
+	if len(clients) > top {
+		clients = clients[:top]
+	}
+	return clients
+}
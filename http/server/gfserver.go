@@ -2,7 +2,8 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -11,16 +12,27 @@ import (
 
 	"github.com/mtgnorton/ws-cluster/shared"
 	"github.com/mtgnorton/ws-cluster/shared/auth"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
 
 	"github.com/mtgnorton/ws-cluster/clustermessage"
 	"github.com/mtgnorton/ws-cluster/core/checking"
 	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/core/tap"
+	"github.com/mtgnorton/ws-cluster/core/tap/tapevent"
 
 	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
 	"github.com/mtgnorton/ws-cluster/tools/wssentry"
 
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultPushWait    = time.Second     // 同步推送默认等待回执时间
+	maxPushWait        = 5 * time.Second // 同步推送最大等待回执时间
+	defaultMaxBodySize = 8               // 默认请求体最大大小,单位MB
 )
 
 type gfServer struct {
@@ -78,21 +90,96 @@ func (g gfServer) Run() {
 			}
 		})
 
-		group.GET("/reset_metrics", func(r *ghttp.Request) {
-			nodeID := shared.GetNodeID()
-			serverIP := shared.GetInternalIP()
-			p := g.opts.prometheus.Get(wsprometheus.MetricWsConnection)
-			p.Reset([]string{fmt.Sprintf("%d", nodeID), serverIP})
+		group.POST("/push/stream", func(r *ghttp.Request) {
+			ctx := r.Context()
+			beginTime := time.Now()
+
+			g.sentry.RecoverHttp(r, g.streamHandler)
 
-			r.Response.WriteJson(clustermessage.NewSuccessResp(fmt.Sprintf("%d,%s reset success", nodeID, serverIP)))
+			p := g.opts.prometheus
+			err := p.GetAdd(wsprometheus.MetricRequestTotal, nil, 1)
+			if err != nil {
+				g.opts.logger.Infof(ctx, "add metric error:%s", err.Error())
+			}
+			err = p.GetAdd(wsprometheus.MetricRequestURLTotal, []string{"http_stream", strconv.Itoa(r.Response.Status)}, 1)
+			if err != nil {
+				g.opts.logger.Infof(ctx, "add metric error:%s", err.Error())
+			}
+			err = p.GetObserve(wsprometheus.MetricRequestDuration, []string{"http_stream"}, time.Since(beginTime).Seconds())
+			if err != nil {
+				g.opts.logger.Infof(ctx, "add metric error:%s", err.Error())
+			}
+		})
+
+		group.GET("/dead_letters", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.deadLetterListHandler)
+		})
+		group.GET("/dead_letters/{id}", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.deadLetterGetHandler)
+		})
+		group.DELETE("/dead_letters/{id}", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.deadLetterDeleteHandler)
+		})
+		group.POST("/dead_letters/{id}/reinject", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.deadLetterReinjectHandler)
+		})
+		group.ALL("/queue/dual", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.queueDualHandler)
+		})
+		group.POST("/clients/kick", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.kickHandler)
+		})
+		group.GET("/log/level", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.logLevelHandler)
+		})
+		group.POST("/log/level", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.logLevelHandler)
+		})
+	})
+	g.server.Group("/debug/ws-cluster", func(group *ghttp.RouterGroup) {
+		group.Middleware(g.sentry.MiddleWare)
+		group.GET("/", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.debugHandler)
+		})
+		group.GET("/queue", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.debugQueueHandler)
+		})
+		group.GET("/clients", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.debugClientsHandler)
+		})
+		group.GET("/slow", func(r *ghttp.Request) {
+			g.sentry.RecoverHttp(r, g.debugSlowHandler)
 		})
 	})
 
 	g.opts.logger.Infof(context.Background(), "http server run on port:%d", g.opts.port)
+	// 请求体大小由 serveHTTP 按接口限制
+	g.server.SetClientMaxBodySize(0)
+	g.server.SetHandler(g.serveHTTP)
 	g.server.SetPort(g.opts.port)
 	g.server.Run()
 }
 
+// serveHTTP 按接口限制请求体的大小,流式推送接口边读请求体边输出结果,需要更大的请求体和更长的读取时间
+func (g gfServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	httpConfig := g.opts.config.Values().HttpServer
+	maxBodySize := kit.IfElse(httpConfig.MaxBodySize > 0, httpConfig.MaxBodySize, defaultMaxBodySize)
+	if r.URL.Path == streamPushPath {
+		maxBodySize = kit.IfElse(httpConfig.StreamMaxBodySize > 0, httpConfig.StreamMaxBodySize, defaultStreamMaxBodySize)
+		readTimeout := kit.IfElse(httpConfig.StreamReadTimeout > 0, time.Duration(httpConfig.StreamReadTimeout)*time.Second, defaultStreamReadTimeout)
+		rc := http.NewResponseController(w)
+		// HTTP/1.1 默认在第一次写响应时关闭未读完的请求体,HTTP/2本身支持同时读写,会返回不支持的错误
+		if err := rc.EnableFullDuplex(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			g.opts.logger.Warnf(r.Context(), "push stream enable full duplex error:%v", err)
+		}
+		if err := rc.SetReadDeadline(time.Now().Add(readTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			g.opts.logger.Warnf(r.Context(), "push stream set read deadline error:%v", err)
+		}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBodySize)*1024*1024)
+	g.server.ServeHTTP(w, r)
+}
+
 func (g gfServer) Stop() error {
 	return g.server.Shutdown()
 }
@@ -101,6 +188,7 @@ func (g gfServer) Stop() error {
 //
 //	@Summary		业务系统通过该接口推送消息
 //	@Description	业务系统通过该接口推送消息,当同时传递了uids和cids时，会求并集
+//	@Description	sync为true时,会等待各节点的投递回执,返回每个uid投递成功的cids以及不在线的uids,最多等待wait_ms毫秒
 //	@ID				push-message
 //	@Accept			json
 //	@Produce		json
@@ -108,8 +196,11 @@ func (g gfServer) Stop() error {
 //	@Param			cids	query		string		false	"客户端id,多个客户端id以逗号隔开"
 //	@Param			token	query		string		true	"签名"
 //	@Param			data	query		string		true	"推送的消息内容,建议为json"
+//	@Param			sync	query		bool		false	"是否同步等待投递结果,默认false"
+//	@Param			wait_ms	query		int			false	"同步等待回执的毫秒数,默认1000,最大5000"
 //	@Success		200		{string}	string		"{"code":1,"msg":"success","payload":{}}"
 //	@Failure		200		{object}	message.Res	"code=0,msg=error"
+//	@Failure		503		{object}	message.Res	"code=0,msg=service busy, please retry,队列繁忙时返回,可以稍后重试"
 //	@Router			/push [post]
 func (g gfServer) handler(r *ghttp.Request) {
 	var (
@@ -117,22 +208,20 @@ func (g gfServer) handler(r *ghttp.Request) {
 		uidStr = r.Get("uids").String()
 		cidStr = r.Get("cids").String()
 		data   = r.Get("data").String()
+		sync   = r.Get("sync").Bool()
+		waitMs = r.Get("wait_ms").Int64()
 		uids   []string
 		cids   []string
 	)
-	userData, err := auth.Decode(token)
-	if err != nil {
-		r.Response.WriteJson(clustermessage.NewErrorResp("token error"))
-		return
-	}
-	if !checking.DefaultChecking.IsExist(userData.PID) {
-		r.Response.WriteJson(clustermessage.NewErrorResp("PID denied"))
-		return
-	}
-	if userData.ClientType == int(client.CTypeUser) {
-		r.Response.WriteJson(clustermessage.NewErrorResp("permission denied"))
+	// 业务服务端可以通过 traceparent 请求头传入自己的链路
+	ctx, span := g.opts.tracer.Start(g.opts.tracer.ExtractHTTP(r.Context(), r.Header), "http.push")
+	defer span.End()
+	userData, errMsg := authPusher(token)
+	if errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
 		return
 	}
+	span.SetAttributes(attribute.String("pid", userData.PID))
 	for _, uid := range strings.Split(uidStr, ",") {
 		uid = strings.TrimSpace(uid)
 		if len(uid) > 0 {
@@ -161,12 +250,66 @@ func (g gfServer) handler(r *ghttp.Request) {
 	// time.Sleep(time.Duration(rand.Intn(10)) * time.Second)
 
 	msg.Type = clustermessage.TypePush
+	if sync {
+		msg.ReceiptID = shared.GetSnowflakeNode().Generate().String()
+	}
+	queue.StampHeader(msg)
+	g.opts.tap.Observe(ctx, tap.Point{Stage: tapevent.StageIngest, Source: "http", Msg: msg})
 
-	err = g.opts.queue.Publish(r.Context(), msg)
+	err := g.opts.queue.Publish(ctx, msg)
 	if err != nil {
-		g.opts.logger.Warnf(r.Context(), "publish message error:%s", err.Error())
+		g.opts.logger.Warnf(ctx, "publish message error:%s", err.Error())
+		if queue.IsPublishDropped(err) {
+			// 队列繁忙,调用方可以稍后重试
+			r.Response.Header().Set("Retry-After", "1")
+			r.Response.WriteHeader(http.StatusServiceUnavailable)
+			r.Response.WriteJson(clustermessage.NewErrorResp("service busy, please retry"))
+			return
+		}
 		r.Response.WriteJson(clustermessage.NewErrorResp("publish message error"))
 		return
 	}
-	r.Response.WriteJson(clustermessage.NewSuccessResp())
+	g.addProjectPush(userData.PID, "http", len(data))
+	if !sync {
+		r.Response.WriteJson(clustermessage.NewSuccessResp())
+		return
+	}
+
+	wait := defaultPushWait
+	if waitMs > 0 {
+		wait = time.Duration(waitMs) * time.Millisecond
+	}
+	if wait > maxPushWait {
+		wait = maxPushWait
+	}
+	result, err := g.opts.cluster.WaitReceipts(r.Context(), msg.ReceiptID, uids, wait)
+	if err != nil {
+		g.opts.logger.Warnf(r.Context(), "wait receipts error:%s", err.Error())
+		r.Response.WriteJson(clustermessage.NewErrorResp("wait receipts error"))
+		return
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(result))
+}
+
+// addProjectPush 统计项目推送成功的消息数量和字节数
+func (g gfServer) addProjectPush(pid string, source string, size int) {
+	p := g.opts.prometheus
+	labels := []string{strconv.FormatInt(shared.GetNodeID(), 10), shared.GetInternalIP(), p.PIDLabel(pid)}
+	_ = p.GetAdd(wsprometheus.MetricProjectPush, append(labels, source), 1)
+	_ = p.GetAdd(wsprometheus.MetricProjectBytes, append(labels, "in"), float64(size))
+}
+
+// authPusher 校验推送方的token,返回不为空的errMsg代表校验失败
+func authPusher(token string) (userData *auth.UserData, errMsg string) {
+	userData, err := auth.Decode(token)
+	if err != nil {
+		return nil, "token error"
+	}
+	if !checking.DefaultChecking.IsExist(userData.PID) {
+		return nil, "PID denied"
+	}
+	if userData.ClientType == int(client.CTypeUser) {
+		return nil, "permission denied"
+	}
+	return userData, ""
 }
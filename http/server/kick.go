@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/shared"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// KickResult 踢下线结果,kicked 为所有节点上被关闭的客户端id
+type KickResult struct {
+	Kicked   []string `json:"kicked"`
+	Nodes    int      `json:"nodes"`    // 需要回执的节点数
+	Reported int      `json:"reported"` // 已经回执的节点数
+	Timeout  bool     `json:"timeout"`  // 等待超时,部分节点的结果缺失
+}
+
+// 踢下线
+//
+//	@Summary		关闭集群中指定的连接
+//	@Description	通过队列广播到所有节点,每个节点关闭连接在本节点上的客户端并回执,关闭原因记录为kick,开启conn_event时记录踢下线事件
+//	@ID				client-kick
+//	@Produce		json
+//	@Param			token	query		string	true	"管理端签名"
+//	@Param			cids	query		string	true	"客户端id,多个客户端id以逗号隔开"
+//	@Param			wait_ms	query		int		false	"等待各节点回执的最长时间,默认1000,最大5000"
+//	@Success		200		{string}	string	"{"code":1,"msg":"success","payload":{"kicked":["1"],"nodes":2,"reported":2,"timeout":false}}"
+//	@Failure		503		{object}	message.Res	"code=0,msg=service busy, please retry,队列繁忙时返回,可以稍后重试"
+//	@Router			/clients/kick [post]
+func (g gfServer) kickHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	var cids []string
+	for _, cid := range strings.Split(r.Get("cids").String(), ",") {
+		if cid = strings.TrimSpace(cid); cid != "" {
+			cids = append(cids, cid)
+		}
+	}
+	if len(cids) == 0 {
+		r.Response.WriteJson(clustermessage.NewErrorResp("cids is required"))
+		return
+	}
+	ctx := r.Context()
+	msg := &clustermessage.AffairMsg{
+		Type:      clustermessage.TypeKick,
+		To:        &clustermessage.To{CIDs: cids},
+		ReceiptID: shared.GetSnowflakeNode().Generate().String(),
+	}
+	queue.StampHeader(msg)
+	if err := g.opts.queue.Publish(ctx, msg); err != nil {
+		g.opts.logger.Warnf(ctx, "publish kick message error:%s", err.Error())
+		if queue.IsPublishDropped(err) {
+			r.Response.Header().Set("Retry-After", "1")
+			r.Response.WriteHeader(http.StatusServiceUnavailable)
+			r.Response.WriteJson(clustermessage.NewErrorResp("service busy, please retry"))
+			return
+		}
+		r.Response.WriteJson(clustermessage.NewErrorResp("publish message error"))
+		return
+	}
+
+	wait := defaultPushWait
+	if waitMs := r.Get("wait_ms").Int64(); waitMs > 0 {
+		wait = time.Duration(waitMs) * time.Millisecond
+	}
+	if wait > maxPushWait {
+		wait = maxPushWait
+	}
+	receipts, err := g.opts.cluster.WaitReceipts(ctx, msg.ReceiptID, nil, wait)
+	if err != nil {
+		g.opts.logger.Warnf(ctx, "wait kick receipts error:%s", err.Error())
+		r.Response.WriteJson(clustermessage.NewErrorResp("wait receipts error"))
+		return
+	}
+	result := KickResult{
+		Kicked:   make([]string, 0, len(cids)),
+		Nodes:    receipts.Nodes,
+		Reported: receipts.Reported,
+		Timeout:  receipts.Timeout,
+	}
+	for _, kicked := range receipts.Delivered {
+		result.Kicked = append(result.Kicked, kicked...)
+	}
+	sort.Strings(result.Kicked)
+	g.opts.logger.Infof(ctx, "kick clients:%v,nodes:%d,reported:%d", result.Kicked, result.Nodes, result.Reported)
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(result))
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// 日志级别
+//
+//	@Summary		查看或修改各模块的日志级别
+//	@Description	只修改本节点,GET或module为空时只返回所有模块的级别,修改需要使用POST,模块有 default, queue, manager, ws_server, http_server, client
+//	@Description	ttl大于0时到期后恢复为配置文件中的级别,reset为true时立即恢复
+//	@ID				log-level
+//	@Produce		json
+//	@Param			token	query		string	true	"管理端签名"
+//	@Param			module	query		string	false	"模块"
+//	@Param			level	query		string	false	"debug,info,warn,error,dpanic,panic,fatal,修改时必填"
+//	@Param			ttl		query		int		false	"有效期,单位秒,为0时不过期"
+//	@Param			reset	query		bool	false	"恢复为配置文件中的级别"
+//	@Success		200		{array}		loglevel.Status	"{"code":1,"msg":"success","payload":[{"module":"queue","level":"debug","initial":"info","expire_at":1700000000}]}"
+//	@Router			/log/level [get]
+//	@Router			/log/level [post]
+func (g gfServer) logLevelHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	if module := r.Get("module").String(); module != "" {
+		if r.Method != http.MethodPost {
+			r.Response.WriteJson(clustermessage.NewErrorResp("use POST to change log level"))
+			return
+		}
+		var (
+			err   error
+			level = r.Get("level").String()
+		)
+		if r.Get("reset").Bool() {
+			err = g.opts.levels.Reset(module)
+		} else if level == "" {
+			// 空的级别会被解析为info,需要明确指定
+			err = errors.New("level is required")
+		} else {
+			err = g.opts.levels.Set(module, level, time.Duration(r.Get("ttl").Int64())*time.Second)
+		}
+		if err != nil {
+			r.Response.WriteJson(clustermessage.NewErrorResp(err.Error()))
+			return
+		}
+		g.opts.logger.Infof(r.Context(), "log level of %s set to %s,ttl:%ds,reset:%t", module, level, r.Get("ttl").Int64(), r.Get("reset").Bool())
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(g.opts.levels.List()))
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/logger/loglevel"
+	"github.com/mtgnorton/ws-cluster/shared/auth"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+type logLevelResp struct {
+	Code    int               `json:"code"`
+	Msg     string            `json:"msg"`
+	Payload []loglevel.Status `json:"payload"`
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	levels := logger.NewLevels(config.Log{Level: "info"})
+	s := gfServer{
+		opts: Options{
+			ctx:    ctx,
+			logger: logger.DefaultLogger,
+			levels: levels,
+		},
+		server: g.Server(fmt.Sprintf("log_level_test_%d", time.Now().UnixNano())),
+	}
+	s.server.BindHandler("GET:/log/level", s.logLevelHandler)
+	s.server.BindHandler("POST:/log/level", s.logLevelHandler)
+	s.server.SetAddr("127.0.0.1:0")
+	s.server.SetDumpRouterMap(false)
+	if err := s.server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.server.Shutdown() })
+	u := fmt.Sprintf("http://127.0.0.1:%d/log/level", s.server.GetListenedPort())
+	token := auth.MustEncode(&auth.UserData{PID: "p1", UID: "admin", ClientType: int(client.CTypeAdmin)})
+
+	request := func(method string, query url.Values) *logLevelResp {
+		t.Helper()
+		query.Set("token", token)
+		req, err := http.NewRequest(method, u+"?"+query.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		result := &logLevelResp{}
+		if err := json.Unmarshal(body, result); err != nil {
+			t.Fatalf("parse %s error:%v", body, err)
+		}
+		return result
+	}
+	queueLevel := func() string {
+		return levels.Level(string(logger.ModuleQueue)).Level().String()
+	}
+
+	if resp := request(http.MethodGet, url.Values{}); resp.Code != 1 || len(resp.Payload) == 0 {
+		t.Fatalf("list = %+v", resp)
+	}
+	// GET 不允许修改
+	if resp := request(http.MethodGet, url.Values{"module": {"queue"}, "level": {"debug"}}); resp.Code == 1 || queueLevel() != "info" {
+		t.Fatalf("get should not change level, got %+v, level %s", resp, queueLevel())
+	}
+	// 缺少level时不能被当作info
+	if resp := request(http.MethodPost, url.Values{"module": {"queue"}}); resp.Code == 1 {
+		t.Fatalf("empty level should be rejected, got %+v", resp)
+	}
+	if resp := request(http.MethodPost, url.Values{"module": {"queue"}, "level": {"debug"}}); resp.Code != 1 || queueLevel() != "debug" {
+		t.Fatalf("post = %+v, level %s", resp, queueLevel())
+	}
+	if resp := request(http.MethodPost, url.Values{"module": {"queue"}, "level": {""}}); resp.Code == 1 || queueLevel() != "debug" {
+		t.Fatalf("empty level should be rejected, got %+v, level %s", resp, queueLevel())
+	}
+	if resp := request(http.MethodPost, url.Values{"module": {"queue"}, "reset": {"true"}}); resp.Code != 1 || queueLevel() != "info" {
+		t.Fatalf("reset = %+v, level %s", resp, queueLevel())
+	}
+}
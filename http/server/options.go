@@ -3,11 +3,17 @@ package server
 import (
 	"context"
 
+	"github.com/mtgnorton/ws-cluster/core/cluster"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
+	"github.com/mtgnorton/ws-cluster/core/manager"
 	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/core/tap"
 	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/logger/loglevel"
 
 	"github.com/mtgnorton/ws-cluster/config"
 	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
 )
 
 type Option func(*Options)
@@ -17,7 +23,13 @@ type Options struct {
 	config     config.Config
 	logger     logger.Logger
 	prometheus *wsprometheus.Prometheus
+	tracer     *wstrace.Tracer
 	queue      queue.Queue
+	cluster    *cluster.Cluster
+	deadLetter *deadletter.DeadLetter
+	manager    manager.Manager
+	levels     *loglevel.Registry
+	tap        *tap.Tap
 	port       int
 }
 
@@ -25,9 +37,15 @@ func NewOptions(opts ...Option) Options {
 	options := Options{
 		ctx:        context.Background(),
 		config:     config.DefaultConfig,
-		logger:     logger.DefaultLogger,
+		logger:     logger.DefaultLogger.WithModule(logger.ModuleHttpServer),
 		prometheus: wsprometheus.DefaultPrometheus,
+		tracer:     wstrace.DefaultTracer,
 		queue:      queue.GetQueueInstance(config.DefaultConfig),
+		cluster:    cluster.DefaultCluster,
+		deadLetter: deadletter.DefaultDeadLetter,
+		manager:    manager.DefaultManager,
+		levels:     logger.DefaultLevels,
+		tap:        tap.DefaultTap,
 		port:       config.DefaultConfig.Values().HttpServer.Port,
 	}
 	for _, o := range opts {
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// 切换双写队列的消费来源
+//
+//	@Summary		查看或切换双写队列的消费来源
+//	@Description	只有queue.use为dual时可用,source为空时只返回当前的消费来源,切换后所有节点在1秒内生效
+//	@Description	建议的切换顺序为 primary -> both -> secondary,both时两个队列都消费,按消息ID去重
+//	@ID				queue-dual
+//	@Produce		json
+//	@Param			token	query		string	true	"管理端签名"
+//	@Param			source	query		string	false	"primary,secondary,both"
+//	@Success		200		{string}	string	"{"code":1,"msg":"success","payload":{"source":"primary"}}"
+//	@Router			/queue/dual [get]
+//	@Router			/queue/dual [post]
+func (g gfServer) queueDualHandler(r *ghttp.Request) {
+	if errMsg := authAdmin(r.Get("token").String()); errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	switcher, ok := g.opts.queue.(queue.DualSwitcher)
+	if !ok {
+		r.Response.WriteJson(clustermessage.NewErrorResp("queue is not dual"))
+		return
+	}
+	if source := r.Get("source").String(); source != "" {
+		if err := switcher.SetConsumeSource(r.Context(), source); err != nil {
+			r.Response.WriteJson(clustermessage.NewErrorResp(err.Error()))
+			return
+		}
+		g.opts.logger.Infof(r.Context(), "queue dual consume source switch to %s", source)
+	}
+	r.Response.WriteJson(clustermessage.NewSuccessRespWithPayload(map[string]string{"source": switcher.ConsumeSource()}))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/core/tap"
+	"github.com/mtgnorton/ws-cluster/core/tap/tapevent"
+	"github.com/mtgnorton/ws-cluster/shared/kit"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+	jsoniter "github.com/json-iterator/go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	streamPushPath           = "/v1/push/stream"
+	defaultStreamMaxBodySize = 1024             // 流式推送默认的请求体最大大小,单位MB
+	defaultStreamReadTimeout = 10 * time.Minute // 流式推送默认读取请求体的超时时间
+
+	streamMaxLineSize   = 1024 * 1024            // 单行推送消息的最大字节数
+	streamFlushLines    = 100                    // 累计多少行结果后输出给调用方
+	streamFlushInterval = 100 * time.Millisecond // 距离上次输出超过该时间后输出给调用方
+)
+
+// StreamPushLine 流式推送中的单行消息
+type StreamPushLine struct {
+	AffairID string      `json:"affair_id,omitempty"`
+	UIDs     []string    `json:"uids,omitempty"`
+	CIDs     []string    `json:"cids,omitempty"`
+	Data     interface{} `json:"data"`
+}
+
+// StreamPushResult 流式推送中每行消息的处理结果,Line从1开始,Line为0时代表整个请求的汇总结果
+type StreamPushResult struct {
+	Line     int                `json:"line"`
+	Code     int                `json:"code"`
+	Msg      string             `json:"msg"`
+	AffairID string             `json:"affair_id,omitempty"`
+	Summary  *StreamPushSummary `json:"summary,omitempty"`
+}
+
+type StreamPushSummary struct {
+	Total   int `json:"total"`
+	Success int `json:"success"`
+	Failed  int `json:"failed"`
+}
+
+// 流式推送消息
+//
+//	@Summary		业务系统通过该接口批量推送消息
+//	@Description	请求体为换行分隔的json(NDJSON),每行格式为{"affair_id":"","uids":[],"cids":[],"data":{}},支持chunked传输
+//	@Description	每行消息依次进入消息队列,本地队列满时会阻塞读取请求体以实现背压
+//	@Description	响应同样为NDJSON,每行对应一条消息的处理结果,最后一行line为0,为整个请求的汇总
+//	@ID				push-message-stream
+//	@Accept			application/x-ndjson
+//	@Produce		application/x-ndjson
+//	@Param			token	query		string				true	"签名"
+//	@Success		200		{object}	StreamPushResult	"{"line":1,"code":1,"msg":"success"}"
+//	@Router			/push/stream [post]
+func (g gfServer) streamHandler(r *ghttp.Request) {
+	// 不能使用r.Get,会读取整个请求体
+	token := r.GetQuery("token").String()
+	ctx, span := g.opts.tracer.Start(g.opts.tracer.ExtractHTTP(r.Context(), r.Header), "http.push_stream")
+	defer span.End()
+	userData, errMsg := authPusher(token)
+	if errMsg != "" {
+		r.Response.WriteJson(clustermessage.NewErrorResp(errMsg))
+		return
+	}
+	span.SetAttributes(attribute.String("pid", userData.PID))
+	g.pushStream(ctx, r, userData.PID)
+}
+
+// pushStream 逐行读取请求体推送,每行的处理结果按批次输出给调用方
+func (g gfServer) pushStream(ctx context.Context, r *ghttp.Request, pid string) {
+	logger := g.opts.logger
+	summary := &StreamPushSummary{}
+	r.Response.Header().Set("Content-Type", "application/x-ndjson")
+	pending := 0
+	lastFlush := time.Now()
+	writeResult := func(result *StreamPushResult, force bool) {
+		resultBytes, _ := json.Marshal(result)
+		r.Response.Write(resultBytes, "\n")
+		pending++
+		if force || pending >= streamFlushLines || time.Since(lastFlush) >= streamFlushInterval {
+			r.Response.Flush()
+			pending = 0
+			lastFlush = time.Now()
+		}
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), streamMaxLineSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		content := bytes.TrimSpace(scanner.Bytes())
+		if len(content) == 0 {
+			continue
+		}
+		summary.Total++
+		result := g.publishStreamLine(ctx, pid, lineNo, content)
+		if result.Code == 1 {
+			summary.Success++
+		} else {
+			summary.Failed++
+		}
+		writeResult(result, result.Code != 1)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	done := &StreamPushResult{Code: 1, Msg: "done", Summary: summary}
+	if err := scanner.Err(); err != nil {
+		logger.Warnf(ctx, "push stream read body error after line %d:%v", lineNo, err)
+		done.Code, done.Msg = 0, "read body error:"+err.Error()
+	}
+	writeResult(done, true)
+}
+
+func (g gfServer) publishStreamLine(ctx context.Context, pid string, lineNo int, content []byte) *StreamPushResult {
+	line := &StreamPushLine{}
+	if err := json.Unmarshal(content, line); err != nil {
+		return &StreamPushResult{Line: lineNo, Msg: "parse line error:" + err.Error()}
+	}
+	result := &StreamPushResult{Line: lineNo, AffairID: line.AffairID}
+	if len(line.UIDs) == 0 && len(line.CIDs) == 0 {
+		result.Msg = "uids or cids is required"
+		return result
+	}
+	msg := &clustermessage.AffairMsg{
+		AffairID: line.AffairID,
+		Payload:  line.Data,
+		Type:     clustermessage.TypePush,
+		To:       &clustermessage.To{PID: pid, UIDs: line.UIDs, CIDs: line.CIDs},
+	}
+	queue.StampHeader(msg)
+	g.opts.tap.Observe(ctx, tap.Point{Stage: tapevent.StageIngest, Source: "http_stream", Msg: msg})
+	if err := g.opts.queue.Publish(ctx, msg); err != nil {
+		g.opts.logger.Warnf(ctx, "push stream publish line %d error:%s", lineNo, err.Error())
+		result.Msg = kit.IfElse(queue.IsPublishDropped(err), "service busy, please retry", "publish message error")
+		return result
+	}
+	g.addProjectPush(pid, "http_stream", len(content))
+	result.Code, result.Msg = 1, "success"
+	return result
+}
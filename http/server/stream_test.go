@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/core/tap"
+	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// 请求体超过服务端读缓冲,并且在读完之前已经输出过结果,每一行都需要有对应的结果
+func TestPushStreamChunked(t *testing.T) {
+	const (
+		pid   = "stream_test"
+		lines = 300
+	)
+	s := gfServer{
+		opts: Options{
+			ctx:        context.Background(),
+			config:     config.DefaultConfig,
+			logger:     logger.DefaultLogger,
+			prometheus: wsprometheus.DefaultPrometheus,
+			tracer:     wstrace.DefaultTracer,
+			queue:      queue.NewMemoryQueue(),
+			tap:        tap.DefaultTap,
+		},
+		server: g.Server(pid),
+	}
+	s.server.BindHandler("POST:"+streamPushPath, func(r *ghttp.Request) {
+		s.pushStream(r.Context(), r, pid)
+	})
+	s.server.SetHandler(s.serveHTTP)
+	s.server.SetClientMaxBodySize(0)
+	s.server.SetAddr("127.0.0.1:0")
+	s.server.SetDumpRouterMap(false)
+	if err := s.server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.server.Shutdown()
+
+	bodyReader, bodyWriter := io.Pipe()
+	go func() {
+		data := strings.Repeat("x", 1024)
+		for i := 1; i <= lines; i++ {
+			_, _ = fmt.Fprintf(bodyWriter, `{"affair_id":"%d","uids":["u%d"],"data":"%s"}`+"\n", i, i, data)
+			// 分批写入,让服务端在请求体读完之前输出结果
+			if i%50 == 0 {
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+		_ = bodyWriter.Close()
+	}()
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", s.server.GetListenedPort(), streamPushPath)
+	req, err := http.NewRequest(http.MethodPost, url, bodyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.TransferEncoding = []string{"chunked"}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 1 {
+		t.Fatalf("want HTTP/1.1, got %s", resp.Proto)
+	}
+
+	seen := make(map[int]bool, lines)
+	var done *StreamPushResult
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		result := &StreamPushResult{}
+		if err := json.Unmarshal(scanner.Bytes(), result); err != nil {
+			t.Fatalf("parse result %s error:%v", scanner.Text(), err)
+		}
+		if result.Line == 0 {
+			done = result
+			continue
+		}
+		if result.Code != 1 {
+			t.Fatalf("line %d failed:%s", result.Line, result.Msg)
+		}
+		seen[result.Line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if done == nil || done.Code != 1 || done.Summary == nil || done.Summary.Success != lines {
+		t.Fatalf("summary = %+v", done)
+	}
+	for i := 1; i <= lines; i++ {
+		if !seen[i] {
+			t.Fatalf("line %d has no result", i)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package logfield
+
+import (
+	"context"
+	"strconv"
+)
+
+// 日志中统一使用的字段名
+const (
+	PID      = "pid"
+	UID      = "uid"
+	CID      = "cid"
+	Node     = "node"
+	AffairID = "affair_id"
+)
+
+type ctxKey struct{}
+
+type field struct {
+	key   string
+	value string
+}
+
+// With 在ctx中附加日志字段,同名字段会被覆盖,value为空时不附加
+func With(ctx context.Context, key, value string) context.Context {
+	if value == "" {
+		return ctx
+	}
+	old, _ := ctx.Value(ctxKey{}).([]field)
+	fields := make([]field, 0, len(old)+1)
+	for _, f := range old {
+		if f.key != key {
+			fields = append(fields, f)
+		}
+	}
+	fields = append(fields, field{key: key, value: value})
+	return context.WithValue(ctx, ctxKey{}, fields)
+}
+
+func WithPID(ctx context.Context, pid string) context.Context {
+	return With(ctx, PID, pid)
+}
+
+func WithUID(ctx context.Context, uid string) context.Context {
+	return With(ctx, UID, uid)
+}
+
+func WithCID(ctx context.Context, cid string) context.Context {
+	return With(ctx, CID, cid)
+}
+
+func WithNode(ctx context.Context, nodeID int64) context.Context {
+	return With(ctx, Node, strconv.FormatInt(nodeID, 10))
+}
+
+func WithAffairID(ctx context.Context, affairID string) context.Context {
+	return With(ctx, AffairID, affairID)
+}
+
+// KeyValues 按附加的顺序返回ctx中的日志字段,键值交替排列,可以直接传给zap的With
+func KeyValues(ctx context.Context) []interface{} {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(ctxKey{}).([]field)
+	if len(fields) == 0 {
+		return nil
+	}
+	kvs := make([]interface{}, 0, len(fields)*2)
+	for _, f := range fields {
+		kvs = append(kvs, f.key, f.value)
+	}
+	return kvs
+}
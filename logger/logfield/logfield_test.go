@@ -0,0 +1,29 @@
+package logfield
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestKeyValues(t *testing.T) {
+	if kvs := KeyValues(context.Background()); kvs != nil {
+		t.Fatalf("kvs = %v", kvs)
+	}
+	//nolint:staticcheck
+	if kvs := KeyValues(nil); kvs != nil {
+		t.Fatalf("kvs of nil ctx = %v", kvs)
+	}
+
+	ctx := WithNode(WithUID(WithPID(context.Background(), "77"), ""), 3)
+	parent := WithCID(ctx, "c1")
+	child := WithPID(WithAffairID(parent, "a1"), "88")
+
+	if kvs, want := KeyValues(parent), []interface{}{PID, "77", Node, "3", CID, "c1"}; !reflect.DeepEqual(kvs, want) {
+		t.Fatalf("parent kvs = %v, want %v", kvs, want)
+	}
+	// 覆盖同名字段不影响父ctx
+	if kvs, want := KeyValues(child), []interface{}{Node, "3", CID, "c1", AffairID, "a1", PID, "88"}; !reflect.DeepEqual(kvs, want) {
+		t.Fatalf("child kvs = %v, want %v", kvs, want)
+	}
+}
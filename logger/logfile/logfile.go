@@ -0,0 +1,373 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RotateBuiltin  = "builtin"  // 按大小和时间切割
+	RotateExternal = "external" // 由logrotate等外部工具切割,只负责重新打开
+
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	compressSuffix   = ".gz"
+)
+
+// 测试中替换
+var (
+	currentTime = time.Now
+	megabyte    = int64(1024 * 1024)
+)
+
+type Config struct {
+	Filename       string
+	Rotate         string        // builtin,external,为空时使用builtin
+	MaxSize        int           // 单个文件的大小,单位MB,为0时不按大小切割
+	RotateInterval time.Duration // 按时间切割的间隔,按本地时间对齐,为0时不按时间切割
+	MaxAge         int           // 备份保留天数,为0时不按时间删除
+	MaxBackups     int           // 备份保留数量,为0时不按数量删除
+	Compress       bool          // 是否使用gzip压缩备份
+}
+
+// File 日志文件,并发安全
+// 内置切割时将当前文件重命名为 name-时间.ext 后创建新文件,备份的删除和压缩在后台进行
+// 外部切割时文件被移动,删除或截断后通过Check或Reopen重新打开,写入过程中不会丢失日志
+type File struct {
+	config Config
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	rotateAt time.Time // 下一次按时间切割的时间,为零值时不按时间切割
+
+	millCh   chan struct{}
+	millOnce sync.Once
+}
+
+func New(c Config) (*File, error) {
+	if c.Rotate == "" {
+		c.Rotate = RotateBuiltin
+	}
+	if c.Rotate != RotateBuiltin && c.Rotate != RotateExternal {
+		return nil, fmt.Errorf("unknown rotate mode:%s", c.Rotate)
+	}
+	if c.Filename == "" {
+		return nil, fmt.Errorf("filename is required")
+	}
+	f := &File{config: c, millCh: make(chan struct{}, 1)}
+	if err := os.MkdirAll(filepath.Dir(c.Filename), 0755); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) Write(p []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		if err = f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.shouldRotate(int64(len(p))) {
+		if err = f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.close()
+}
+
+// Reopen 关闭后重新打开文件,外部工具移动文件后调用,之后的日志写入新文件
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.close(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Check 检查文件是否被移动,删除或截断,被移动或删除时重新打开,被截断时重新计算大小
+func (f *File) Check() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	current, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(f.config.Filename)
+	if os.IsNotExist(err) || (err == nil && !os.SameFile(info, current)) {
+		if err := f.close(); err != nil {
+			return err
+		}
+		return f.open()
+	}
+	if err != nil {
+		return err
+	}
+	// 以追加的方式打开,截断后的写入从文件末尾开始,只需要更新大小
+	if current.Size() < f.size {
+		f.size = current.Size()
+	}
+	return nil
+}
+
+// Watch 收到signals中的信号时重新打开文件,每隔interval检查一次文件是否被移动或截断,直到ctx结束
+func (f *File) Watch(ctx context.Context, interval time.Duration, onError func(error), signals ...os.Signal) {
+	sigCh := make(chan os.Signal, 1)
+	if len(signals) > 0 {
+		signal.Notify(sigCh, signals...)
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	defer signal.Stop(sigCh)
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+			err = f.Reopen()
+		case <-tick:
+			err = f.Check()
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.config.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.rotateAt = time.Time{}
+	if f.config.Rotate == RotateBuiltin && f.config.RotateInterval > 0 {
+		f.rotateAt = nextRotateAt(currentTime(), f.config.RotateInterval)
+	}
+	return nil
+}
+
+func (f *File) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *File) shouldRotate(writeLen int64) bool {
+	if f.config.Rotate != RotateBuiltin || f.size == 0 {
+		return false
+	}
+	if f.config.MaxSize > 0 && f.size+writeLen > int64(f.config.MaxSize)*megabyte {
+		return true
+	}
+	return !f.rotateAt.IsZero() && !currentTime().Before(f.rotateAt)
+}
+
+func (f *File) rotate() error {
+	if err := f.close(); err != nil {
+		return err
+	}
+	// 同一毫秒内多次切割时顺延,避免覆盖之前的备份
+	t := currentTime()
+	name := f.backupName(t)
+	for exists(name) || exists(name+compressSuffix) {
+		t = t.Add(time.Millisecond)
+		name = f.backupName(t)
+	}
+	if err := os.Rename(f.config.Filename, name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.mill()
+	return nil
+}
+
+func (f *File) backupName(t time.Time) string {
+	dir, prefix, ext := f.nameParts()
+	return filepath.Join(dir, fmt.Sprintf("%s%s%s", prefix, t.Format(backupTimeFormat), ext))
+}
+
+// nameParts 返回备份文件的目录,前缀和扩展名,如 /logs/normal.log 的备份为 /logs/normal-2006-01-02T15-04-05.000.log
+func (f *File) nameParts() (dir, prefix, ext string) {
+	dir = filepath.Dir(f.config.Filename)
+	name := filepath.Base(f.config.Filename)
+	ext = filepath.Ext(name)
+	return dir, strings.TrimSuffix(name, ext) + "-", ext
+}
+
+// nextRotateAt 按本地时间对齐,如间隔为24小时时在本地的0点切割
+func nextRotateAt(now time.Time, interval time.Duration) time.Time {
+	_, offset := now.Zone()
+	shift := time.Duration(offset) * time.Second
+	return now.Add(shift).Truncate(interval).Add(interval).Add(-shift)
+}
+
+// mill 通知后台协程删除过期的备份并压缩
+func (f *File) mill() {
+	if f.config.MaxAge == 0 && f.config.MaxBackups == 0 && !f.config.Compress {
+		return
+	}
+	f.millOnce.Do(func() {
+		go func() {
+			for range f.millCh {
+				_ = f.millRunOnce()
+			}
+		}()
+	})
+	select {
+	case f.millCh <- struct{}{}:
+	default:
+	}
+}
+
+type backup struct {
+	path      string
+	timestamp time.Time
+}
+
+func (f *File) millRunOnce() error {
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	var remove []backup
+	if f.config.MaxBackups > 0 && len(backups) > f.config.MaxBackups {
+		remove = append(remove, backups[f.config.MaxBackups:]...)
+		backups = backups[:f.config.MaxBackups]
+	}
+	if f.config.MaxAge > 0 {
+		cutoff := currentTime().Add(-time.Duration(f.config.MaxAge) * 24 * time.Hour)
+		kept := backups[:0]
+		for _, b := range backups {
+			if b.timestamp.Before(cutoff) {
+				remove = append(remove, b)
+				continue
+			}
+			kept = append(kept, b)
+		}
+		backups = kept
+	}
+	for _, b := range remove {
+		_ = os.Remove(b.path)
+	}
+	if f.config.Compress {
+		for _, b := range backups {
+			if !strings.HasSuffix(b.path, compressSuffix) {
+				if err := compressFile(b.path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// backups 返回所有备份文件,按时间从新到旧排序
+func (f *File) backups() ([]backup, error) {
+	dir, prefix, ext := f.nameParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		ts := strings.TrimPrefix(name, prefix)
+		ts = strings.TrimSuffix(strings.TrimSuffix(ts, compressSuffix), ext)
+		t, err := time.ParseInLocation(backupTimeFormat, ts, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, name), timestamp: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].timestamp.After(backups[j].timestamp) })
+	return backups, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+compressSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(path + compressSuffix)
+		}
+	}()
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,181 @@
+package logfile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readLines 读取目录下所有日志文件(包括压缩的备份)的行
+func readLines(t *testing.T, dir string) map[string]int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(map[string]int)
+	for _, e := range entries {
+		file, err := os.Open(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = file
+		if strings.HasSuffix(e.Name(), compressSuffix) {
+			if r, err = gzip.NewReader(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines[scanner.Text()]++
+		}
+		_ = file.Close()
+	}
+	return lines
+}
+
+func TestRotateUnderLoad(t *testing.T) {
+	old := megabyte
+	megabyte = 1024
+	defer func() { megabyte = old }()
+
+	dir := t.TempDir()
+	f, err := New(Config{Filename: filepath.Join(dir, "normal.log"), MaxSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const writers, perWriter = 16, 2000
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if _, err := fmt.Fprintf(f, "writer-%d line-%d\n", w, i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	// 写入过程中重新打开(如收到SIGHUP),不应丢失日志
+	time.Sleep(time.Millisecond)
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, dir)
+	if len(lines) != writers*perWriter {
+		t.Fatalf("lines = %d, want %d", len(lines), writers*perWriter)
+	}
+	for line, count := range lines {
+		if count != 1 {
+			t.Fatalf("line %q written %d times", line, count)
+		}
+	}
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) < 10 {
+		t.Fatalf("backups = %d, should rotate by size", len(backups))
+	}
+}
+
+func TestCheckAfterExternalRotate(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "normal.log")
+	f, err := New(Config{Filename: name, Rotate: RotateExternal, MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, _ = f.Write([]byte("before rename\n"))
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Check(); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte("after rename\n"))
+	if data, _ := os.ReadFile(name); string(data) != "after rename\n" {
+		t.Fatalf("new file = %q", data)
+	}
+	if data, _ := os.ReadFile(name + ".1"); string(data) != "before rename\n" {
+		t.Fatalf("renamed file = %q", data)
+	}
+
+	// copytruncate
+	if err := os.Truncate(name, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if f.size != 0 {
+		t.Fatalf("size after truncate = %d", f.size)
+	}
+	_, _ = f.Write([]byte("after truncate\n"))
+	if data, _ := os.ReadFile(name); string(data) != "after truncate\n" {
+		t.Fatalf("truncated file = %q", data)
+	}
+
+	// 外部切割模式下不会自己切割
+	_, _ = f.Write(make([]byte, 2*megabyte))
+	if backups, _ := f.backups(); len(backups) != 0 {
+		t.Fatalf("external mode should not rotate, backups = %d", len(backups))
+	}
+}
+
+func TestRotateByTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 23, 59, 0, 0, time.Local)
+	currentTime = func() time.Time { return now }
+	defer func() { currentTime = time.Now }()
+
+	dir := t.TempDir()
+	f, err := New(Config{Filename: filepath.Join(dir, "normal.log"), RotateInterval: 24 * time.Hour, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if want := time.Date(2024, 5, 2, 0, 0, 0, 0, time.Local); !f.rotateAt.Equal(want) {
+		t.Fatalf("rotateAt = %s, want %s", f.rotateAt, want)
+	}
+
+	for day := 0; day < 4; day++ {
+		_, _ = fmt.Fprintf(f, "day-%d\n", day)
+		now = now.Add(24 * time.Hour)
+	}
+	// 等待后台删除和压缩
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		backups, err := f.backups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed := len(backups) == 2 && strings.HasSuffix(backups[0].path, compressSuffix) && strings.HasSuffix(backups[1].path, compressSuffix)
+		if compressed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("backups = %+v", backups)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lines := readLines(t, dir)
+	if len(lines) != 3 || lines["day-0"] != 0 || lines["day-3"] != 1 {
+		t.Fatalf("lines = %v", lines)
+	}
+}
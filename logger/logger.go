@@ -2,9 +2,15 @@ package logger
 
 import (
 	"context"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/logger/loglevel"
 )
 
-var DefaultLogger Logger = NewZapLogger()
+// DefaultLevels 各模块的日志级别,可以通过管理接口在运行时修改
+var DefaultLevels = NewLevels(config.DefaultConfig.Values().Log)
+
+var DefaultLogger Logger = NewZapLogger(WithLevels(DefaultLevels))
 
 type LogLevel string
 
@@ -18,8 +24,33 @@ const (
 	FatalLevel  LogLevel = "fatal"
 )
 
+// Module 日志所属的模块,每个模块的级别可以单独配置
+type Module string
+
+const (
+	ModuleDefault    Module = "default"
+	ModuleQueue      Module = "queue"
+	ModuleManager    Module = "manager"
+	ModuleWsServer   Module = "ws_server"
+	ModuleHttpServer Module = "http_server"
+	ModuleClient     Module = "client"
+)
+
+var Modules = []Module{ModuleDefault, ModuleQueue, ModuleManager, ModuleWsServer, ModuleHttpServer, ModuleClient}
+
+// NewLevels 根据日志配置创建所有模块的级别,log.modules中没有配置的模块使用log.level
+func NewLevels(c config.Log) *loglevel.Registry {
+	names := make([]string, 0, len(Modules))
+	for _, m := range Modules {
+		names = append(names, string(m))
+	}
+	return loglevel.NewRegistry(c.Level, c.Modules, names...)
+}
+
 type Logger interface {
 	Init(opts ...Option)
+	WithModule(module Module) Logger
+	Sync() error
 	Debug(ctx context.Context, args ...interface{})
 	Debugf(ctx context.Context, template string, args ...interface{})
 	Info(ctx context.Context, args ...interface{})
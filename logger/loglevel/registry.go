@@ -0,0 +1,139 @@
+package loglevel
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Status 模块当前的日志级别
+type Status struct {
+	Module   string `json:"module"`
+	Level    string `json:"level"`
+	Initial  string `json:"initial"`             // 配置文件中的级别,过期或重置后恢复为该级别
+	ExpireAt int64  `json:"expire_at,omitempty"` // 修改的过期时间,unix秒,为0时不过期
+}
+
+type module struct {
+	level    zap.AtomicLevel
+	initial  zapcore.Level
+	expireAt time.Time
+	timer    *time.Timer
+}
+
+// Registry 保存各模块的日志级别,运行时修改后所有使用该模块级别的日志立即生效
+// 修改时可以指定有效期,过期后恢复为配置文件中的级别
+type Registry struct {
+	mu      sync.Mutex
+	modules map[string]*module
+}
+
+// NewRegistry defaultLevel为没有单独配置的模块使用的级别,overrides为配置文件中单独配置的模块级别
+// names为所有模块,只有这些模块的级别可以被修改,无法解析的级别使用info
+func NewRegistry(defaultLevel string, overrides map[string]string, names ...string) *Registry {
+	r := &Registry{modules: make(map[string]*module, len(names))}
+	base := ParseLevel(defaultLevel)
+	for _, name := range names {
+		initial := base
+		if level, ok := overrides[name]; ok {
+			initial = ParseLevel(level)
+		}
+		r.modules[name] = &module{level: zap.NewAtomicLevelAt(initial), initial: initial}
+	}
+	return r
+}
+
+// ParseLevel 解析日志级别,无法解析时返回info
+func ParseLevel(s string) zapcore.Level {
+	level, err := zapcore.ParseLevel(s)
+	if err != nil {
+		return zapcore.InfoLevel
+	}
+	return level
+}
+
+// Level 返回模块的级别,未注册的模块返回固定为info的级别
+func (r *Registry) Level(name string) zap.AtomicLevel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.modules[name]; ok {
+		return m.level
+	}
+	return zap.NewAtomicLevelAt(zapcore.InfoLevel)
+}
+
+// Set 修改模块的级别,ttl大于0时到期后恢复为配置文件中的级别,再次修改会取消之前的过期时间
+func (r *Registry) Set(name string, level string, ttl time.Duration) error {
+	l, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.modules[name]
+	if !ok {
+		return fmt.Errorf("unknown module:%s", name)
+	}
+	m.stopTimer()
+	m.level.SetLevel(l)
+	if ttl > 0 {
+		m.expireAt = time.Now().Add(ttl)
+		var timer *time.Timer
+		timer = time.AfterFunc(ttl, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			// 已经被再次修改或重置
+			if m.timer != timer {
+				return
+			}
+			m.reset()
+		})
+		m.timer = timer
+	}
+	return nil
+}
+
+// Reset 恢复为配置文件中的级别
+func (r *Registry) Reset(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.modules[name]
+	if !ok {
+		return fmt.Errorf("unknown module:%s", name)
+	}
+	m.reset()
+	return nil
+}
+
+// List 按模块名称排序返回所有模块的级别
+func (r *Registry) List() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]Status, 0, len(r.modules))
+	for name, m := range r.modules {
+		status := Status{Module: name, Level: m.level.Level().String(), Initial: m.initial.String()}
+		if !m.expireAt.IsZero() {
+			status.ExpireAt = m.expireAt.Unix()
+		}
+		list = append(list, status)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Module < list[j].Module })
+	return list
+}
+
+func (m *module) reset() {
+	m.stopTimer()
+	m.level.SetLevel(m.initial)
+}
+
+func (m *module) stopTimer() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.expireAt = time.Time{}
+}
@@ -0,0 +1,77 @@
+package loglevel
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRegistryInitial(t *testing.T) {
+	r := NewRegistry("warn", map[string]string{"queue": "debug", "client": "bad"}, "default", "queue", "client")
+	cases := map[string]zapcore.Level{
+		"default": zapcore.WarnLevel,
+		"queue":   zapcore.DebugLevel,
+		"client":  zapcore.InfoLevel,
+		"unknown": zapcore.InfoLevel,
+	}
+	for name, want := range cases {
+		if got := r.Level(name).Level(); got != want {
+			t.Fatalf("level of %s = %s, want %s", name, got, want)
+		}
+	}
+	list := r.List()
+	if len(list) != 3 || list[0].Module != "client" || list[2].Module != "queue" || list[2].Initial != "debug" {
+		t.Fatalf("list = %+v", list)
+	}
+}
+
+func TestRegistrySet(t *testing.T) {
+	r := NewRegistry("info", nil, "queue", "manager")
+	level := r.Level("queue")
+	if level.Enabled(zapcore.DebugLevel) {
+		t.Fatal("debug should be disabled")
+	}
+	if err := r.Set("queue", "debug", 0); err != nil {
+		t.Fatal(err)
+	}
+	// 已经获取的级别立即生效
+	if !level.Enabled(zapcore.DebugLevel) {
+		t.Fatal("debug should be enabled after set")
+	}
+	if r.Level("manager").Enabled(zapcore.DebugLevel) {
+		t.Fatal("other modules should not be changed")
+	}
+	if err := r.Reset("queue"); err != nil || level.Enabled(zapcore.DebugLevel) {
+		t.Fatalf("reset err = %v", err)
+	}
+
+	if err := r.Set("unknown", "debug", 0); err == nil {
+		t.Fatal("unknown module should be rejected")
+	}
+	if err := r.Set("queue", "verbose", 0); err == nil {
+		t.Fatal("invalid level should be rejected")
+	}
+}
+
+func TestRegistryTTL(t *testing.T) {
+	r := NewRegistry("info", nil, "queue")
+	if err := r.Set("queue", "debug", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if status := r.List()[0]; status.Level != "debug" || status.ExpireAt == 0 {
+		t.Fatalf("status = %+v", status)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if status := r.List()[0]; status.Level != "info" || status.ExpireAt != 0 {
+		t.Fatalf("status after ttl = %+v", status)
+	}
+
+	// 再次修改会取消之前的过期时间
+	_ = r.Set("queue", "debug", 50*time.Millisecond)
+	_ = r.Set("queue", "warn", 0)
+	time.Sleep(100 * time.Millisecond)
+	if level := r.Level("queue").Level(); level != zapcore.WarnLevel {
+		t.Fatalf("level = %s", level)
+	}
+}
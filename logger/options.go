@@ -1,10 +1,17 @@
 package logger
 
-import "github.com/mtgnorton/ws-cluster/config"
+import (
+	"sync"
+
+	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/logger/loglevel"
+)
 
 type Options struct {
 	configLog    config.Log
 	configSentry config.Sentry
+	levels       *loglevel.Registry
+	modules      sync.Map // Module -> Logger
 }
 
 type Option func(*Options)
@@ -17,6 +24,9 @@ func NewOptions(opts ...Option) *Options {
 	for _, opt := range opts {
 		opt(o)
 	}
+	if o.levels == nil {
+		o.levels = NewLevels(o.configLog)
+	}
 	return o
 }
 
@@ -31,3 +41,10 @@ func WithConfigSentry(configSentry config.Sentry) Option {
 		o.configSentry = configSentry
 	}
 }
+
+// WithLevels 指定各模块的日志级别,不指定时根据日志配置创建
+func WithLevels(levels *loglevel.Registry) Option {
+	return func(o *Options) {
+		o.levels = levels
+	}
+}
@@ -5,101 +5,162 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"syscall"
+	"time"
 
 	"github.com/getsentry/sentry-go"
 
 	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/logger/logfield"
+	"github.com/mtgnorton/ws-cluster/logger/logfile"
 	"github.com/mtgnorton/ws-cluster/shared"
 	"github.com/mtgnorton/ws-cluster/tools/wssentry"
 
 	"github.com/TheZeroSlave/zapsentry"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type ZapLogger struct {
 	options *Options
 	logger  *zap.SugaredLogger
+	level   zap.AtomicLevel // 所属模块的级别,运行时修改后立即生效
 }
 
 func NewZapLogger(opts ...Option) Logger {
 	options := NewOptions(opts...)
 	z := &ZapLogger{
 		options: options,
+		level:   options.levels.Level(string(ModuleDefault)),
 	}
 	z.logger = z.initLogger()
 	return z
 }
 
+// WithModule 返回使用模块级别的日志,日志中附加module字段,同一模块只创建一次
+func (z ZapLogger) WithModule(module Module) Logger {
+	if l, ok := z.options.modules.Load(module); ok {
+		return l.(Logger)
+	}
+	l, _ := z.options.modules.LoadOrStore(module, &ZapLogger{
+		options: z.options,
+		logger:  z.logger.With("module", string(module)),
+		level:   z.options.levels.Level(string(module)),
+	})
+	return l.(Logger)
+}
+
 func (z *ZapLogger) Init(opts ...Option) {
 	for _, o := range opts {
 		o(z.options)
 	}
+	z.level = z.options.levels.Level(string(ModuleDefault))
 	z.logger = z.initLogger()
+	// 已经创建的模块日志使用的是旧配置,下次获取时重新创建
+	z.options.modules.Range(func(key, _ any) bool {
+		z.options.modules.Delete(key)
+		return true
+	})
 }
 
 func (z ZapLogger) Debug(ctx context.Context, args ...interface{}) {
+	if !z.level.Enabled(zapcore.DebugLevel) {
+		return
+	}
 	args = append([]interface{}{fmt.Sprintf(" [ServerIP:%s,NodeID:%d] ", shared.GetInternalIP(), shared.GetNodeID())}, args...)
-	z.logger.Debug(args...)
+	z.with(ctx).Debug(args...)
 }
 
 func (z ZapLogger) Debugf(ctx context.Context, template string, args ...interface{}) {
-	z.logger.Debugf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
+	if !z.level.Enabled(zapcore.DebugLevel) {
+		return
+	}
+	z.with(ctx).Debugf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
 }
 
 func (z ZapLogger) Info(ctx context.Context, args ...interface{}) {
+	if !z.level.Enabled(zapcore.InfoLevel) {
+		return
+	}
 	args = append([]interface{}{fmt.Sprintf(" [ServerIP:%s,NodeID:%d] ", shared.GetInternalIP(), shared.GetNodeID())}, args...)
-	z.logger.Info(args...)
+	z.with(ctx).Info(args...)
 }
 
 func (z ZapLogger) Infof(ctx context.Context, template string, args ...interface{}) {
-	z.logger.Infof(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
+	if !z.level.Enabled(zapcore.InfoLevel) {
+		return
+	}
+	z.with(ctx).Infof(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
 }
 
 func (z ZapLogger) Warn(ctx context.Context, args ...interface{}) {
+	if !z.level.Enabled(zapcore.WarnLevel) {
+		return
+	}
 	args = append([]interface{}{fmt.Sprintf(" [ServerIP:%s,NodeID:%d] ", shared.GetInternalIP(), shared.GetNodeID())}, args...)
-	z.logger.Warn(args...)
+	z.with(ctx).Warn(args...)
 }
 
 func (z ZapLogger) Warnf(ctx context.Context, template string, args ...interface{}) {
-	z.logger.Warnf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
+	if !z.level.Enabled(zapcore.WarnLevel) {
+		return
+	}
+	z.with(ctx).Warnf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
 }
 
 func (z ZapLogger) Error(ctx context.Context, args ...interface{}) {
+	if !z.level.Enabled(zapcore.ErrorLevel) {
+		return
+	}
 	args = append([]interface{}{fmt.Sprintf(" [ServerIP:%s,NodeID:%d] ", shared.GetInternalIP(), shared.GetNodeID())}, args...)
-	z.logger.Error(args...)
+	z.with(ctx).Error(args...)
 }
 
 func (z ZapLogger) Errorf(ctx context.Context, template string, args ...interface{}) {
-	z.logger.Errorf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
+	if !z.level.Enabled(zapcore.ErrorLevel) {
+		return
+	}
+	z.with(ctx).Errorf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
 }
 
 func (z ZapLogger) Fatal(ctx context.Context, args ...interface{}) {
 	args = append([]interface{}{fmt.Sprintf(" [ServerIP:%s,NodeID:%d] ", shared.GetInternalIP(), shared.GetNodeID())}, args...)
-	z.logger.Fatal(args...)
+	z.with(ctx).Fatal(args...)
 }
 
 func (z ZapLogger) Fatalf(ctx context.Context, template string, args ...interface{}) {
-	z.logger.Fatalf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
+	z.with(ctx).Fatalf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
 }
 
 func (z ZapLogger) DPanic(ctx context.Context, args ...interface{}) {
 	args = append([]interface{}{fmt.Sprintf(" [ServerIP:%s,NodeID:%d] ", shared.GetInternalIP(), shared.GetNodeID())}, args...)
-	z.logger.DPanic(args...)
+	z.with(ctx).DPanic(args...)
 }
 
 func (z ZapLogger) DPanicf(ctx context.Context, template string, args ...interface{}) {
-	z.logger.DPanicf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
+	z.with(ctx).DPanicf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
 }
 
 func (z ZapLogger) Panic(ctx context.Context, args ...interface{}) {
 	args = append([]interface{}{fmt.Sprintf(" [ServerIP:%s,NodeID:%d] ", shared.GetInternalIP(), shared.GetNodeID())}, args...)
-	z.logger.Panic(args...)
+	z.with(ctx).Panic(args...)
 }
 
 func (z ZapLogger) Panicf(ctx context.Context, template string, args ...interface{}) {
-	z.logger.Panicf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
+	z.with(ctx).Panicf(" [ServerIP:%s,NodeID:%d] "+template, append([]interface{}{shared.GetInternalIP(), shared.GetNodeID()}, args...)...)
+}
+
+// Sync 将缓存的日志写入文件,退出前调用
+func (z ZapLogger) Sync() error {
+	return z.logger.Sync()
+}
+
+// with 附加ctx中的日志字段,如pid,uid,cid,affair_id
+func (z ZapLogger) with(ctx context.Context) *zap.SugaredLogger {
+	if kvs := logfield.KeyValues(ctx); len(kvs) > 0 {
+		return z.logger.With(kvs...)
+	}
+	return z.logger
 }
 
 func (z ZapLogger) initLogger() *zap.SugaredLogger {
@@ -108,27 +169,6 @@ func (z ZapLogger) initLogger() *zap.SugaredLogger {
 
 	encoder := encoder()
 
-	var level zapcore.Level
-
-	switch z.options.configLog.Level {
-	case "debug":
-		level = zapcore.DebugLevel
-	case "info":
-		level = zapcore.InfoLevel
-	case "warn":
-		level = zapcore.WarnLevel
-	case "error":
-		level = zapcore.ErrorLevel
-	case "dpanic":
-		level = zapcore.DPanicLevel
-	case "panic":
-		level = zapcore.PanicLevel
-	case "fatal":
-		level = zapcore.FatalLevel
-	default:
-		level = zapcore.InfoLevel
-	}
-
 	//cores := []zapcore.Core{
 	//	zapcore.NewCore(encoder, errorWriter, zap.ErrorLevel),
 	//}
@@ -136,8 +176,9 @@ func (z ZapLogger) initLogger() *zap.SugaredLogger {
 	//	cores = append(cores, zapcore.NewCore(encoder, partitionWriter, level))
 	//}
 
+	// 级别由各模块的日志自行判断,dpanic及以上级别始终输出
 	cores := []zapcore.Core{
-		zapcore.NewCore(encoder, writer, level),
+		zapcore.NewCore(encoder, writer, zapcore.DebugLevel),
 	}
 
 	tee := zapcore.NewTee(cores...)
@@ -219,28 +260,41 @@ func partitionWriter(config config.Log) zapcore.WriteSyncer {
 			lc.Path = lc.Path[:len(lc.Path)-1]
 		}
 		lc.Path = lc.Path + "/normal.log"
+	} else {
+		lc.Path = os.TempDir() + "/ws-cluster/normal.log"
 	}
 
-	lumberJackLogger := &lumberjack.Logger{
-		Filename:   lc.Path,
-		MaxSize:    lc.MaxSize,
-		MaxBackups: lc.MaxBackups,
-		MaxAge:     lc.MaxAge,
-		Compress:   lc.Compress,
+	file, err := logfile.New(logfile.Config{
+		Filename:       lc.Path,
+		Rotate:         lc.Rotate,
+		MaxSize:        lc.MaxSize,
+		RotateInterval: time.Duration(lc.RotateInterval) * time.Hour,
+		MaxAge:         lc.MaxAge,
+		MaxBackups:     lc.MaxBackups,
+		Compress:       lc.Compress,
+	})
+	if err != nil {
+		// 日志文件无法打开时(如目录没有写权限)输出到标准错误,不影响服务启动
+		fmt.Fprintf(os.Stderr, "open log file %s error:%v, log to stderr\n", lc.Path, err)
+		return zapcore.Lock(os.Stderr)
 	}
+	// 收到SIGHUP或检测到文件被外部移动,截断时重新打开,日志无法写入自身,错误输出到标准错误
+	go file.Watch(context.Background(), time.Second, func(err error) {
+		fmt.Fprintf(os.Stderr, "log file %s reopen error:%v\n", lc.Path, err)
+	}, syscall.SIGHUP)
 
 	syncWriter := &zapcore.BufferedWriteSyncer{
-		WS:   zapcore.AddSync(lumberJackLogger),
+		WS:   file,
 		Size: 4096,
 	}
 
-	writers := []io.Writer{syncWriter}
+	writers := []zapcore.WriteSyncer{syncWriter}
 
 	if lc.Print {
-		writers = append(writers, os.Stdout)
+		writers = append(writers, zapcore.AddSync(os.Stdout))
 	}
 
-	return zapcore.AddSync(io.MultiWriter(writers...))
+	return zapcore.NewMultiWriteSyncer(writers...)
 }
 
 //func errorWriter(config config.Config) zapcore.WriteSyncer {
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/mtgnorton/ws-cluster/core/cluster"
+	"github.com/mtgnorton/ws-cluster/core/tap"
 	"github.com/mtgnorton/ws-cluster/ws/server"
 
 	"github.com/mtgnorton/ws-cluster/shared"
@@ -15,6 +18,7 @@ import (
 
 	"github.com/mtgnorton/ws-cluster/tools/swagger"
 	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
 
 	"github.com/gogf/gf/v2/frame/g"
 	swaggerFiles "github.com/swaggo/files"
@@ -23,6 +27,7 @@ import (
 
 	"github.com/mtgnorton/ws-cluster/config"
 	"github.com/mtgnorton/ws-cluster/docs"
+	"github.com/mtgnorton/ws-cluster/logger"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/sasha-s/go-deadlock"
@@ -46,6 +51,8 @@ func main() {
 	}
 	toolServer(c)
 	defer sentry.Flush(time.Second * 3)
+	cluster.DefaultCluster.Start()
+	tap.DefaultTap.Start()
 	wsServerInstance := server.New()
 	httpServerInstance := httpServer.New()
 	go wsServerInstance.Run()
@@ -72,7 +79,15 @@ func main() {
 		fmt.Printf("WebSocket服务器关闭失败: %v\n", err)
 	}
 
+	// 导出缓存的span
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := wstrace.DefaultTracer.Shutdown(ctx); err != nil {
+		fmt.Printf("链路追踪关闭失败: %v\n", err)
+	}
+
 	fmt.Println("服务已安全关闭")
+	_ = logger.DefaultLogger.Sync()
 }
 
 func toolServer(c config.Config) {
@@ -94,4 +109,17 @@ func toolServer(c config.Config) {
 		wsprometheus.DefaultPrometheus.Init()
 	}
 
+	if traceConfig := c.Values().Trace; traceConfig.Endpoint != "" {
+		err := wstrace.DefaultTracer.Init(
+			wstrace.WithEndpoint(traceConfig.Endpoint),
+			wstrace.WithInsecure(traceConfig.Insecure),
+			wstrace.WithSampleRate(traceConfig.SampleRate),
+			wstrace.WithServiceName(traceConfig.ServiceName),
+			wstrace.WithNode(shared.GetNodeID()),
+		)
+		if err != nil {
+			fmt.Printf("链路追踪初始化失败: %v\n", err)
+		}
+	}
+
 }
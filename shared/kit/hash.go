@@ -0,0 +1,36 @@
+// Hash 提供哈希相关函数
+package kit
+
+import (
+	"hash/fnv"
+)
+
+// RendezvousPick 使用最高随机权重(rendezvous)哈希从nodes中为key选择一个节点
+// 节点增减时只有原本属于该节点的key会被重新分配,nodes为空时返回空字符串
+func RendezvousPick(key string, nodes []string) string {
+	var (
+		picked    string
+		maxWeight uint64
+	)
+	for i, node := range nodes {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(node))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(key))
+		weight := mix64(h.Sum64())
+		if i == 0 || weight > maxWeight || (weight == maxWeight && node < picked) {
+			picked, maxWeight = node, weight
+		}
+	}
+	return picked
+}
+
+// mix64 对fnv结果再做一次混淆,使相近输入的权重分布更均匀
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
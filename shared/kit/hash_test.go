@@ -0,0 +1,39 @@
+package kit
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRendezvousPick(t *testing.T) {
+	if got := RendezvousPick("uid", nil); got != "" {
+		t.Fatalf("empty nodes got %q", got)
+	}
+	nodes := []string{"1001", "1002", "1003"}
+	reversed := []string{"1003", "1002", "1001"}
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "uid_" + strconv.Itoa(i)
+		picked := RendezvousPick(key, nodes)
+		if picked != RendezvousPick(key, reversed) {
+			t.Fatalf("key %s picked differently when nodes order changed", key)
+		}
+		counts[picked]++
+	}
+	for _, node := range nodes {
+		if counts[node] < 800 {
+			t.Fatalf("distribution is uneven: %v", counts)
+		}
+	}
+
+	// 移除一个节点后,只有原本属于该节点的key会被重新分配
+	remaining := []string{"1001", "1003"}
+	for i := 0; i < 3000; i++ {
+		key := "uid_" + strconv.Itoa(i)
+		before := RendezvousPick(key, nodes)
+		after := RendezvousPick(key, remaining)
+		if before != "1002" && before != after {
+			t.Fatalf("key %s moved from %s to %s", key, before, after)
+		}
+	}
+}
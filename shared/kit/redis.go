@@ -0,0 +1,12 @@
+package kit
+
+import "github.com/redis/go-redis/v9"
+
+// RedisKey 返回 tag+suffix 组成的key,cluster模式下tag作为hash tag,相同tag的key位于同一个slot
+// 其他模式下key保持不变,升级时不影响已有的数据
+func RedisKey(rdb redis.UniversalClient, tag, suffix string) string {
+	if _, ok := rdb.(*redis.ClusterClient); ok {
+		return "{" + tag + "}" + suffix
+	}
+	return tag + suffix
+}
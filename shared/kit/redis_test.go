@@ -0,0 +1,24 @@
+package kit
+
+import (
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisKey(t *testing.T) {
+	single := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer single.Close()
+	if key := RedisKey(single, "snowflake", ":worker:"); key != "snowflake:worker:" {
+		t.Fatalf("single key = %s", key)
+	}
+
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:7000"}})
+	defer cluster.Close()
+	if key := RedisKey(cluster, "snowflake", ":worker:"); key != "{snowflake}:worker:" {
+		t.Fatalf("cluster key = %s", key)
+	}
+	if key := RedisKey(cluster, "ws_queue_stream:1", ""); key != "{ws_queue_stream:1}" {
+		t.Fatalf("cluster stream key = %s", key)
+	}
+}
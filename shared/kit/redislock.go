@@ -15,15 +15,16 @@ type Locker interface {
 
 type UnLocker interface {
 	Unlock(ctx context.Context) error
+	Refresh(ctx context.Context, expiration time.Duration) error
 }
 
 type RedisLocker struct {
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 	prefix      string
 }
 
 type RedisUnLocker struct {
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 	k           string
 	v           string
 }
@@ -41,6 +42,14 @@ else
 end
 `)
 
+var luaRefreshScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+    return redis.call("pexpire", KEYS[1], ARGV[2])
+else
+    return 0
+end
+`)
+
 func (r *RedisLocker) Lock(ctx context.Context, k string, expiration time.Duration) (UnLocker, error) {
 	k = r.prefix + k
 	v, err := GenerateUniqueId()
@@ -77,7 +86,22 @@ func (r *RedisUnLocker) Unlock(ctx context.Context) error {
 	return nil
 }
 
-func NewRedisLocker(redisClient *redis.Client, prefix string) *RedisLocker {
+// Refresh 延长持有的锁的过期时间,锁已经过期或者被其他人持有时返回 ErrLockerNotExist
+func (r *RedisUnLocker) Refresh(ctx context.Context, expiration time.Duration) error {
+	if r == nil {
+		return ErrLockerNotExist
+	}
+	rs, err := luaRefreshScript.Run(ctx, r.redisClient, []string{r.k}, r.v, expiration.Milliseconds()).Result()
+	if err != nil {
+		return err
+	}
+	if v, ok := rs.(int64); !ok || v != 1 {
+		return ErrLockerNotExist
+	}
+	return nil
+}
+
+func NewRedisLocker(redisClient redis.UniversalClient, prefix string) *RedisLocker {
 	return &RedisLocker{
 		redisClient: redisClient,
 		prefix:      prefix,
@@ -59,6 +59,10 @@ func RetryN(n int, execFunc func(context.Context) (interface{}, error), opts ...
 				return
 			}
 		}
+		// 最后一次失败后不再等待
+		if i == n-1 {
+			return
+		}
 		if waitErr := wait(retry.Ctx, retry.Backoff); waitErr != nil {
 			err = waitErr
 			if retry.ErrHandle != nil {
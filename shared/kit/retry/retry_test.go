@@ -0,0 +1,38 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryN(t *testing.T) {
+	t.Run("success_after_retry", func(t *testing.T) {
+		calls := 0
+		v, times := RetryN(3, func(ctx context.Context) (interface{}, error) {
+			calls++
+			if calls < 2 {
+				return nil, errors.New("fail")
+			}
+			return "ok", nil
+		}, WithBackoff(NewBackoff(WithMin(time.Millisecond), WithMax(time.Millisecond))))
+		if v != "ok" || times != 2 {
+			t.Errorf("expected ok after 2 times, got %v after %d times", v, times)
+		}
+	})
+
+	t.Run("no_wait_after_last_attempt", func(t *testing.T) {
+		begin := time.Now()
+		_, times := RetryN(2, func(ctx context.Context) (interface{}, error) {
+			return nil, errors.New("fail")
+		}, WithBackoff(NewBackoff(WithMin(100*time.Millisecond), WithMax(time.Second))))
+		if times != 2 {
+			t.Errorf("expected 2 times, got %d", times)
+		}
+		// 只在两次尝试之间等待一次
+		if cost := time.Since(begin); cost >= 200*time.Millisecond {
+			t.Errorf("expected single backoff wait, cost %v", cost)
+		}
+	})
+}
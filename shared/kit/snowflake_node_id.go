@@ -10,6 +10,7 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// 非cluster模式下使用的key,cluster模式下加上hash tag,保证回收脚本访问的key都在同一个slot
 const (
 	AvailableKey    = "snowflake:worker_ids:available"
 	WorkerKeyPrefix = "snowflake:worker:"
@@ -28,22 +29,26 @@ var (
 )
 
 type NodeIDWorker struct {
-	rdb      *redis.Client
-	workerID int64
-	ctx      context.Context
-	cancel   context.CancelFunc
-	mu       sync.Mutex
-	initOnce sync.Once
+	rdb             redis.UniversalClient
+	availableKey    string
+	workerKeyPrefix string
+	workerID        int64
+	ctx             context.Context
+	cancel          context.CancelFunc
+	mu              sync.Mutex
+	initOnce        sync.Once
 }
 
-func NewNodeIDWorker(rdb *redis.Client) (*NodeIDWorker, error) {
+func NewNodeIDWorker(rdb redis.UniversalClient) (*NodeIDWorker, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	w := &NodeIDWorker{
-		rdb:      rdb,
-		ctx:      ctx,
-		cancel:   cancel,
-		workerID: -1,
+		rdb:             rdb,
+		availableKey:    RedisKey(rdb, "snowflake", ":worker_ids:available"),
+		workerKeyPrefix: RedisKey(rdb, "snowflake", ":worker:"),
+		ctx:             ctx,
+		cancel:          cancel,
+		workerID:        -1,
 	}
 
 	if err := w.initializePool(); err != nil {
@@ -55,7 +60,7 @@ func NewNodeIDWorker(rdb *redis.Client) (*NodeIDWorker, error) {
 func (w *NodeIDWorker) initializePool() error {
 	var initErr error
 	w.initOnce.Do(func() {
-		exists, err := w.rdb.Exists(w.ctx, AvailableKey).Result()
+		exists, err := w.rdb.Exists(w.ctx, w.availableKey).Result()
 		if err != nil {
 			initErr = err
 			return
@@ -66,7 +71,7 @@ func (w *NodeIDWorker) initializePool() error {
 			for i := int64(0); i <= MaxWorkerID; i++ {
 				members[i] = i
 			}
-			if err := w.rdb.SAdd(w.ctx, AvailableKey, members...).Err(); err != nil {
+			if err := w.rdb.SAdd(w.ctx, w.availableKey, members...).Err(); err != nil {
 				initErr = err
 			}
 		}
@@ -83,7 +88,7 @@ func (w *NodeIDWorker) Acquire() (int64, error) {
 	}
 
 	for {
-		id, err := w.rdb.SPop(w.ctx, AvailableKey).Int64()
+		id, err := w.rdb.SPop(w.ctx, w.availableKey).Int64()
 		if err == redis.Nil {
 			if err := w.recycleExpiredWorkers(); err != nil {
 				return -1, err
@@ -94,9 +99,9 @@ func (w *NodeIDWorker) Acquire() (int64, error) {
 			return -1, WrapError(err, ErrRedisConn)
 		}
 
-		key := fmt.Sprintf("%s%d", WorkerKeyPrefix, id)
+		key := fmt.Sprintf("%s%d", w.workerKeyPrefix, id)
 		if ok, err := w.rdb.SetNX(w.ctx, key, "occupied", WorkerTTL).Result(); err != nil {
-			w.rdb.SAdd(w.ctx, AvailableKey, id)
+			w.rdb.SAdd(w.ctx, w.availableKey, id)
 			return -1, err
 		} else if ok {
 			w.workerID = id
@@ -104,7 +109,7 @@ func (w *NodeIDWorker) Acquire() (int64, error) {
 			return id, nil
 		}
 
-		w.rdb.SAdd(w.ctx, AvailableKey, id)
+		w.rdb.SAdd(w.ctx, w.availableKey, id)
 	}
 }
 
@@ -116,12 +121,12 @@ func (w *NodeIDWorker) Release() error {
 		return nil
 	}
 
-	key := fmt.Sprintf("%s%d", WorkerKeyPrefix, w.workerID)
+	key := fmt.Sprintf("%s%d", w.workerKeyPrefix, w.workerID)
 	if err := w.rdb.Del(w.ctx, key).Err(); err != nil {
 		return WrapError(err, ErrRedisConn)
 	}
 
-	if err := w.rdb.SAdd(w.ctx, AvailableKey, w.workerID).Err(); err != nil {
+	if err := w.rdb.SAdd(w.ctx, w.availableKey, w.workerID).Err(); err != nil {
 		return WrapError(err, ErrRedisConn)
 	}
 
@@ -144,7 +149,7 @@ func (w *NodeIDWorker) startRenewal(key string) {
 			}
 			if err := w.rdb.Expire(w.ctx, key, WorkerTTL).Err(); err != nil {
 				// 自动处理续期失败
-				w.rdb.SAdd(w.ctx, AvailableKey, w.workerID)
+				w.rdb.SAdd(w.ctx, w.availableKey, w.workerID)
 				w.workerID = -1
 				w.mu.Unlock()
 				return
@@ -167,8 +172,8 @@ func (w *NodeIDWorker) recycleExpiredWorkers() error {
     return redis.call("SCARD", KEYS[1])
     `
 
-	_, err := w.rdb.Eval(w.ctx, script, []string{AvailableKey},
-		MaxWorkerID, WorkerKeyPrefix,
+	_, err := w.rdb.Eval(w.ctx, script, []string{w.availableKey},
+		MaxWorkerID, w.workerKeyPrefix,
 	).Result()
 
 	if err != nil {
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/mtgnorton/ws-cluster/config"
@@ -11,41 +12,62 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-var defaultRedis *redis.Client
+// NewRedisClient 根据配置的模式创建单实例,哨兵或cluster客户端
+func NewRedisClient(c config.Redis) redis.UniversalClient {
+	switch c.Mode {
+	case "", config.RedisModeSingle:
+		return redis.NewClient(&redis.Options{Addr: c.Addr, Password: c.Password, Username: c.User, DB: c.DB})
+	case config.RedisModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       c.MasterName,
+			SentinelAddrs:    splitAddrs(c.SentinelAddrs),
+			SentinelPassword: c.SentinelPassword,
+			Username:         c.User,
+			Password:         c.Password,
+			DB:               c.DB,
+		})
+	case config.RedisModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{Addrs: splitAddrs(c.Addr), Username: c.User, Password: c.Password})
+	default:
+		panic(fmt.Sprintf("unsupported redis mode:%s", c.Mode))
+	}
+}
+
+func splitAddrs(addrs string) []string {
+	var result []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+var defaultRedis redis.UniversalClient
 
 var redisOnce sync.Once
 
 func setRedis() {
 	redisOnce.Do(func() {
-
-		c := config.DefaultConfig
-
-		defaultRedis = redis.NewClient(&redis.Options{Addr: c.Values().Redis.Addr, Password: c.Values().Redis.Password, Username: c.Values().Redis.User, DB: c.Values().Redis.DB})
-		if defaultRedis == nil {
-			panic("redis not set")
-		}
+		defaultRedis = NewRedisClient(config.DefaultConfig.Values().Redis)
 	})
 }
-func GetRedis() *redis.Client {
+func GetRedis() redis.UniversalClient {
 	setRedis()
 	return defaultRedis
 }
 
-var defaultRedisQueue *redis.Client
+var defaultRedisQueue redis.UniversalClient
 
 var defaultRedisQueueOnce sync.Once
 
 func setDefaultRedisQueue() {
 	defaultRedisQueueOnce.Do(func() {
-		c := config.DefaultConfig
-		defaultRedisQueue = redis.NewClient(&redis.Options{Addr: c.Values().Queue.Redis.Addr, Password: c.Values().Queue.Redis.Password, Username: c.Values().Queue.Redis.User, DB: c.Values().Queue.Redis.DB})
-		if defaultRedisQueue == nil {
-			panic("redis not set")
-		}
+		defaultRedisQueue = NewRedisClient(config.DefaultConfig.Values().Queue.Redis)
 	})
 }
 
-func GetDefaultRedisQueue() *redis.Client {
+func GetDefaultRedisQueue() redis.UniversalClient {
 	setDefaultRedisQueue()
 	return defaultRedisQueue
 }
@@ -92,6 +114,11 @@ func setNodeIDAndWorker(c ...config.Config) {
 		if nodeID > 0 {
 			return
 		}
+		// 单节点部署没有redis,也不需要区分节点
+		if config.DefaultConfig.Values().Standalone() {
+			nodeID = kit.IfElse(config.DefaultConfig.Values().Node > 0, config.DefaultConfig.Values().Node, 1)
+			return
+		}
 
 		nodeIDWorker, err = kit.NewNodeIDWorker(defaultRedis)
 		if err != nil {
@@ -0,0 +1,70 @@
+package wsprometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// wsConnectionClientTypes 没有连接时也输出 ws_connection 的客户端类型,和 client.CType 的名称一致
+var wsConnectionClientTypes = []string{"user", "server"}
+
+// ConnectionCount 项目中某种类型客户端的连接数
+type ConnectionCount struct {
+	PID        string
+	ClientType string
+	Count      int
+}
+
+type connectionSource struct {
+	labelValues []string // 节点和ip
+	counts      func() []ConnectionCount
+}
+
+// connectionCollector 在每次抓取时统计 ws_connection 和 project_connection,连接数总是和客户端管理器一致
+type connectionCollector struct {
+	p           *Prometheus
+	wsDesc      *prometheus.Desc
+	projectDesc *prometheus.Desc
+}
+
+func newConnectionCollector(p *Prometheus) *connectionCollector {
+	return &connectionCollector{
+		p:           p,
+		wsDesc:      prometheus.NewDesc(MetricWsConnection, "current ws connection num.", []string{"node", "ip", "client_type"}, nil),
+		projectDesc: prometheus.NewDesc(MetricProjectConnection, "current ws connection num per project.", []string{"node", "ip", "pid", "client_type"}, nil),
+	}
+}
+
+func (c *connectionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.wsDesc
+	ch <- c.projectDesc
+}
+
+func (c *connectionCollector) Collect(ch chan<- prometheus.Metric) {
+	source := c.p.connections.Load()
+	if source == nil {
+		return
+	}
+	type projectKey struct {
+		pid        string
+		clientType string
+	}
+	var (
+		node, ip = source.labelValues[0], source.labelValues[1]
+		total    = make(map[string]int)
+		projects = make(map[projectKey]int)
+	)
+	// 没有连接时输出0,避免告警规则因序列消失而失效
+	for _, clientType := range wsConnectionClientTypes {
+		total[clientType] = 0
+	}
+	for _, count := range source.counts() {
+		total[count.ClientType] += count.Count
+		projects[projectKey{pid: c.p.PIDLabel(count.PID), clientType: count.ClientType}] += count.Count
+	}
+	for clientType, count := range total {
+		ch <- prometheus.MustNewConstMetric(c.wsDesc, prometheus.GaugeValue, float64(count), node, ip, clientType)
+	}
+	for k, count := range projects {
+		ch <- prometheus.MustNewConstMetric(c.projectDesc, prometheus.GaugeValue, float64(count), node, ip, k.pid, k.clientType)
+	}
+}
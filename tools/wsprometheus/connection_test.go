@@ -0,0 +1,32 @@
+package wsprometheus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConnectionCollectorEmitsZero(t *testing.T) {
+	p := &Prometheus{}
+	counts := []ConnectionCount{{PID: "p1", ClientType: "user", Count: 2}}
+	p.SetConnectionSource([]string{"1", "127.0.0.1"}, func() []ConnectionCount { return counts })
+	collector := newConnectionCollector(p)
+
+	expected := `
+# HELP ws_connection current ws connection num.
+# TYPE ws_connection gauge
+ws_connection{client_type="server",ip="127.0.0.1",node="1"} 0
+ws_connection{client_type="user",ip="127.0.0.1",node="1"} 2
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), MetricWsConnection); err != nil {
+		t.Fatal(err)
+	}
+
+	// 所有连接断开后两种类型都输出0
+	counts = nil
+	expected = strings.Replace(expected, "} 2", "} 0", 1)
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), MetricWsConnection); err != nil {
+		t.Fatal(err)
+	}
+}
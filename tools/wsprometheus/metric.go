@@ -2,6 +2,7 @@ package wsprometheus
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -27,13 +28,6 @@ type Metric struct {
 	collector   prometheus.Collector
 }
 
-func (m *Metric) Reset(labelValues []string) {
-	switch m.Type {
-	case Gauge:
-		m.collector.(*prometheus.GaugeVec).WithLabelValues(labelValues...).Set(0)
-	}
-}
-
 //func (m *Metric) Inc(labelValues []string) (err error) {
 //	switch m.Type {
 //	case Counter:
@@ -60,6 +54,16 @@ func (m *Metric) Add(labelValues []string, value float64) (err error) {
 	}
 }
 
+func (m *Metric) Set(labelValues []string, value float64) (err error) {
+	switch m.Type {
+	case Gauge:
+		m.collector.(*prometheus.GaugeVec).WithLabelValues(labelValues...).Set(value)
+		return
+	default:
+		return fmt.Errorf("metric '%s' not Gauge type", m.Name)
+	}
+}
+
 func (m *Metric) Observe(labelValues []string, value float64) (err error) {
 	switch m.Type {
 	case Histogram:
@@ -119,3 +123,17 @@ func (m *Manager) Add(metric *Metric) error {
 func (m *Manager) Get(name string) *Metric {
 	return m.metrics[name]
 }
+
+// DeleteByLabel 删除所有带有该label的指标中label等于value的序列
+func (m *Manager) DeleteByLabel(name, value string) {
+	for _, metric := range m.metrics {
+		if !slices.Contains(metric.Labels, name) {
+			continue
+		}
+		if vec, ok := metric.collector.(interface {
+			DeletePartialMatch(labels prometheus.Labels) int
+		}); ok {
+			vec.DeletePartialMatch(prometheus.Labels{name: value})
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package pidguard
+
+import (
+	"sort"
+	"sync"
+)
+
+// Other 不单独统计的项目合并后的label
+const Other = "other"
+
+// Guard 限制指标中pid label的取值数量,只有白名单中的项目和连接数最多的前N个项目使用自己的pid,
+// 其他项目合并为 Other,保证prometheus的序列数量有上限
+type Guard struct {
+	topN  int
+	allow map[string]struct{}
+	mu    sync.RWMutex
+	top   map[string]struct{}
+}
+
+// New topN为按连接数单独统计的项目数量,allowlist中的项目总是单独统计且不占用topN的名额
+func New(topN int, allowlist []string) *Guard {
+	g := &Guard{
+		topN:  topN,
+		allow: make(map[string]struct{}, len(allowlist)),
+		top:   make(map[string]struct{}),
+	}
+	for _, pid := range allowlist {
+		if pid != "" {
+			g.allow[pid] = struct{}{}
+		}
+	}
+	return g
+}
+
+// Label 返回项目在指标中使用的label
+func (g *Guard) Label(pid string) string {
+	if pid == "" || pid == Other {
+		return Other
+	}
+	if _, ok := g.allow[pid]; ok {
+		return pid
+	}
+	g.mu.RLock()
+	_, ok := g.top[pid]
+	g.mu.RUnlock()
+	if ok {
+		return pid
+	}
+	return Other
+}
+
+// Update 根据各项目当前的连接数重新选出前N个项目,连接数相同时按pid排序保证结果稳定
+// 返回不再单独统计的项目,调用方需要删除这些项目已有的指标序列
+func (g *Guard) Update(counts map[string]int64) (evicted []string) {
+	candidates := make([]string, 0, len(counts))
+	for pid, count := range counts {
+		if count <= 0 || pid == "" || pid == Other {
+			continue
+		}
+		if _, ok := g.allow[pid]; ok {
+			continue
+		}
+		candidates = append(candidates, pid)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := counts[candidates[i]], counts[candidates[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return candidates[i] < candidates[j]
+	})
+	if len(candidates) > g.topN {
+		candidates = candidates[:max(g.topN, 0)]
+	}
+	top := make(map[string]struct{}, len(candidates))
+	for _, pid := range candidates {
+		top[pid] = struct{}{}
+	}
+
+	g.mu.Lock()
+	for pid := range g.top {
+		if _, ok := top[pid]; !ok {
+			evicted = append(evicted, pid)
+		}
+	}
+	g.top = top
+	g.mu.Unlock()
+	sort.Strings(evicted)
+	return evicted
+}
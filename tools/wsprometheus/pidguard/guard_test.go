@@ -0,0 +1,51 @@
+package pidguard
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGuardLabel(t *testing.T) {
+	g := New(2, []string{"vip"})
+	for _, pid := range []string{"", Other, "1", "2"} {
+		if label := g.Label(pid); label != Other {
+			t.Fatalf("label of %q before update = %s", pid, label)
+		}
+	}
+	if label := g.Label("vip"); label != "vip" {
+		t.Fatalf("allowlist label = %s", label)
+	}
+
+	evicted := g.Update(map[string]int64{"1": 10, "2": 30, "3": 20, "4": 0, "vip": 100})
+	if len(evicted) != 0 {
+		t.Fatalf("evicted = %v", evicted)
+	}
+	want := map[string]string{"1": Other, "2": "2", "3": "3", "4": Other, "vip": "vip", "5": Other}
+	for pid, label := range want {
+		if got := g.Label(pid); got != label {
+			t.Fatalf("label of %s = %s, want %s", pid, got, label)
+		}
+	}
+}
+
+func TestGuardUpdateEvicted(t *testing.T) {
+	g := New(2, nil)
+	g.Update(map[string]int64{"a": 5, "b": 5, "c": 5})
+	if g.Label("a") != "a" || g.Label("b") != "b" || g.Label("c") != Other {
+		t.Fatal("ties should be ordered by pid")
+	}
+
+	evicted := g.Update(map[string]int64{"a": 1, "b": 0, "c": 3, "d": 2})
+	if !reflect.DeepEqual(evicted, []string{"a", "b"}) {
+		t.Fatalf("evicted = %v", evicted)
+	}
+	if g.Label("c") != "c" || g.Label("d") != "d" || g.Label("a") != Other {
+		t.Fatal("top should be replaced")
+	}
+
+	g = New(0, []string{"vip"})
+	g.Update(map[string]int64{"a": 1, "vip": 1})
+	if g.Label("a") != Other || g.Label("vip") != "vip" {
+		t.Fatal("only allowlist should be kept when topN is 0")
+	}
+}
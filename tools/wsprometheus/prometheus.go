@@ -3,7 +3,11 @@ package wsprometheus
 import (
 	"net/http"
 	"sync"
+	"sync/atomic"
 
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus/pidguard"
+
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -23,10 +27,26 @@ const (
 	MetricQueuePublishWaitDuration = "queue_publish_wait_duration" // 统计写入本地发布缓冲区等待时间
 	MetricQueueLagDuration         = "queue_lag_duration"          // 统计消息进入redis后到被消费的等待时间
 	MetricQueueDispatchDuration    = "queue_dispatch_duration"     // 统计单条消息分发处理时间
+	MetricQueueShardLag            = "queue_shard_lag"             // 统计每个分片最近消费的消息进入redis后的等待时间
+	MetricQueueStaleSkip           = "queue_stale_skip"            // 统计超过有效期被跳过的消息数量
+	MetricQueueSpillBytes          = "queue_spill_bytes"           // 统计每个分片本地溢写文件中等待重新写入的字节数
+	MetricQueueSpillDrop           = "queue_spill_drop"            // 统计本地溢写文件中丢弃的消息数量
+	MetricQueueDeadLetter          = "queue_dead_letter"           // 统计写入死信的消息数量
 
 	MetricClientSendDrop              = "client_send_drop"                // 统计客户端发送队列丢弃次数
 	MetricClientSendQueueWaitDuration = "client_send_queue_wait_duration" // 统计客户端发送队列等待时间
 	MetricClientWriteDuration         = "client_write_duration"           // 统计websocket写入耗时
+	MetricMessageE2EDuration          = "message_e2e_duration"            // 统计消息从http或ws入口进入集群到写入连接的耗时
+
+	MetricProjectConnection = "project_connection" // 统计每个项目的连接数
+	MetricProjectPush       = "project_push"       // 统计每个项目推送的消息数量
+	MetricProjectDrop       = "project_drop"       // 统计每个项目丢弃的消息数量
+	MetricProjectBytes      = "project_bytes"      // 统计每个项目收发的字节数
+
+	MetricConnEventDrop = "conn_event_drop" // 统计丢弃的连接事件数量
+
+	MetricWebhookDelivery         = "webhook_delivery"          // 统计webhook投递结果
+	MetricWebhookDeliveryDuration = "webhook_delivery_duration" // 统计webhook单次投递耗时
 )
 
 var DefaultPrometheus = New()
@@ -34,7 +54,9 @@ var DefaultPrometheus = New()
 var once sync.Once
 
 type Prometheus struct {
-	opts Options
+	opts        Options
+	pids        *pidguard.Guard
+	connections atomic.Pointer[connectionSource]
 }
 
 func New(opts ...Option) *Prometheus {
@@ -70,11 +92,43 @@ func (p *Prometheus) GetObserve(metric string, labelValues []string, value float
 	return p.opts.MetricManager.Get(metric).Observe(labelValues, value)
 }
 
+func (p *Prometheus) GetSet(metric string, labelValues []string, value float64) (err error) {
+	if !p.isEnable() {
+		return
+	}
+	return p.opts.MetricManager.Get(metric).Set(labelValues, value)
+}
+
 func (p *Prometheus) Options() Options {
 	return p.opts
 }
 
+// PIDLabel 返回项目在指标中的pid label,不在白名单和连接数前N的项目合并为 other
+func (p *Prometheus) PIDLabel(pid string) string {
+	if p.pids == nil {
+		return pidguard.Other
+	}
+	return p.pids.Label(pid)
+}
+
+// SetConnectionSource 设置抓取时统计连接数的函数,labelValues为节点和ip
+func (p *Prometheus) SetConnectionSource(labelValues []string, counts func() []ConnectionCount) {
+	p.connections.Store(&connectionSource{labelValues: labelValues, counts: counts})
+}
+
+// UpdatePIDs 根据各项目的连接数重新选出单独统计的项目,不再单独统计的项目会删除所有项目维度指标中的序列
+func (p *Prometheus) UpdatePIDs(counts map[string]int64) {
+	if !p.isEnable() || p.pids == nil {
+		return
+	}
+	for _, pid := range p.pids.Update(counts) {
+		p.opts.MetricManager.DeleteByLabel("pid", pid)
+	}
+}
+
 func (p *Prometheus) init() {
+	c := p.opts.Config.Values().Prometheus
+	p.pids = pidguard.New(c.PIDTopN, c.PIDAllowlist)
 
 	_ = p.opts.MetricManager.Add(&Metric{
 		Type:        Counter,
@@ -95,12 +149,8 @@ func (p *Prometheus) init() {
 		Labels:      []string{"url"},
 		Buckets:     []float64{0.1, 0.3, 0.5, 1, 2, 3, 5, 10},
 	})
-	_ = p.opts.MetricManager.Add(&Metric{
-		Type:        Gauge,
-		Name:        MetricWsConnection,
-		Labels:      []string{"node", "ip"},
-		Description: "current ws connection num.",
-	})
+	// 连接数在抓取时从客户端管理器统计
+	prometheus.MustRegister(newConnectionCollector(p))
 
 	_ = p.opts.MetricManager.Add(&Metric{
 		Type:        Counter,
@@ -156,6 +206,36 @@ func (p *Prometheus) init() {
 		Labels:      []string{"node", "ip", "type"},
 		Buckets:     []float64{0.1, 0.5, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
 	})
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Gauge,
+		Name:        MetricQueueShardLag,
+		Description: "queue lag of the latest consumed message per shard.",
+		Labels:      []string{"node", "ip", "shard"},
+	})
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Counter,
+		Name:        MetricQueueStaleSkip,
+		Description: "queue messages skipped because they exceeded the freshness limit of their type.",
+		Labels:      []string{"node", "ip", "type"},
+	})
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Gauge,
+		Name:        MetricQueueSpillBytes,
+		Description: "bytes of messages in the local spill files waiting to be written to the queue per shard.",
+		Labels:      []string{"node", "ip", "shard"},
+	})
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Counter,
+		Name:        MetricQueueSpillDrop,
+		Description: "messages dropped from the local spill files.",
+		Labels:      []string{"node", "ip", "reason"},
+	})
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Counter,
+		Name:        MetricQueueDeadLetter,
+		Description: "queue messages written to the dead letter.",
+		Labels:      []string{"node", "ip", "reason"},
+	})
 	_ = p.opts.MetricManager.Add(&Metric{
 		Type:        Counter,
 		Name:        MetricClientSendDrop,
@@ -176,6 +256,53 @@ func (p *Prometheus) init() {
 		Labels:      []string{"node", "ip", "client_type"},
 		Buckets:     []float64{0.1, 0.5, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
 	})
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Histogram,
+		Name:        MetricMessageE2EDuration,
+		Description: "message latency from edge ingest to websocket write.",
+		Labels:      []string{"node", "ip", "type", "pid"},
+		Buckets:     []float64{1, 5, 10, 20, 50, 100, 200, 500, 1000, 3000, 5000, 10000},
+	})
+
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Counter,
+		Name:        MetricProjectPush,
+		Description: "messages pushed by the business server per project.",
+		Labels:      []string{"node", "ip", "pid", "source"},
+	})
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Counter,
+		Name:        MetricProjectDrop,
+		Description: "messages dropped per project.",
+		Labels:      []string{"node", "ip", "pid", "reason"},
+	})
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Counter,
+		Name:        MetricProjectBytes,
+		Description: "bytes received from and written to ws connections or pushed by http per project.",
+		Labels:      []string{"node", "ip", "pid", "direction"},
+	})
+
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Counter,
+		Name:        MetricConnEventDrop,
+		Description: "connection lifecycle events dropped.",
+		Labels:      []string{"node", "ip", "reason"},
+	})
+
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Counter,
+		Name:        MetricWebhookDelivery,
+		Description: "webhook delivery count by result.",
+		Labels:      []string{"node", "ip", "event", "result"},
+	})
+	_ = p.opts.MetricManager.Add(&Metric{
+		Type:        Histogram,
+		Name:        MetricWebhookDeliveryDuration,
+		Description: "webhook single delivery duration.",
+		Labels:      []string{"node", "ip", "event"},
+		Buckets:     []float64{5, 10, 20, 50, 100, 200, 500, 1000, 3000, 5000},
+	})
 
 	http.Handle(p.opts.Config.Values().Prometheus.Path, promhttp.Handler())
 	go func() {
@@ -0,0 +1,70 @@
+package wstrace
+
+import (
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type Option func(*Options)
+
+type Options struct {
+	endpoint    string
+	insecure    bool
+	sampleRate  float64
+	serviceName string
+	node        int64
+	exporter    sdktrace.SpanExporter
+}
+
+func NewOptions(opts ...Option) Options {
+	options := Options{
+		sampleRate:  1,
+		serviceName: "ws-cluster",
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+// WithEndpoint OTLP http 接收地址,如 localhost:4318
+func WithEndpoint(endpoint string) Option {
+	return func(o *Options) {
+		o.endpoint = endpoint
+	}
+}
+
+func WithInsecure(insecure bool) Option {
+	return func(o *Options) {
+		o.insecure = insecure
+	}
+}
+
+// WithSampleRate 采样率,0-1,未配置(<=0)时保持默认的全部采样
+func WithSampleRate(sampleRate float64) Option {
+	return func(o *Options) {
+		if sampleRate > 0 {
+			o.sampleRate = sampleRate
+		}
+	}
+}
+
+func WithServiceName(serviceName string) Option {
+	return func(o *Options) {
+		if serviceName != "" {
+			o.serviceName = serviceName
+		}
+	}
+}
+
+func WithNode(node int64) Option {
+	return func(o *Options) {
+		o.node = node
+	}
+}
+
+// WithExporter 使用指定的exporter同步导出,用于单元测试,设置后忽略endpoint
+func WithExporter(exporter sdktrace.SpanExporter) Option {
+	return func(o *Options) {
+		o.exporter = exporter
+	}
+}
@@ -0,0 +1,120 @@
+package wstrace
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const instrumentationName = "github.com/mtgnorton/ws-cluster"
+
+// DefaultTracer 未调用 Init 时不导出任何span,只传递上游的链路上下文
+var DefaultTracer = New()
+
+// Tracer 链路上下文通过消息头在节点之间传递,业务服务端推送的消息可以一直追踪到用户端的连接
+type Tracer struct {
+	opts       Options
+	provider   *sdktrace.TracerProvider // 未启用时为nil
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func New(opts ...Option) *Tracer {
+	return &Tracer{
+		opts:       NewOptions(opts...),
+		tracer:     noop.NewTracerProvider().Tracer(instrumentationName),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+}
+
+// Init 根据配置创建导出器,没有配置endpoint和exporter时保持不导出
+func (t *Tracer) Init(opts ...Option) error {
+	for _, o := range opts {
+		o(&t.opts)
+	}
+	var exportOption sdktrace.TracerProviderOption
+	switch {
+	case t.opts.exporter != nil:
+		exportOption = sdktrace.WithSyncer(t.opts.exporter)
+	case t.opts.endpoint != "":
+		clientOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(t.opts.endpoint)}
+		if t.opts.insecure {
+			clientOptions = append(clientOptions, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), clientOptions...)
+		if err != nil {
+			return err
+		}
+		exportOption = sdktrace.WithBatcher(exporter)
+	default:
+		return nil
+	}
+	t.provider = sdktrace.NewTracerProvider(
+		exportOption,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(t.opts.sampleRate))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", t.opts.serviceName),
+			attribute.Int64("ws_cluster.node", t.opts.node),
+		)),
+	)
+	t.tracer = t.provider.Tracer(instrumentationName)
+	return nil
+}
+
+// Start 开始一个span,ctx中没有上游链路时开始新的链路
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Inject 将ctx中的链路上下文写入消息头,消费的节点通过 Extract 恢复
+func (t *Tracer) Inject(ctx context.Context, m *clustermessage.AffairMsg) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+	if m.Header == nil {
+		m.Header = &clustermessage.Header{}
+	}
+	m.Header.Trace = carrier
+}
+
+// Extract 从消息头中恢复上游的链路上下文
+func (t *Tracer) Extract(ctx context.Context, m *clustermessage.AffairMsg) context.Context {
+	if m.Header == nil || len(m.Header.Trace) == 0 {
+		return ctx
+	}
+	return t.propagator.Extract(ctx, propagation.MapCarrier(m.Header.Trace))
+}
+
+// ExtractHTTP 从http请求头(traceparent)中恢复业务服务端的链路上下文
+func (t *Tracer) ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return t.propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Shutdown 导出缓存的span后关闭
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
+
+// End 结束span,err不为空时标记为失败
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
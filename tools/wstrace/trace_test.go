@@ -0,0 +1,111 @@
+package wstrace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const upstreamTraceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+func newTestTracer(t *testing.T) (*Tracer, *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := New()
+	if err := tracer.Init(WithExporter(exporter), WithNode(1)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tracer.Shutdown(context.Background()) })
+	return tracer, exporter
+}
+
+func TestTraceAcrossNodes(t *testing.T) {
+	tracerA, exporterA := newTestTracer(t)
+	tracerB, exporterB := newTestTracer(t)
+
+	header := http.Header{}
+	header.Set("traceparent", upstreamTraceparent)
+	ctx, pushSpan := tracerA.Start(tracerA.ExtractHTTP(context.Background(), header), "http.push")
+	publishCtx, publishSpan := tracerA.Start(ctx, "queue.publish")
+	msg := &clustermessage.AffairMsg{Type: clustermessage.TypePush}
+	tracerA.Inject(publishCtx, msg)
+	publishSpan.End()
+	pushSpan.End()
+
+	data, err := clustermessage.PackAffair(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := clustermessage.ParseAffair(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, consumeSpan := tracerB.Start(tracerB.Extract(context.Background(), received), "queue.consume")
+	End(consumeSpan, errors.New("dispatch failed"))
+
+	spansA, spansB := exporterA.GetSpans(), exporterB.GetSpans()
+	if len(spansA) != 2 || len(spansB) != 1 {
+		t.Fatalf("spans a=%d b=%d", len(spansA), len(spansB))
+	}
+	publish, push, consume := spansA[0], spansA[1], spansB[0]
+	if push.SpanContext.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatalf("push span should continue upstream trace, got %s", push.SpanContext.TraceID())
+	}
+	if publish.Parent.SpanID() != push.SpanContext.SpanID() {
+		t.Fatal("publish span should be child of push span")
+	}
+	if consume.SpanContext.TraceID() != push.SpanContext.TraceID() || consume.Parent.SpanID() != publish.SpanContext.SpanID() {
+		t.Fatal("consume span should be child of publish span on the other node")
+	}
+	if consume.Status.Code != codes.Error || len(consume.Events) == 0 {
+		t.Fatalf("consume span should record error, status=%v", consume.Status)
+	}
+}
+
+func TestNoopTracerPropagates(t *testing.T) {
+	tracer := New()
+	ctx, span := tracer.Start(context.Background(), "queue.publish")
+	msg := &clustermessage.AffairMsg{}
+	tracer.Inject(ctx, msg)
+	span.End()
+	if msg.Header != nil {
+		t.Fatal("no trace should be injected without upstream trace")
+	}
+
+	header := http.Header{}
+	header.Set("traceparent", upstreamTraceparent)
+	ctx, span = tracer.Start(tracer.ExtractHTTP(context.Background(), header), "http.push")
+	tracer.Inject(ctx, msg)
+	span.End()
+	if msg.Header == nil || msg.Header.Trace["traceparent"] == "" {
+		t.Fatal("upstream trace should be propagated by noop tracer")
+	}
+	if got := trace.SpanContextFromContext(tracer.Extract(context.Background(), msg)); got.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatalf("unexpected trace id:%s", got.TraceID())
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 配置文件中没有 sample_rate 时不应该关闭采样
+func TestZeroSampleRateKeepsDefault(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := New()
+	if err := tracer.Init(WithExporter(exporter), WithSampleRate(0)); err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Shutdown(context.Background())
+	_, span := tracer.Start(context.Background(), "http.push")
+	span.End()
+	if got := len(exporter.GetSpans()); got != 1 {
+		t.Fatalf("spans = %d, want 1", got)
+	}
+}
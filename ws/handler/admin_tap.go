@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/tap"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// adminTap 管理端连接上的一个订阅
+type adminTap struct {
+	cid    string
+	cancel context.CancelFunc
+}
+
+// handleMsgFromAdmin 管理端订阅或取消订阅实时消息
+//
+//	订阅 {"type":"tap","ack_id":"1","payload":{"pid":"77","uid":"1","cid":"","types":["push"],"sample":0.5}}
+//	应答 {"ack_id":"1","code":1,"msg":"success","payload":{"tap_id":"xxx"}}
+//	消息记录 {"type":"tap_event","payload":{"tap_id":"xxx","stage":"deliver","node":1,"since_ingest_ms":3.2,...}}
+//	取消订阅 {"type":"tap_stop","payload":{"tap_id":"xxx"}},tap_id为空时取消该连接上的所有订阅,订阅结束后集群发送同样格式的消息
+func (w *WsHandler) handleMsgFromAdmin(ctx context.Context, c client.Client, msg *clustermessage.AffairMsg) {
+	switch msg.Type {
+	case clustermessage.TypeTap:
+		w.startTap(ctx, c, msg)
+	case clustermessage.TypeTapStop:
+		payload := struct {
+			TapID string `json:"tap_id"`
+		}{}
+		_ = decodePayload(msg.Payload, &payload)
+		w.stopTaps(c.GetCID(), payload.TapID)
+		if msg.AckID != "" {
+			c.Send(ctx, clustermessage.NewAck(msg.AckID))
+		}
+	default:
+		if msg.AckID != "" {
+			c.Send(ctx, clustermessage.NewErrorAck(msg.AckID, "unsupported message type"))
+		}
+	}
+}
+
+func (w *WsHandler) startTap(ctx context.Context, c client.Client, msg *clustermessage.AffairMsg) {
+	filter := tap.Filter{}
+	if err := decodePayload(msg.Payload, &filter); err != nil {
+		c.Send(ctx, clustermessage.NewErrorAck(msg.AckID, "payload error:"+err.Error()))
+		return
+	}
+	// 订阅的生命周期和连接无关,由取消订阅,连接断开或到期结束
+	tapCtx, cancel := context.WithCancel(w.opts.ctx)
+	tapID, done, err := w.opts.tap.Subscribe(tapCtx, filter, func(e *tap.Event) {
+		c.Send(tapCtx, &clustermessage.AffairMsg{Type: clustermessage.TypeTapEvent, Payload: e})
+	})
+	if err != nil {
+		cancel()
+		w.opts.logger.Warnf(ctx, "WsHandler-Tap subscribe error:%v", err)
+		c.Send(ctx, clustermessage.NewErrorAck(msg.AckID, err.Error()))
+		return
+	}
+	w.taps.Store(tapID, &adminTap{cid: c.GetCID(), cancel: cancel})
+	go func() {
+		<-done
+		cancel()
+		w.taps.Delete(tapID)
+		c.Send(context.Background(), &clustermessage.AffairMsg{Type: clustermessage.TypeTapStop, Payload: map[string]string{"tap_id": tapID}})
+	}()
+
+	resp := clustermessage.NewSuccessRespWithPayload(map[string]string{"tap_id": tapID})
+	resp.AckID = msg.AckID
+	c.Send(ctx, resp)
+}
+
+// stopTaps 取消连接上的订阅,tapID为空时取消所有订阅
+func (w *WsHandler) stopTaps(cid string, tapID string) {
+	w.taps.Range(func(key, value any) bool {
+		t := value.(*adminTap)
+		if t.cid == cid && (tapID == "" || key.(string) == tapID) {
+			t.cancel()
+		}
+		return true
+	})
+}
+
+func decodePayload(payload interface{}, v interface{}) error {
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, v)
+}
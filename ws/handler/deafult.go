@@ -2,15 +2,25 @@ package handler
 
 import (
 	"context"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/mtgnorton/ws-cluster/clustermessage"
 	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/core/tap"
+	"github.com/mtgnorton/ws-cluster/core/tap/tapevent"
+	"github.com/mtgnorton/ws-cluster/shared"
 	"github.com/mtgnorton/ws-cluster/shared/kit"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type WsHandler struct {
 	opts *Options
+	taps sync.Map // 管理端的订阅 key:tapID value:*adminTap
 }
 
 func NewWsHandler(opts ...Option) *WsHandler {
@@ -65,6 +75,7 @@ func (w *WsHandler) sendClientsLoop() {
 					},
 					To: nil,
 				}
+				queue.StampHeader(&msg)
 				err := w.opts.queue.Publish(ctx, &msg)
 				if err != nil {
 					logger.Warnf(ctx, "WsHandler-sendClientsLoop publish error %v", err)
@@ -85,8 +96,22 @@ func (w *WsHandler) Handle(ctx context.Context, c client.Client, msg *clustermes
 		c.Send(ctx, clustermessage.NewHeartResp(msg, c.GetCID()))
 		return
 	}
+	// 连接发送的消息头只保留链路上下文,消息ID等由集群重新附加
+	ctx = w.opts.tracer.Extract(ctx, msg)
+	msg.Header = nil
+	ctx, span := w.opts.tracer.Start(ctx, "ws.handle",
+		attribute.String("type", string(msg.Type)), attribute.String("cid", c.GetCID()), attribute.String("client_type", c.Type().String()))
+	defer span.End()
 
 	if msg.Type == clustermessage.TypeConnect || msg.Type == clustermessage.TypeDisconnect {
+		if c.Type() == client.CTypeAdmin && msg.Type == clustermessage.TypeDisconnect {
+			w.stopTaps(c.GetCID(), "")
+			return
+		}
+		if c.Type() == client.CTypeServer {
+			w.handleServerPresence(ctx, c, msg.Type)
+			return
+		}
 		if c.Type() != client.CTypeUser {
 			return
 		}
@@ -102,6 +127,8 @@ func (w *WsHandler) Handle(ctx context.Context, c client.Client, msg *clustermes
 	case client.CTypeServer:
 		msg.Type = clustermessage.TypePush
 		w.handleMsgFromServer(ctx, c, msg)
+	case client.CTypeAdmin:
+		w.handleMsgFromAdmin(ctx, c, msg)
 	}
 
 }
@@ -111,19 +138,24 @@ func (w *WsHandler) handleMsgFromServer(ctx context.Context, c client.Client, ms
 
 	var (
 		logger = w.opts.logger
-		queue  = w.opts.queue
+		q      = w.opts.queue
 	)
 	if msg.To == nil {
 		logger.Warnf(ctx, "WsHandler-FromServer msg.To is nil")
 		return
 	}
 	_, _, msg.To.PID = c.GetIDs()
+	queue.StampHeader(msg)
+	w.opts.tap.Observe(ctx, tap.Point{Stage: tapevent.StageIngest, Source: "ws", Msg: msg})
 
-	err := queue.Publish(ctx, msg)
+	err := q.Publish(ctx, msg)
 	if err != nil {
 		logger.Warnf(ctx, "WsHandler-FromServer publish error %v", err)
+		w.sendPublishError(ctx, c, msg, err)
 		return
 	}
+	p := w.opts.prometheus
+	_ = p.GetAdd(wsprometheus.MetricProjectPush, []string{strconv.FormatInt(shared.GetNodeID(), 10), shared.GetInternalIP(), p.PIDLabel(msg.To.PID), "ws"}, 1)
 	if msg.AckID != "" {
 		c.Send(ctx, clustermessage.NewAck(msg.AckID))
 	}
@@ -138,12 +170,45 @@ func (w *WsHandler) handleMsgFromUser(ctx context.Context, c client.Client, msg
 		UID: uid,
 		CID: cid,
 	}
+	queue.StampHeader(msg)
+	w.opts.tap.Observe(ctx, tap.Point{Stage: tapevent.StageIngest, Source: "ws", Msg: msg})
+	w.opts.webhook.Notify(ctx, msg)
+	if msg.Type == clustermessage.TypeRequest {
+		if w.handleNoServer(ctx, c, msg) {
+			return
+		}
+		// 只有用户请求需要选择服务端,上下线事件仍然广播给所有服务端
+		w.pickServer(ctx, msg)
+	}
 	err := w.opts.queue.Publish(ctx, msg)
 	if err != nil {
 		w.opts.logger.Warnf(ctx, "WsHandler-FromUser user publish error %v", err)
+		w.sendPublishError(ctx, c, msg, err)
 		return
 	}
 	if msg.AckID != "" {
 		c.Send(ctx, clustermessage.NewAck(msg.AckID))
 	}
 }
+
+// sendPublishError 消息没有进入队列时告知发送方,发送方可以根据ack_id重试
+func (w *WsHandler) sendPublishError(ctx context.Context, c client.Client, msg *clustermessage.AffairMsg, err error) {
+	if msg.AckID == "" {
+		return
+	}
+	if queue.IsPublishDropped(err) {
+		c.Send(ctx, clustermessage.NewErrorAck(msg.AckID, "service busy, please retry"))
+		return
+	}
+	c.Send(ctx, clustermessage.NewErrorAck(msg.AckID, "publish message error"))
+}
+
+// pickServer 按项目的分发方式为用户消息选择目标服务端,选择失败时广播给所有服务端
+func (w *WsHandler) pickServer(ctx context.Context, msg *clustermessage.AffairMsg) {
+	target, err := w.opts.cluster.PickServer(ctx, msg.Source.PID, msg.Source.UID)
+	if err != nil {
+		w.opts.logger.Warnf(ctx, "WsHandler-FromUser pick server pid:%s error:%v", msg.Source.PID, err)
+		return
+	}
+	msg.Target = target
+}
@@ -4,26 +4,44 @@ import (
 	"context"
 
 	"github.com/mtgnorton/ws-cluster/config"
+	"github.com/mtgnorton/ws-cluster/core/cluster"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
 	"github.com/mtgnorton/ws-cluster/core/manager"
 	"github.com/mtgnorton/ws-cluster/core/queue"
+	"github.com/mtgnorton/ws-cluster/core/tap"
+	"github.com/mtgnorton/ws-cluster/core/webhook"
 	"github.com/mtgnorton/ws-cluster/logger"
+	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
+	"github.com/mtgnorton/ws-cluster/tools/wstrace"
 )
 
 type Option func(*Options)
 
 type Options struct {
-	ctx     context.Context
-	manager manager.Manager
-	logger  logger.Logger
-	queue   queue.Queue
+	ctx        context.Context
+	manager    manager.Manager
+	logger     logger.Logger
+	queue      queue.Queue
+	webhook    *webhook.Webhook
+	cluster    *cluster.Cluster
+	deadLetter *deadletter.DeadLetter
+	tracer     *wstrace.Tracer
+	prometheus *wsprometheus.Prometheus
+	tap        *tap.Tap
 }
 
 func NewOptions(opts ...Option) *Options {
 	options := &Options{
-		ctx:     context.Background(),
-		manager: manager.DefaultManager,
-		logger:  logger.DefaultLogger,
-		queue:   queue.GetQueueInstance(config.DefaultConfig),
+		ctx:        context.Background(),
+		manager:    manager.DefaultManager,
+		logger:     logger.DefaultLogger.WithModule(logger.ModuleWsServer),
+		queue:      queue.GetQueueInstance(config.DefaultConfig),
+		webhook:    webhook.DefaultWebhook,
+		cluster:    cluster.DefaultCluster,
+		deadLetter: deadletter.DefaultDeadLetter,
+		tracer:     wstrace.DefaultTracer,
+		prometheus: wsprometheus.DefaultPrometheus,
+		tap:        tap.DefaultTap,
 	}
 	for _, o := range opts {
 		o(options)
@@ -53,3 +71,27 @@ func WithQueue(q queue.Queue) Option {
 		o.queue = q
 	}
 }
+
+func WithWebhook(w *webhook.Webhook) Option {
+	return func(o *Options) {
+		o.webhook = w
+	}
+}
+
+func WithCluster(c *cluster.Cluster) Option {
+	return func(o *Options) {
+		o.cluster = c
+	}
+}
+
+func WithDeadLetter(d *deadletter.DeadLetter) Option {
+	return func(o *Options) {
+		o.deadLetter = d
+	}
+}
+
+func WithPrometheus(p *wsprometheus.Prometheus) Option {
+	return func(o *Options) {
+		o.prometheus = p
+	}
+}
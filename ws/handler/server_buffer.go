@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/mtgnorton/ws-cluster/clustermessage"
+	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/core/cluster"
+	"github.com/mtgnorton/ws-cluster/core/deadletter"
+)
+
+// handleServerPresence 服务端连接时登记到集群并投递缓存的请求,断开时从集群注销
+func (w *WsHandler) handleServerPresence(ctx context.Context, c client.Client, msgType clustermessage.Type) {
+	var (
+		logger = w.opts.logger
+		pid    = c.GetPID()
+	)
+	if msgType == clustermessage.TypeDisconnect {
+		if err := w.opts.cluster.LeaveServer(ctx, c); err != nil {
+			logger.Warnf(ctx, "WsHandler-ServerPresence leave pid:%s cid:%s error:%v", pid, c.GetCID(), err)
+		}
+		return
+	}
+	buffering := w.opts.cluster.BufferPolicy(pid) == cluster.BufferPolicyBuffer
+	if buffering {
+		// 先标记排空再登记服务端,其他节点看到服务端时新的请求仍然进入缓存
+		if err := w.opts.cluster.StartDrain(ctx, pid); err != nil {
+			logger.Warnf(ctx, "WsHandler-ServerPresence start drain pid:%s error:%v", pid, err)
+		}
+	}
+	if err := w.opts.cluster.JoinServer(ctx, c); err != nil {
+		logger.Warnf(ctx, "WsHandler-ServerPresence join pid:%s cid:%s error:%v", pid, c.GetCID(), err)
+	}
+	if !buffering {
+		return
+	}
+	go w.drainUntilEmpty(w.opts.ctx, pid)
+}
+
+// drainUntilEmpty 排空缓存直到缓存为空后清除排空标记,之后新的请求直接进入队列
+// 其他节点缓存的服务端列表在 CacheTTL 内可能仍为空,会继续写入缓存,因此至少间隔 CacheTTL 后才清除标记
+func (w *WsHandler) drainUntilEmpty(ctx context.Context, pid string) {
+	var (
+		logger   = w.opts.logger
+		interval = 2 * w.opts.cluster.Options().CacheTTL
+		deadline = time.Now().Add(w.opts.cluster.BufferTTL())
+	)
+	w.drainBuffer(ctx, pid)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		w.drainBuffer(ctx, pid)
+		finished, err := w.opts.cluster.FinishDrain(ctx, pid)
+		if err != nil {
+			logger.Warnf(ctx, "WsHandler-DrainBuffer pid:%s finish error:%v", pid, err)
+		}
+		if finished {
+			return
+		}
+		// 排空标记在缓存的有效期后自动过期
+		if time.Now().After(deadline) {
+			logger.Warnf(ctx, "WsHandler-DrainBuffer pid:%s not finished in %v", pid, w.opts.cluster.BufferTTL())
+			return
+		}
+	}
+}
+
+func (w *WsHandler) drainBuffer(ctx context.Context, pid string) {
+	logger := w.opts.logger
+	count, err := w.opts.cluster.DrainBuffer(ctx, pid, func(ctx context.Context, message []byte) error {
+		msg, err := clustermessage.ParseAffair(message)
+		if err != nil {
+			// 无法解析的请求记录到死信后从缓存中删除,避免阻塞后面的请求
+			logger.Warnf(ctx, "WsHandler-DrainBuffer pid:%s parse error:%v", pid, err)
+			w.opts.deadLetter.Add(ctx, &deadletter.Entry{
+				Reason:  deadletter.ReasonDecode,
+				Error:   err.Error(),
+				Source:  "server_buffer:" + pid,
+				Message: string(message),
+			})
+			return nil
+		}
+		w.pickServer(ctx, msg)
+		return w.opts.queue.Publish(ctx, msg)
+	})
+	if err != nil {
+		logger.Warnf(ctx, "WsHandler-DrainBuffer pid:%s delivered:%d error:%v", pid, count, err)
+		return
+	}
+	if count > 0 {
+		logger.Infof(ctx, "WsHandler-DrainBuffer pid:%s delivered:%d", pid, count)
+	}
+}
+
+// handleNoServer 集群中没有该项目的服务端时按策略缓存或拒绝用户请求,返回true表示请求已处理
+func (w *WsHandler) handleNoServer(ctx context.Context, c client.Client, msg *clustermessage.AffairMsg) bool {
+	var (
+		logger = w.opts.logger
+		pid    = msg.Source.PID
+		policy = w.opts.cluster.BufferPolicy(pid)
+	)
+	if policy == cluster.BufferPolicyNone {
+		return false
+	}
+	hasServer, err := w.opts.cluster.HasServer(ctx, pid)
+	if err != nil {
+		// 无法判断时按原有流程进入队列
+		logger.Warnf(ctx, "WsHandler-FromUser check server pid:%s error:%v", pid, err)
+		return false
+	}
+	if hasServer {
+		return policy == cluster.BufferPolicyBuffer && w.bufferWhileDraining(ctx, c, msg)
+	}
+	if policy == cluster.BufferPolicyReject {
+		if msg.AckID != "" {
+			c.Send(ctx, clustermessage.NewErrorAck(msg.AckID, "service unavailable"))
+		}
+		return true
+	}
+	message, err := clustermessage.PackAffair(msg)
+	if err == nil {
+		err = w.opts.cluster.BufferRequest(ctx, pid, message)
+	}
+	if err != nil {
+		logger.Warnf(ctx, "WsHandler-FromUser buffer request pid:%s error:%v", pid, err)
+		if msg.AckID != "" {
+			c.Send(ctx, clustermessage.NewErrorAck(msg.AckID, "service unavailable"))
+		}
+		return true
+	}
+	if msg.AckID != "" {
+		c.Send(ctx, clustermessage.NewAck(msg.AckID))
+	}
+	return true
+}
+
+// bufferWhileDraining 服务端刚连接,缓存的请求还没有投递完时新的请求继续进入缓存,返回true表示请求已处理
+func (w *WsHandler) bufferWhileDraining(ctx context.Context, c client.Client, msg *clustermessage.AffairMsg) bool {
+	pid := msg.Source.PID
+	message, err := clustermessage.PackAffair(msg)
+	if err != nil {
+		return false
+	}
+	buffered, err := w.opts.cluster.BufferIfDraining(ctx, pid, message)
+	if err != nil {
+		// 无法判断时直接进入队列
+		w.opts.logger.Warnf(ctx, "WsHandler-FromUser buffer while draining pid:%s error:%v", pid, err)
+		return false
+	}
+	if buffered && msg.AckID != "" {
+		c.Send(ctx, clustermessage.NewAck(msg.AckID))
+	}
+	return buffered
+}
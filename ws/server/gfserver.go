@@ -5,8 +5,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"sync"
-	"sync/atomic"
+	"strconv"
 	"time"
 
 	"github.com/mtgnorton/ws-cluster/shared"
@@ -14,6 +13,7 @@ import (
 
 	"github.com/mtgnorton/ws-cluster/clustermessage"
 	"github.com/mtgnorton/ws-cluster/core/client"
+	"github.com/mtgnorton/ws-cluster/logger/logfield"
 	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
 	"github.com/mtgnorton/ws-cluster/tools/wssentry"
 
@@ -24,18 +24,16 @@ import (
 )
 
 type gfServer struct {
-	opts         Options
-	server       *ghttp.Server
-	sentry       *wssentry.Handler
-	onlineNumber sync.Map
+	opts   Options
+	server *ghttp.Server
+	sentry *wssentry.Handler
 }
 
 func New(opts ...Option) Server {
 	return &gfServer{
-		opts:         NewOptions(opts...),
-		server:       g.Server("ws"),
-		sentry:       wssentry.GfSentry,
-		onlineNumber: sync.Map{},
+		opts:   NewOptions(opts...),
+		server: g.Server("ws"),
+		sentry: wssentry.GfSentry,
 	}
 }
 
@@ -68,6 +66,7 @@ func (s *gfServer) Run() {
 	if s.opts.config.Values().Router.Enable {
 		go s.registerToRegistryLoop()
 	}
+	s.opts.prometheus.SetConnectionSource([]string{strconv.FormatInt(shared.GetNodeID(), 10), shared.GetInternalIP()}, s.connectionCounts)
 	go s.printOnlineNumber()
 	s.server.Run()
 
@@ -96,7 +95,7 @@ func (s *gfServer) connect(r *ghttp.Request) {
 		_ = socket.Close()
 		logger.Debugf(ctx, "Websocket token is error:%v", err)
 		r.Exit()
-	} else if !s.opts.checking.IsExist(userData.PID) {
+	} else if userData.ClientType != int(client.CTypeAdmin) && !s.opts.checking.IsExist(userData.PID) { // 管理端不属于任何项目,不校验pid
 		socket, err = r.WebSocket()
 		if err != nil {
 			logger.Debugf(ctx, "Websocket err:%v", err)
@@ -128,7 +127,11 @@ func (s *gfServer) connect(r *ghttp.Request) {
 
 	// claims, err := shared.DefaultJwtWs.Parse(token)
 
+	nodeID := shared.GetNodeID()
+	ctx = logfield.WithNode(logfield.WithUID(logfield.WithPID(ctx, userData.PID), userData.UID), nodeID)
 	c := client.NewClient(ctx, userData.UID, userData.PID, client.CType(userData.ClientType), socket.Conn)
+	cID, _, _ := c.GetIDs()
+	ctx = logfield.WithCID(ctx, cID)
 
 	s.opts.manager.Join(ctx, c)
 
@@ -136,17 +139,14 @@ func (s *gfServer) connect(r *ghttp.Request) {
 		Type: clustermessage.TypeConnect,
 	})
 
-	cID, _, _ := c.GetIDs()
 	logger.Debugf(ctx, "new client connect:%s", cID)
+	remoteIP, userAgent := r.GetClientIp(), r.UserAgent()
+	s.opts.connEvent.Connected(ctx, c, remoteIP, userAgent)
 
-	s.addMetrics()
-
-	nodeID := shared.GetNodeID()
 	connectMsg := fmt.Sprintf("connect to node:%d success,clientID:%s", nodeID, cID)
 	c.Send(ctx, clustermessage.NewSuccessResp(connectMsg))
 
-	counter, _ := s.onlineNumber.LoadOrStore(userData.PID, &atomic.Int64{})
-	counter.(*atomic.Int64).Add(1)
+	nodeLabel, serverIP := strconv.FormatInt(nodeID, 10), shared.GetInternalIP()
 
 	for {
 		//if hub := wssentry.GetHubFromContext(r); hub != nil {
@@ -161,17 +161,12 @@ func (s *gfServer) connect(r *ghttp.Request) {
 			s.opts.handler.Handle(ctx, c, &clustermessage.AffairMsg{
 				Type: clustermessage.TypeDisconnect,
 			})
-			nodeID := shared.GetNodeID()
-			serverIP := shared.GetInternalIP()
-			err = s.opts.prometheus.GetAdd(wsprometheus.MetricWsConnection, []string{fmt.Sprintf("%d", nodeID), serverIP}, -1)
-			if err != nil {
-				logger.Infof(ctx, "Websocket GetAdd err: %v", err)
-			}
-			if counter, ok := s.onlineNumber.Load(userData.PID); ok {
-				counter.(*atomic.Int64).Add(-1)
-			}
+			s.opts.connEvent.Closed(ctx, c, remoteIP, userAgent)
 			return
 		}
+		c.AddInbound(len(msgBytes))
+		p := s.opts.prometheus
+		_ = p.GetAdd(wsprometheus.MetricProjectBytes, []string{nodeLabel, serverIP, p.PIDLabel(userData.PID), "in"}, float64(len(msgBytes)))
 		msg, err := clustermessage.ParseAffair(msgBytes)
 		if err != nil {
 			logger.Infof(ctx, "parse err:%v", err)
@@ -216,15 +211,14 @@ func (s *gfServer) registerToRegistryLoop() {
 	}
 }
 
-func (s *gfServer) addMetrics() {
-	// prometheus add metrics
-	p := s.opts.prometheus
-	//_ = p.GetAdd(wsprometheus.MetricRequestTotal, nil, 1)
-	//_ = p.GetAdd(wsprometheus.MetricRequestURLTotal, []string{"ws", "200"}, 1)
-	nodeID := shared.GetNodeID()
-	serverIP := shared.GetInternalIP()
-	_ = p.GetAdd(wsprometheus.MetricWsConnection, []string{fmt.Sprintf("%d", nodeID), serverIP}, 1)
-
+// connectionCounts 从客户端管理器统计各项目的连接数,在prometheus抓取时调用
+func (s *gfServer) connectionCounts() []wsprometheus.ConnectionCount {
+	counts := s.opts.manager.ConnectionCounts(s.opts.ctx)
+	result := make([]wsprometheus.ConnectionCount, 0, len(counts))
+	for _, c := range counts {
+		result = append(result, wsprometheus.ConnectionCount{PID: c.PID, ClientType: c.CType.String(), Count: c.Count})
+	}
+	return result
 }
 
 func (s *gfServer) printOnlineNumber() {
@@ -233,14 +227,17 @@ func (s *gfServer) printOnlineNumber() {
 	for range ticker.C {
 		prompt := "current online number,"
 		total := 0
-		s.onlineNumber.Range(func(key, value any) bool {
-			count := value.(*atomic.Int64).Load()
-			prompt += fmt.Sprintf(" %s:%d,", key, count)
-			total += int(count)
-			return true
-		})
+		counts := make(map[string]int64)
+		for _, c := range s.opts.manager.ConnectionCounts(s.opts.ctx) {
+			counts[c.PID] += int64(c.Count)
+			total += c.Count
+		}
+		for pid, count := range counts {
+			prompt += fmt.Sprintf(" %s:%d,", pid, count)
+		}
 		prompt += fmt.Sprintf(" total:%d", total)
 		s.opts.logger.Infof(s.opts.ctx, prompt)
+		s.opts.prometheus.UpdatePIDs(counts)
 	}
 }
 
@@ -5,6 +5,7 @@ import (
 
 	"github.com/mtgnorton/ws-cluster/config"
 	"github.com/mtgnorton/ws-cluster/core/checking"
+	"github.com/mtgnorton/ws-cluster/core/connevent"
 	"github.com/mtgnorton/ws-cluster/core/manager"
 	"github.com/mtgnorton/ws-cluster/logger"
 	"github.com/mtgnorton/ws-cluster/tools/wsprometheus"
@@ -20,6 +21,7 @@ type Options struct {
 	logger     logger.Logger
 	prometheus *wsprometheus.Prometheus
 	checking   *checking.Checking
+	connEvent  *connevent.Recorder
 	port       int
 }
 
@@ -30,9 +32,10 @@ func NewOptions(opts ...Option) Options {
 		config:     config.DefaultConfig,
 		manager:    manager.DefaultManager,
 		handler:    handler.DefaultHandler,
-		logger:     logger.DefaultLogger,
+		logger:     logger.DefaultLogger.WithModule(logger.ModuleWsServer),
 		prometheus: wsprometheus.DefaultPrometheus,
 		checking:   checking.DefaultChecking,
+		connEvent:  connevent.DefaultRecorder,
 	}
 	for _, o := range opts {
 		o(&options)
@@ -76,3 +79,9 @@ func WithPort(port int) Option {
 		o.port = port
 	}
 }
+
+func WithConnEvent(r *connevent.Recorder) Option {
+	return func(o *Options) {
+		o.connEvent = r
+	}
+}